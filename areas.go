@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/michalswi/osm/geo"
+)
+
+// ClientArea is a polygon or multipolygon location (coverage area, metro region, campus).
+type ClientArea struct {
+	Name      string        `json:"name"`
+	As        string        `json:"as"`
+	Asname    string        `json:"asname"`
+	Details   string        `json:"details"`
+	Tags      []string      `json:"tags"`
+	Category  string        `json:"category"`
+	Geometry  *geo.Geometry `json:"geometry,omitempty"`
+	AreaKm2   float64       `json:"area_km2"`
+	ValidFrom *time.Time    `json:"valid_from,omitempty"`
+	ValidTo   *time.Time    `json:"valid_to,omitempty"`
+
+	polygons geo.MultiPolygon
+	sw, ne   geo.Point
+}
+
+// newClientArea validates the geometry of loc and prepares it for point-in-polygon queries.
+func newClientArea(loc Location) (ClientArea, error) {
+	polygons, err := loc.Geometry.Polygons()
+	if err != nil {
+		return ClientArea{}, err
+	}
+
+	name := loc.Name
+	if strings.TrimSpace(name) == "" {
+		name = strings.TrimSpace(loc.As + " " + loc.Asname)
+	}
+	if name == "" {
+		return ClientArea{}, fmt.Errorf("area without name")
+	}
+
+	tags := loc.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+
+	sw, ne := polygons.Bounds()
+	return ClientArea{
+		Name:     name,
+		As:       loc.As,
+		Asname:   loc.Asname,
+		Details:  loc.Details,
+		Tags:     tags,
+		Category: loc.Category,
+		Geometry: loc.Geometry,
+		AreaKm2:  polygons.Area() / 1e6,
+		polygons: polygons,
+		sw:       sw,
+		ne:       ne,
+	}, nil
+}
+
+// contains reports whether p lies inside the area.
+func (a ClientArea) contains(p geo.Point) bool {
+	if p.Lat < a.sw.Lat || p.Lat > a.ne.Lat || p.Lon < a.sw.Lon || p.Lon > a.ne.Lon {
+		return false
+	}
+	return a.polygons.Contains(p)
+}
+
+// apiAreas returns the area locations with their geometry, filtered like /api/locations.
+func apiAreas(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseLocationFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, filterAreas(getCachedAreas(), filter))
+}
+
+// apiGeoContains returns the areas (without geometry) that contain lat/lon.
+func apiGeoContains(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	p, err := parsePointParams(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	filter, err := parseLocationFilter(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hits := []ClientArea{}
+	for _, a := range filterAreas(getCachedAreas(), filter) {
+		if a.contains(p) {
+			a.Geometry = nil
+			hits = append(hits, a)
+		}
+	}
+
+	writeJSON(w, hits)
+}
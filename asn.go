@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// asnRecord is one entry of the local ASN registry dump.
+type asnRecord struct {
+	ASN     uint32
+	Name    string
+	Country string
+	RIR     string
+}
+
+// asnRegistry is the parsed registry file, reloaded when the file changes on disk.
+type asnRegistry struct {
+	mu      sync.Mutex
+	path    string
+	modTime time.Time
+	records map[uint32]asnRecord
+}
+
+// validationIssue is a problem found in the location source.
+type validationIssue struct {
+	Kind     string          `json:"kind"`
+	Message  string          `json:"message"`
+	Location ClientLocation  `json:"location"`
+	Related  *ClientLocation `json:"related,omitempty"`
+}
+
+// parseASN accepts "AS8535", "as8535" or "8535".
+func parseASN(s string) (uint32, bool) {
+	s = strings.TrimSpace(s)
+	if len(s) > 2 && strings.EqualFold(s[:2], "as") {
+		s = s[2:]
+	}
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(n), true
+}
+
+// get returns the registry records, reloading the file if it changed; nil when
+// no registry is configured (or it cannot be read). The map is replaced, never
+// modified, on reload, so callers may keep reading it.
+func (reg *asnRegistry) get() map[uint32]asnRecord {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if reg.path == "" {
+		return nil
+	}
+	if err := reg.reload(); err != nil {
+		logger.Printf("Failed to load ASN registry: %v", err)
+	}
+	return reg.records
+}
+
+// reload re-reads the registry when its modification time changed.
+func (reg *asnRegistry) reload() error {
+	fi, err := os.Stat(reg.path)
+	if err != nil {
+		return err
+	}
+	if reg.records != nil && fi.ModTime().Equal(reg.modTime) {
+		return nil
+	}
+
+	f, err := os.Open(reg.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	records, err := readASNRegistry(f)
+	if err != nil {
+		return err
+	}
+	reg.records = records
+	reg.modTime = fi.ModTime()
+	logger.Printf("Loaded %d ASN registry records from %s", len(records), reg.path)
+	return nil
+}
+
+// readASNRegistry parses a CSV of asn,name,country,rir. A header row and
+// rows with an unparsable ASN are skipped.
+func readASNRegistry(r io.Reader) (map[uint32]asnRecord, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	records := map[uint32]asnRecord{}
+	for line := 1; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		asn, ok := parseASN(row[0])
+		if !ok {
+			continue
+		}
+		rec := asnRecord{ASN: asn}
+		if len(row) > 1 {
+			rec.Name = strings.TrimSpace(row[1])
+		}
+		if len(row) > 2 {
+			rec.Country = strings.ToUpper(strings.TrimSpace(row[2]))
+		}
+		if len(row) > 3 {
+			rec.RIR = strings.TrimSpace(row[3])
+		}
+		records[asn] = rec
+	}
+	return records, nil
+}
+
+// detailsLink fills the details template: {as} becomes "AS8535", {asn} becomes "8535".
+func detailsLink(asn uint32) string {
+	n := strconv.FormatUint(uint64(asn), 10)
+	return strings.NewReplacer("{as}", "AS"+n, "{asn}", n).Replace(detailsTemplate)
+}
+
+// enrichLocations fills in missing asname, country and details from the ASN registry
+// and reports locations whose values disagree with it.
+func enrichLocations(locs []ClientLocation) []validationIssue {
+	issues := []validationIssue{}
+	records := asnReg.get()
+	for i := range locs {
+		loc := &locs[i]
+		if loc.As == "" {
+			continue
+		}
+		asn, ok := parseASN(loc.As)
+		if !ok {
+			issues = append(issues, validationIssue{Kind: "invalid_asn", Message: fmt.Sprintf("cannot parse AS number %q", loc.As), Location: *loc})
+			continue
+		}
+		if loc.Details == "" && detailsTemplate != "" {
+			loc.Details = detailsLink(asn)
+		}
+
+		if records == nil {
+			continue
+		}
+		rec, found := records[asn]
+		if !found {
+			issues = append(issues, validationIssue{Kind: "unknown_asn", Message: fmt.Sprintf("AS%d not found in ASN registry", asn), Location: *loc})
+			continue
+		}
+		if loc.Asname == "" {
+			loc.Asname = rec.Name
+		} else if rec.Name != "" && !strings.EqualFold(loc.Asname, rec.Name) {
+			issues = append(issues, validationIssue{Kind: "asname_mismatch", Message: fmt.Sprintf("asname %q differs from registry name %q", loc.Asname, rec.Name), Location: *loc})
+		}
+		if loc.Country == "" {
+			loc.Country = rec.Country
+			loc.registryCountry = rec.Country != ""
+		} else if rec.Country != "" && !strings.EqualFold(loc.Country, rec.Country) {
+			issues = append(issues, validationIssue{Kind: "country_mismatch", Message: fmt.Sprintf("country %q differs from registry country %q", loc.Country, rec.Country), Location: *loc})
+		}
+	}
+	return issues
+}
+
+// apiLocationsValidate reports the problems found while loading the locations
+// and the data-quality checks. ?radius_m= sets the near-duplicate distance
+// (default 50), ?kind= (comma separated) limits the issue kinds.
+func apiLocationsValidate(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	radius := 50.0
+	if s := q.Get("radius_m"); s != "" {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || !(v >= 0) || math.IsInf(v, 1) {
+			http.Error(w, "invalid radius_m", http.StatusBadRequest)
+			return
+		}
+		radius = v
+	}
+
+	issues := append(getLocationIssues(), qualityIssues(getCachedLocations(), radius)...)
+	if kinds := q.Get("kind"); kinds != "" {
+		want := strings.Split(kinds, ",")
+		filtered := []validationIssue{}
+		for _, issue := range issues {
+			if slices.Contains(want, issue.Kind) {
+				filtered = append(filtered, issue)
+			}
+		}
+		issues = filtered
+	}
+	writeJSON(w, issues)
+}
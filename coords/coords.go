@@ -0,0 +1,292 @@
+// Package coords parses coordinates written in the common human and machine
+// formats: decimal degrees, degrees-minutes-seconds, degrees-decimal-minutes,
+// geo: URIs (RFC 5870), geohashes and Open Location Codes (plus codes).
+package coords
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Formats reported by Parse.
+const (
+	FormatDecimal  = "decimal"
+	FormatDMS      = "dms"
+	FormatDDM      = "ddm"
+	FormatGeoURI   = "geo_uri"
+	FormatGeohash  = "geohash"
+	FormatPlusCode = "plus_code"
+)
+
+// Coordinate is a parsed WGS84 position. UncertaintyM is set when the input
+// carries a precision (geo: u= parameter, geohash or plus code cell size).
+type Coordinate struct {
+	Lat          float64 `json:"lat"`
+	Lon          float64 `json:"lon"`
+	Format       string  `json:"format"`
+	UncertaintyM float64 `json:"uncertainty_m,omitempty"`
+}
+
+// Parse reads a coordinate in any of the supported formats, e.g.
+//
+//	51.1099,17.0320
+//	51°06'35.9"N 17°01'55.1"E
+//	N 51 06.598 E 17 01.919
+//	geo:51.1,17.03;u=10
+//	u3h4fg
+//	9F4MGC4J+2V
+func Parse(s string) (Coordinate, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Coordinate{}, fmt.Errorf("empty coordinate")
+	}
+
+	var c Coordinate
+	var err error
+	switch {
+	case len(s) > 4 && strings.EqualFold(s[:4], "geo:"):
+		c, err = parseGeoURI(s)
+	case strings.Contains(s, "+") && isPlusCode(s):
+		c, err = DecodePlusCode(s)
+	case isGeohash(s):
+		c, err = DecodeGeohash(s)
+	default:
+		c, err = parseDegrees(s)
+	}
+	if err != nil {
+		return Coordinate{}, err
+	}
+	return c, checkRange(c.Lat, c.Lon)
+}
+
+func checkRange(lat, lon float64) error {
+	if math.IsNaN(lat) || lat < -90 || lat > 90 {
+		return fmt.Errorf("latitude out of range: %f", lat)
+	}
+	if math.IsNaN(lon) || lon < -180 || lon > 180 {
+		return fmt.Errorf("longitude out of range: %f", lon)
+	}
+	return nil
+}
+
+// parseGeoURI reads geo:lat,lon[,alt][;crs=wgs84][;u=metres][;...].
+func parseGeoURI(s string) (Coordinate, error) {
+	params := strings.Split(s[4:], ";")
+	parts := strings.Split(params[0], ",")
+	if len(parts) < 2 || len(parts) > 3 {
+		return Coordinate{}, fmt.Errorf("invalid geo URI: %s", s)
+	}
+	lat, err1 := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	lon, err2 := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err1 != nil || err2 != nil {
+		return Coordinate{}, fmt.Errorf("invalid geo URI: %s", s)
+	}
+	c := Coordinate{Lat: lat, Lon: lon, Format: FormatGeoURI}
+	for _, p := range params[1:] {
+		key, val, _ := strings.Cut(p, "=")
+		switch strings.ToLower(key) {
+		case "crs":
+			if !strings.EqualFold(val, "wgs84") {
+				return Coordinate{}, fmt.Errorf("unsupported geo URI crs: %s", val)
+			}
+		case "u":
+			u, err := strconv.ParseFloat(val, 64)
+			if err != nil || u < 0 {
+				return Coordinate{}, fmt.Errorf("invalid geo URI uncertainty: %s", val)
+			}
+			c.UncertaintyM = u
+		}
+	}
+	return c, nil
+}
+
+// coordToken is a number or a hemisphere letter of a degrees notation.
+type coordToken struct {
+	num    float64
+	hemi   byte // N, S, E, W or 0 for numbers
+	signed bool // number had an explicit sign
+}
+
+// tokenizeDegrees splits degree notations into numbers and hemisphere letters;
+// degree, minute and second marks, commas and spaces are separators.
+func tokenizeDegrees(s string) ([]coordToken, error) {
+	var out []coordToken
+	rs := []rune(s)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case r == '-' || r == '+' || r == '.' || unicode.IsDigit(r):
+			j := i + 1
+			for j < len(rs) && (rs[j] == '.' || unicode.IsDigit(rs[j])) {
+				j++
+			}
+			v, err := strconv.ParseFloat(string(rs[i:j]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number: %s", string(rs[i:j]))
+			}
+			out = append(out, coordToken{num: v, signed: r == '-' || r == '+'})
+			i = j
+		case strings.ContainsRune("NSEWnsew", r):
+			out = append(out, coordToken{hemi: byte(unicode.ToUpper(r))})
+			i++
+		case unicode.IsSpace(r) || strings.ContainsRune(",;°º˚'’′\"”″", r):
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected character %q", r)
+		}
+	}
+	return out, nil
+}
+
+// parseDegrees reads two decimal, DDM or DMS values, with hemisphere letters
+// before or after each value, or signed values without letters.
+func parseDegrees(s string) (Coordinate, error) {
+	toks, err := tokenizeDegrees(s)
+	if err != nil {
+		return Coordinate{}, fmt.Errorf("invalid location format: %s (%v)", s, err)
+	}
+
+	var groups [][]coordToken // numbers of each value, hemisphere letter last
+	hasHemi := false
+	for _, t := range toks {
+		if t.hemi != 0 {
+			hasHemi = true
+		}
+	}
+	switch {
+	case !hasHemi:
+		if len(toks) == 0 || len(toks)%2 != 0 || len(toks) > 6 {
+			return Coordinate{}, fmt.Errorf("invalid location format: %s", s)
+		}
+		groups = [][]coordToken{toks[:len(toks)/2], toks[len(toks)/2:]}
+	case toks[0].hemi != 0: // N 51 06.598 E 17 01.919
+		for _, t := range toks {
+			if t.hemi != 0 {
+				groups = append(groups, nil)
+			}
+			groups[len(groups)-1] = append(groups[len(groups)-1], t)
+		}
+		for i, g := range groups { // move the letter last
+			groups[i] = append(g[1:], g[0])
+		}
+	default: // 51°06'35.9"N 17°01'55.1"E
+		cur := []coordToken{}
+		for _, t := range toks {
+			cur = append(cur, t)
+			if t.hemi != 0 {
+				groups = append(groups, cur)
+				cur = []coordToken{}
+			}
+		}
+		if len(cur) > 0 {
+			return Coordinate{}, fmt.Errorf("invalid location format: %s", s)
+		}
+	}
+	if len(groups) != 2 {
+		return Coordinate{}, fmt.Errorf("invalid location format: %s", s)
+	}
+
+	var vals [2]float64
+	var hemis [2]byte
+	parts := 0
+	for i, g := range groups {
+		if n := len(g); n > 0 && g[n-1].hemi != 0 {
+			hemis[i] = g[n-1].hemi
+			g = g[:n-1]
+		}
+		if len(g) == 0 || len(g) > 3 || (parts != 0 && len(g) != parts) {
+			return Coordinate{}, fmt.Errorf("invalid location format: %s", s)
+		}
+		parts = len(g)
+		v, err := combineDegrees(g, hemis[i])
+		if err != nil {
+			return Coordinate{}, fmt.Errorf("invalid location format: %s (%v)", s, err)
+		}
+		vals[i] = v
+	}
+
+	lat, lon := vals[0], vals[1]
+	switch {
+	case hemis[0] == 'E' || hemis[0] == 'W':
+		if hemis[1] != 'N' && hemis[1] != 'S' {
+			return Coordinate{}, fmt.Errorf("invalid location format: %s (hemispheres)", s)
+		}
+		lat, lon = vals[1], vals[0]
+	case hemis[0] != 0 && (hemis[1] == 'N' || hemis[1] == 'S'):
+		return Coordinate{}, fmt.Errorf("invalid location format: %s (hemispheres)", s)
+	}
+
+	format := map[int]string{1: FormatDecimal, 2: FormatDDM, 3: FormatDMS}[parts]
+	return Coordinate{Lat: lat, Lon: lon, Format: format}, nil
+}
+
+// combineDegrees turns degrees[, minutes[, seconds]] and a hemisphere into a signed value.
+func combineDegrees(g []coordToken, hemi byte) (float64, error) {
+	deg := g[0].num
+	neg := deg < 0 || (g[0].signed && math.Signbit(deg))
+	deg = math.Abs(deg)
+	scale := 60.0
+	for _, t := range g[1:] {
+		if t.signed || t.num < 0 || t.num >= 60 {
+			return 0, fmt.Errorf("minutes and seconds must be between 0 and 60")
+		}
+		deg += t.num / scale
+		scale *= 60
+	}
+	if hemi == 'S' || hemi == 'W' {
+		if neg {
+			return 0, fmt.Errorf("negative value with %c hemisphere", hemi)
+		}
+		neg = true
+	}
+	if neg {
+		deg = -deg
+	}
+	return deg, nil
+}
+
+// ParseAxis reads a single latitude or longitude in decimal, DDM or DMS
+// notation, e.g. `51°06'35.9"N` or `-17.032`.
+func ParseAxis(s string, isLat bool) (float64, error) {
+	toks, err := tokenizeDegrees(strings.TrimSpace(s))
+	if err != nil || len(toks) == 0 {
+		return 0, fmt.Errorf("invalid coordinate: %s", s)
+	}
+	var hemi byte
+	switch {
+	case toks[0].hemi != 0:
+		hemi, toks = toks[0].hemi, toks[1:]
+	case toks[len(toks)-1].hemi != 0:
+		hemi, toks = toks[len(toks)-1].hemi, toks[:len(toks)-1]
+	}
+	if len(toks) == 0 || len(toks) > 3 {
+		return 0, fmt.Errorf("invalid coordinate: %s", s)
+	}
+	for _, t := range toks {
+		if t.hemi != 0 {
+			return 0, fmt.Errorf("invalid coordinate: %s", s)
+		}
+	}
+	if hemi != 0 && (hemi == 'N' || hemi == 'S') != isLat {
+		return 0, fmt.Errorf("invalid hemisphere %c: %s", hemi, s)
+	}
+	v, err := combineDegrees(toks, hemi)
+	if err != nil {
+		return 0, fmt.Errorf("invalid coordinate: %s (%v)", s, err)
+	}
+	if isLat {
+		return v, checkRange(v, 0)
+	}
+	return v, checkRange(0, v)
+}
+
+// cellUncertainty is the half diagonal, in metres, of a lat/lon cell centred on lat.
+func cellUncertainty(lat, dLat, dLon float64) float64 {
+	const mPerDeg = 111320.0
+	y := dLat * mPerDeg
+	x := dLon * mPerDeg * math.Cos(lat*math.Pi/180)
+	return math.Round(math.Hypot(x, y)/2*10) / 10
+}
@@ -0,0 +1,118 @@
+package coords
+
+import (
+	"math"
+	"testing"
+)
+
+func near(a, b, eps float64) bool { return math.Abs(a-b) <= eps }
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in       string
+		lat, lon float64
+		format   string
+		u        float64 // expected UncertaintyM, -1: any positive value
+	}{
+		{"51.1099,17.0320", 51.1099, 17.032, FormatDecimal, 0},
+		{" 51.1099 17.0320 ", 51.1099, 17.032, FormatDecimal, 0},
+		{"-33.8688, 151.2093", -33.8688, 151.2093, FormatDecimal, 0},
+		{"51.1N 17.03E", 51.1, 17.03, FormatDecimal, 0},
+		{"17.03E 51.1N", 51.1, 17.03, FormatDecimal, 0},
+		{"51n17e", 51, 17, FormatDecimal, 0},
+		{"33.8S 151.2E", -33.8, 151.2, FormatDecimal, 0},
+		{`51°06'35.9"N 17°01'55.1"E`, 51.109972, 17.031972, FormatDMS, 0},
+		{`33°52'08"S, 151°12'33"E`, -33.868889, 151.209167, FormatDMS, 0},
+		{"N 51 06.598 E 17 01.919", 51.109967, 17.031983, FormatDDM, 0},
+		{"W 3 42.6 N 40 25.2", 40.42, -3.71, FormatDDM, 0},
+		{"-0 30 0 -0 30 0", -0.5, -0.5, FormatDMS, 0}, // signed zero degrees keeps the sign
+		{"geo:51.1,17.03", 51.1, 17.03, FormatGeoURI, 0},
+		{"GEO:51.1,17.03,120;crs=WGS84;u=10", 51.1, 17.03, FormatGeoURI, 10},
+		{"u3h4fg", 51.127625, 17.001343, FormatGeohash, -1},
+		{"U3H4FG", 51.127625, 17.001343, FormatGeohash, -1},
+		{"9F4MGC4J+2V", 52.505063, 13.432188, FormatPlusCode, -1},
+	}
+	for _, tt := range tests {
+		c, err := Parse(tt.in)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.in, err)
+			continue
+		}
+		if !near(c.Lat, tt.lat, 1e-6) || !near(c.Lon, tt.lon, 1e-6) {
+			t.Errorf("Parse(%q) = %f,%f, want %f,%f", tt.in, c.Lat, c.Lon, tt.lat, tt.lon)
+		}
+		if c.Format != tt.format {
+			t.Errorf("Parse(%q) format = %s, want %s", tt.in, c.Format, tt.format)
+		}
+		if tt.u >= 0 && c.UncertaintyM != tt.u {
+			t.Errorf("Parse(%q) uncertainty = %f, want %f", tt.in, c.UncertaintyM, tt.u)
+		}
+		if tt.u < 0 && c.UncertaintyM <= 0 { // cell size
+			t.Errorf("Parse(%q) has no uncertainty", tt.in)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"17e",     // one axis, not a geohash
+		"51.1",    // one value
+		"91,17",   // latitude out of range
+		"51,181",  // longitude out of range
+		"51N 17N", // two latitudes
+		"51E 17E",
+		"-51S 17E",               // sign and hemisphere
+		`51°60'0"N 17°0'0"E`,     // minutes out of range
+		"51 06 17",               // odd number of values
+		"geo:51.1",               // missing longitude
+		"geo:51.1,17.03;crs=utm", // other crs
+		"geo:51.1,17.03;u=-1",    // negative uncertainty
+		"MGC4J+2V",               // short plus code
+		"u3h4fga0u3h4f",          // geohash too long
+		"hello world",
+	} {
+		if c, err := Parse(in); err == nil {
+			t.Errorf("Parse(%q) = %+v, want an error", in, c)
+		}
+	}
+}
+
+func TestIsGeohash(t *testing.T) {
+	for s, want := range map[string]bool{
+		"u3h4fg": true,
+		"u3":     true,
+		"sp3e":   true, // p is not a hemisphere letter
+		"u":      false,
+		"17e":    false,
+		"51n17e": false,
+		"1234":   false,
+		"u3h4fa": false, // a is not base32
+	} {
+		if got := isGeohash(s); got != want {
+			t.Errorf("isGeohash(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
+
+func TestParseAxis(t *testing.T) {
+	tests := []struct {
+		in    string
+		isLat bool
+		want  float64
+		ok    bool
+	}{
+		{`51°06'35.9"N`, true, 51.109972, true},
+		{"W 3 42.6", false, -3.71, true},
+		{"-17.032", false, -17.032, true},
+		{"17E", true, 0, false},
+		{"95", true, 0, false},
+		{"", false, 0, false},
+	}
+	for _, tt := range tests {
+		v, err := ParseAxis(tt.in, tt.isLat)
+		if (err == nil) != tt.ok || tt.ok && !near(v, tt.want, 1e-6) {
+			t.Errorf("ParseAxis(%q, %v) = %f, %v", tt.in, tt.isLat, v, err)
+		}
+	}
+}
@@ -0,0 +1,368 @@
+package coords
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// WGS84 / GRS80 ellipsoid. The two differ by 0.1 mm in the semi-minor axis, so
+// the Polish grids (GRS80) use the same constants.
+const (
+	wgs84A = 6378137.0
+	wgs84F = 1 / 298.257223563
+)
+
+// Projected is a position in a coordinate reference system. X is the easting
+// (longitude for EPSG:4326) and Y the northing (latitude). Text is the usual
+// written form, e.g. "33N 642146 5663654" for UTM or an MGRS reference.
+type Projected struct {
+	CRS  string  `json:"crs"`
+	Name string  `json:"name"`
+	X    float64 `json:"x"`
+	Y    float64 `json:"y"`
+	Text string  `json:"text"`
+}
+
+// transverseMercator is a Transverse Mercator grid on the WGS84 ellipsoid.
+type transverseMercator struct {
+	lon0, k0, e0, n0 float64
+}
+
+// Krüger series coefficients (third order in n, sub-millimetre within
+// a few degrees of the central meridian).
+var tmA, tmAlpha, tmBeta, tmDelta = func() (float64, [3]float64, [3]float64, [3]float64) {
+	n := wgs84F / (2 - wgs84F)
+	n2, n3 := n*n, n*n*n
+	a := wgs84A / (1 + n) * (1 + n2/4 + n2*n2/64)
+	alpha := [3]float64{n/2 - 2*n2/3 + 5*n3/16, 13*n2/48 - 3*n3/5, 61 * n3 / 240}
+	beta := [3]float64{n/2 - 2*n2/3 + 37*n3/96, n2/48 + n3/15, 17 * n3 / 480}
+	delta := [3]float64{2*n - 2*n2/3 - 2*n3, 7*n2/3 - 8*n3/5, 56 * n3 / 15}
+	return a, alpha, beta, delta
+}()
+
+func (tm transverseMercator) forward(lat, lon float64) (e, n float64) {
+	nn := wgs84F / (2 - wgs84F)
+	c := 2 * math.Sqrt(nn) / (1 + nn)
+	phi := lat * math.Pi / 180
+	dl := (lon - tm.lon0) * math.Pi / 180
+	t := math.Sinh(math.Atanh(math.Sin(phi)) - c*math.Atanh(c*math.Sin(phi)))
+	xi := math.Atan2(t, math.Cos(dl))
+	eta := math.Atanh(math.Sin(dl) / math.Sqrt(1+t*t))
+	x, y := eta, xi
+	for j, a := range tmAlpha {
+		k := float64(2 * (j + 1))
+		x += a * math.Cos(k*xi) * math.Sinh(k*eta)
+		y += a * math.Sin(k*xi) * math.Cosh(k*eta)
+	}
+	return tm.e0 + tm.k0*tmA*x, tm.n0 + tm.k0*tmA*y
+}
+
+func (tm transverseMercator) inverse(e, n float64) (lat, lon float64) {
+	xi := (n - tm.n0) / (tm.k0 * tmA)
+	eta := (e - tm.e0) / (tm.k0 * tmA)
+	xi1, eta1 := xi, eta
+	for j, b := range tmBeta {
+		k := float64(2 * (j + 1))
+		xi1 -= b * math.Sin(k*xi) * math.Cosh(k*eta)
+		eta1 -= b * math.Cos(k*xi) * math.Sinh(k*eta)
+	}
+	chi := math.Asin(math.Sin(xi1) / math.Cosh(eta1))
+	phi := chi
+	for j, d := range tmDelta {
+		phi += d * math.Sin(float64(2*(j+1))*chi)
+	}
+	return phi * 180 / math.Pi, tm.lon0 + math.Atan2(math.Sinh(eta1), math.Cos(xi1))*180/math.Pi
+}
+
+// pl1992 is the Polish PL-1992 grid (EPSG:2180).
+var pl1992 = transverseMercator{lon0: 19, k0: 0.9993, e0: 500000, n0: -5300000}
+
+// pl2000 returns the PL-2000 grid of zone 5-8 (EPSG:2176-2179).
+func pl2000(zone int) transverseMercator {
+	return transverseMercator{lon0: float64(zone * 3), k0: 0.999923, e0: float64(zone)*1e6 + 500000}
+}
+
+// utmGrid returns UTM zone 1-60 of the northern or southern hemisphere.
+func utmGrid(zone int, north bool) transverseMercator {
+	tm := transverseMercator{lon0: float64(zone*6 - 183), k0: 0.9996, e0: 500000}
+	if !north {
+		tm.n0 = 10000000
+	}
+	return tm
+}
+
+// UTMZone returns the UTM zone of a position, including the Norway and
+// Svalbard exceptions.
+func UTMZone(lat, lon float64) int {
+	if lon >= 180 {
+		lon -= 360
+	}
+	zone := int(math.Floor((lon+180)/6)) + 1
+	switch {
+	case lat >= 56 && lat < 64 && lon >= 3 && lon < 12:
+		zone = 32
+	case lat >= 72 && lat < 84 && lon >= 0 && lon < 42:
+		zone = 31 + 2*int(math.Floor((lon+3)/12))
+	}
+	return zone
+}
+
+const latBands = "CDEFGHJKLMNPQRSTUVWX"
+
+// latBand returns the MGRS/UTM latitude band letter, 0 outside 80S-84N.
+func latBand(lat float64) byte {
+	if lat < -80 || lat > 84 {
+		return 0
+	}
+	i := int(math.Floor((lat + 80) / 8))
+	if i > 19 {
+		i = 19 // band X spans 72-84N
+	}
+	return latBands[i]
+}
+
+// webMercator is EPSG:3857 on the WGS84 sphere radius.
+func webMercator(lat, lon float64) (x, y float64) {
+	lat = math.Max(-85.05112878, math.Min(85.05112878, lat))
+	return wgs84A * lon * math.Pi / 180, wgs84A * math.Log(math.Tan(math.Pi/4+lat*math.Pi/360))
+}
+
+func webMercatorInverse(x, y float64) (lat, lon float64) {
+	return (2*math.Atan(math.Exp(y/wgs84A)) - math.Pi/2) * 180 / math.Pi, x / wgs84A * 180 / math.Pi
+}
+
+// crsInfo describes one supported CRS code.
+type crsInfo struct {
+	code string // canonical code, e.g. EPSG:2180
+	name string
+}
+
+// normalizeCRS maps the accepted spellings of a CRS to a canonical code:
+// EPSG:4326, EPSG:3857, UTM (zone from the coordinate), EPSG:326xx/327xx,
+// MGRS, EPSG:2180 and EPSG:2176-2179.
+func normalizeCRS(s string) (crsInfo, error) {
+	c := strings.ToUpper(strings.TrimSpace(s))
+	c = strings.TrimPrefix(c, "EPSG:")
+	switch c {
+	case "", "4326", "WGS84":
+		return crsInfo{"EPSG:4326", "WGS84"}, nil
+	case "3857", "900913", "WEBMERCATOR":
+		return crsInfo{"EPSG:3857", "Web Mercator"}, nil
+	case "UTM":
+		return crsInfo{"UTM", "UTM"}, nil
+	case "MGRS":
+		return crsInfo{"MGRS", "MGRS"}, nil
+	case "2180", "PL1992", "PL-1992":
+		return crsInfo{"EPSG:2180", "PL-1992"}, nil
+	case "2176", "2177", "2178", "2179":
+		zone := int(c[3]-'0') - 1
+		return crsInfo{"EPSG:" + c, fmt.Sprintf("PL-2000 zone %d", zone)}, nil
+	case "PL2000", "PL-2000":
+		return crsInfo{"PL2000", "PL-2000"}, nil
+	}
+	if code, err := strconv.Atoi(c); err == nil && (code > 32600 && code <= 32660 || code > 32700 && code <= 32760) {
+		hemi := "N"
+		if code > 32700 {
+			hemi = "S"
+		}
+		return crsInfo{"EPSG:" + c, fmt.Sprintf("UTM zone %d%s", code%100, hemi)}, nil
+	}
+	return crsInfo{}, fmt.Errorf("unsupported CRS: %s", s)
+}
+
+// ToCRS projects a WGS84 position into crs. "UTM" and "PL2000" pick the zone
+// from the position.
+func ToCRS(lat, lon float64, crs string) (Projected, error) {
+	if err := checkRange(lat, lon); err != nil {
+		return Projected{}, err
+	}
+	info, err := normalizeCRS(crs)
+	if err != nil {
+		return Projected{}, err
+	}
+	p := Projected{CRS: info.code, Name: info.name}
+	switch code := info.code; {
+	case code == "EPSG:4326":
+		p.X, p.Y = lon, lat
+		p.Text = fmt.Sprintf("%.6f,%.6f", lat, lon)
+		return p, nil
+	case code == "EPSG:3857":
+		p.X, p.Y = webMercator(lat, lon)
+	case code == "MGRS":
+		if p.Text, err = ToMGRS(lat, lon, 5); err != nil {
+			return Projected{}, err
+		}
+		p.X, p.Y, _ = toUTM(lat, lon, UTMZone(lat, lon))
+		return p, nil
+	case code == "UTM" || strings.HasPrefix(code, "EPSG:32"):
+		zone, north := UTMZone(lat, lon), lat >= 0
+		if code != "UTM" {
+			n, _ := strconv.Atoi(code[len(code)-2:])
+			zone, north = n, code[7] == '6'
+		}
+		tm := utmGrid(zone, north)
+		p.X, p.Y = tm.forward(lat, lon)
+		hemi := "N"
+		if !north {
+			hemi = "S"
+		}
+		p.Name = fmt.Sprintf("UTM zone %d%s", zone, hemi)
+		p.CRS = fmt.Sprintf("EPSG:%d", 32600+zone+map[bool]int{true: 0, false: 100}[north])
+		p.Text = fmt.Sprintf("%d%s %.0f %.0f", zone, hemi, p.X, p.Y)
+		return p, nil
+	case code == "EPSG:2180":
+		p.X, p.Y = pl1992.forward(lat, lon)
+	default: // PL-2000
+		zone := 0
+		if code == "PL2000" {
+			zone = int(math.Floor((lon + 1.5) / 3))
+			if zone < 5 || zone > 8 {
+				return Projected{}, fmt.Errorf("longitude %f is outside the PL-2000 zones", lon)
+			}
+			p.CRS, p.Name = fmt.Sprintf("EPSG:%d", 2171+zone), fmt.Sprintf("PL-2000 zone %d", zone)
+		} else {
+			zone = int(code[8]-'0') - 1
+		}
+		p.X, p.Y = pl2000(zone).forward(lat, lon)
+	}
+	p.Text = fmt.Sprintf("%.2f,%.2f", p.X, p.Y)
+	return p, nil
+}
+
+func toUTM(lat, lon float64, zone int) (e, n float64, north bool) {
+	north = lat >= 0
+	e, n = utmGrid(zone, north).forward(lat, lon)
+	return e, n, north
+}
+
+// FromCRS reads a position written in crs and returns it in WGS84. Projected
+// grids take "easting,northing" (x first, as in GIS software; note the
+// Polish surveying convention writes northing first). UTM takes
+// "33N 642146 5663654" or "33U 642146 5663654", as do EPSG:326xx/327xx when
+// the zone matches, MGRS a grid reference and EPSG:4326 anything Parse accepts.
+func FromCRS(crs, s string) (Coordinate, error) {
+	info, err := normalizeCRS(crs)
+	if err != nil {
+		return Coordinate{}, err
+	}
+	var lat, lon float64
+	switch code := info.code; {
+	case code == "EPSG:4326":
+		return Parse(s)
+	case code == "MGRS":
+		lat, lon, err = FromMGRS(s)
+	case code == "UTM":
+		lat, lon, err = parseUTM(s)
+	case code == "PL2000":
+		var x, y float64
+		if x, y, err = parseXY(s); err == nil {
+			zone := int(x / 1e6)
+			if zone < 5 || zone > 8 {
+				return Coordinate{}, fmt.Errorf("easting %.2f has no PL-2000 zone prefix 5-8", x)
+			}
+			lat, lon = pl2000(zone).inverse(x, y)
+		}
+	case strings.HasPrefix(code, "EPSG:32"):
+		zone, _ := strconv.Atoi(code[len(code)-2:])
+		north := code[7] == '6'
+		var x, y float64
+		if x, y, err = parseXY(s); err != nil {
+			// the "33N 642146 5663654" text ToCRS writes, in the same zone
+			z, n, ux, uy, uerr := splitUTM(s)
+			if uerr != nil {
+				break
+			}
+			if z != zone || n != north {
+				return Coordinate{}, fmt.Errorf("%s is not in %s (%s)", s, info.name, code)
+			}
+			x, y, err = ux, uy, nil
+		}
+		lat, lon = utmGrid(zone, north).inverse(x, y)
+	default:
+		var x, y float64
+		if x, y, err = parseXY(s); err != nil {
+			break
+		}
+		switch {
+		case code == "EPSG:3857":
+			lat, lon = webMercatorInverse(x, y)
+		case code == "EPSG:2180":
+			lat, lon = pl1992.inverse(x, y)
+		default:
+			lat, lon = pl2000(int(code[8]-'0')-1).inverse(x, y)
+		}
+	}
+	if err != nil {
+		return Coordinate{}, err
+	}
+	if err := checkRange(lat, lon); err != nil {
+		return Coordinate{}, err
+	}
+	return Coordinate{Lat: lat, Lon: lon, Format: info.code}, nil
+}
+
+// parseXY reads "x,y" or "x y".
+func parseXY(s string) (x, y float64, err error) {
+	f := strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ';' || r == ' ' || r == '\t' })
+	if len(f) != 2 {
+		return 0, 0, fmt.Errorf("invalid projected coordinate: %s (want easting,northing)", s)
+	}
+	x, err1 := strconv.ParseFloat(f[0], 64)
+	y, err2 := strconv.ParseFloat(f[1], 64)
+	if err1 != nil || err2 != nil {
+		return 0, 0, fmt.Errorf("invalid projected coordinate: %s", s)
+	}
+	return x, y, nil
+}
+
+// parseUTM reads "33N 642146 5663654" (hemisphere) or "33U 642146 5663654"
+// (latitude band; N and S are always read as hemispheres).
+func parseUTM(s string) (lat, lon float64, err error) {
+	zone, north, x, y, err := splitUTM(s)
+	if err != nil {
+		return 0, 0, err
+	}
+	lat, lon = utmGrid(zone, north).inverse(x, y)
+	return lat, lon, nil
+}
+
+// splitUTM returns the zone, hemisphere, easting and northing of a UTM text.
+func splitUTM(s string) (zone int, north bool, x, y float64, err error) {
+	f := strings.Fields(strings.ToUpper(strings.ReplaceAll(s, ",", " ")))
+	if len(f) != 3 || len(f[0]) < 2 {
+		return 0, false, 0, 0, fmt.Errorf("invalid UTM coordinate: %s (want e.g. 33U 642146 5663654)", s)
+	}
+	letter := f[0][len(f[0])-1]
+	zone, err = strconv.Atoi(f[0][:len(f[0])-1])
+	if err != nil || zone < 1 || zone > 60 || strings.IndexByte(latBands, letter) < 0 {
+		return 0, false, 0, 0, fmt.Errorf("invalid UTM zone: %s", f[0])
+	}
+	x, err1 := strconv.ParseFloat(f[1], 64)
+	y, err2 := strconv.ParseFloat(f[2], 64)
+	if err1 != nil || err2 != nil {
+		return 0, false, 0, 0, fmt.Errorf("invalid UTM coordinate: %s", s)
+	}
+	north = letter >= 'N'
+	if letter == 'S' {
+		north = false
+	}
+	return zone, north, x, y, nil
+}
+
+// AllCRS returns the position in every supported CRS: WGS84, Web Mercator,
+// UTM, MGRS and, inside Poland, PL-1992 and the PL-2000 zone of the position.
+func AllCRS(lat, lon float64) []Projected {
+	codes := []string{"EPSG:4326", "EPSG:3857", "UTM", "MGRS"}
+	if lat >= 49 && lat <= 55 && lon >= 14 && lon <= 24.2 {
+		codes = append(codes, "EPSG:2180", "PL2000")
+	}
+	var out []Projected
+	for _, c := range codes {
+		if p, err := ToCRS(lat, lon, c); err == nil {
+			out = append(out, p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,152 @@
+package coords
+
+import (
+	"math"
+	"testing"
+)
+
+// Reference UTM/MGRS values were computed independently with the Snyder
+// series (USGS Professional Paper 1395), which agree with the implementation
+// here to well under a metre inside a zone.
+var refPoints = []struct {
+	name     string
+	lat, lon float64
+	utm      string // "zone hemisphere easting northing", rounded to metres
+	mgrs     string
+}{
+	{"Wrocław", 51.1099, 17.0320, "33N 642241 5664010", "33U XS 42241 64009"},
+	{"Sydney", -33.8568, 151.2153, "56S 334901 6252289", "56H LH 34900 52288"},
+	{"Cape Town", -33.9249, 18.4241, "34S 261882 6243182", "34H BH 61881 43182"},
+	{"Buenos Aires", -34.6037, -58.3816, "21S 373318 6170036", "21H UB 73317 70036"},
+	{"Washington", 38.8895, -77.0353, "18N 323478 4306483", "18S UJ 23478 06483"},
+}
+
+// metres returns the distance between two nearby positions.
+func metres(lat1, lon1, lat2, lon2 float64) float64 {
+	const mPerDeg = 111320.0
+	return math.Hypot((lat2-lat1)*mPerDeg, (lon2-lon1)*mPerDeg*math.Cos(lat1*math.Pi/180))
+}
+
+func TestUTMReference(t *testing.T) {
+	for _, p := range refPoints {
+		got, err := ToCRS(p.lat, p.lon, "utm")
+		if err != nil {
+			t.Fatalf("%s: %v", p.name, err)
+		}
+		if got.Text != p.utm {
+			t.Errorf("%s: ToCRS(utm) = %q, want %q", p.name, got.Text, p.utm)
+		}
+		got, err = ToCRS(p.lat, p.lon, "MGRS")
+		if err != nil {
+			t.Fatalf("%s: %v", p.name, err)
+		}
+		if got.Text != p.mgrs {
+			t.Errorf("%s: ToCRS(MGRS) = %q, want %q", p.name, got.Text, p.mgrs)
+		}
+	}
+}
+
+func TestCRSRoundTrip(t *testing.T) {
+	for _, p := range refPoints {
+		codes := []string{"EPSG:4326", "EPSG:3857", "UTM", "MGRS"}
+		if p.name == "Wrocław" {
+			codes = append(codes, "EPSG:2180", "PL2000", "EPSG:2178")
+		}
+		for _, code := range codes {
+			proj, err := ToCRS(p.lat, p.lon, code)
+			if err != nil {
+				t.Fatalf("%s: ToCRS(%s): %v", p.name, code, err)
+			}
+			// the CRS ToCRS reports (e.g. EPSG:32633 for UTM) must read its text back
+			c, err := FromCRS(proj.CRS, proj.Text)
+			if err != nil {
+				t.Errorf("%s: FromCRS(%s, %q): %v", p.name, proj.CRS, proj.Text, err)
+				continue
+			}
+			tolerance := 0.01 // two decimals of projected metres
+			switch code {
+			case "UTM":
+				tolerance = 1 // whole metres
+			case "MGRS":
+				tolerance = 1.5 // south-west corner of the 1 m square
+			}
+			if d := metres(p.lat, p.lon, c.Lat, c.Lon); d > tolerance {
+				t.Errorf("%s: %s %q reads back %.3f m off", p.name, proj.CRS, proj.Text, d)
+			}
+		}
+	}
+}
+
+func TestFromCRSUTM(t *testing.T) {
+	want, err := FromCRS("EPSG:32633", "642132,5662905")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, crs := range []string{"EPSG:32633", "32633", "utm"} {
+		for _, s := range []string{"33N 642132 5662905", "33U 642132 5662905", "33n, 642132, 5662905"} {
+			c, err := FromCRS(crs, s)
+			if err != nil {
+				t.Errorf("FromCRS(%s, %q): %v", crs, s, err)
+				continue
+			}
+			if c.Lat != want.Lat || c.Lon != want.Lon {
+				t.Errorf("FromCRS(%s, %q) = %f,%f, want %f,%f", crs, s, c.Lat, c.Lon, want.Lat, want.Lon)
+			}
+		}
+	}
+	for _, s := range []string{"34N 642132 5662905", "33S 642132 5662905", "33N 642132"} {
+		if _, err := FromCRS("EPSG:32633", s); err == nil {
+			t.Errorf("FromCRS(EPSG:32633, %q) gave no error", s)
+		}
+	}
+	// southern zones
+	c, err := FromCRS("EPSG:32756", "56S 334901 6252289")
+	if err != nil || metres(c.Lat, c.Lon, -33.8568, 151.2153) > 1 {
+		t.Errorf("FromCRS(EPSG:32756) = %+v, %v", c, err)
+	}
+}
+
+func TestMGRSRoundTrip(t *testing.T) {
+	for _, p := range refPoints {
+		lat, lon, err := FromMGRS(p.mgrs)
+		if err != nil {
+			t.Fatalf("FromMGRS(%s): %v", p.mgrs, err)
+		}
+		if d := metres(p.lat, p.lon, lat, lon); d > 1.5 {
+			t.Errorf("FromMGRS(%s) = %f,%f, %.2f m from %s", p.mgrs, lat, lon, d, p.name)
+		}
+		for digits := 1; digits <= 5; digits++ {
+			ref, err := ToMGRS(p.lat, p.lon, digits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lat, lon, err := FromMGRS(ref)
+			if err != nil {
+				t.Fatalf("FromMGRS(%s): %v", ref, err)
+			}
+			// the south-west corner (in grid terms) lies within the square's diagonal
+			size := math.Pow(10, float64(5-digits))
+			if d := metres(p.lat, p.lon, lat, lon); d > size*math.Sqrt2+1 {
+				t.Errorf("%s: %s reads back %.1f m off (square %.0f m)", p.name, ref, d, size)
+			}
+		}
+	}
+	// a sweep over the bands in both hemispheres catches wrong row cycles
+	for lat := -79.5; lat < 84; lat += 7.3 {
+		for lon := -177.0; lon < 180; lon += 23.9 {
+			ref, err := ToMGRS(lat, lon, 5)
+			if err != nil {
+				t.Fatalf("ToMGRS(%f,%f): %v", lat, lon, err)
+			}
+			glat, glon, err := FromMGRS(ref)
+			if err != nil || metres(lat, lon, glat, glon) > 1.5 {
+				t.Errorf("ToMGRS(%f,%f) = %s reads back %f,%f, %v", lat, lon, ref, glat, glon, err)
+			}
+		}
+	}
+	for _, s := range []string{"", "33", "61U XS", "33U IS 1 1", "33U XS 123", "33U XS 123456789012"} {
+		if _, _, err := FromMGRS(s); err == nil {
+			t.Errorf("FromMGRS(%q) gave no error", s)
+		}
+	}
+}
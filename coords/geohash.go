@@ -0,0 +1,68 @@
+package coords
+
+import (
+	"fmt"
+	"strings"
+)
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// isGeohash reports whether s looks like a geohash: 2-12 base32 characters with
+// at least one letter that is not a hemisphere letter, so "17e" or "51n17e"
+// are read as degrees. Geohashes of only digits and n, s, e, w are not
+// recognised.
+func isGeohash(s string) bool {
+	if len(s) < 2 || len(s) > 12 {
+		return false
+	}
+	letter := false
+	for _, r := range strings.ToLower(s) {
+		if !strings.ContainsRune(geohashAlphabet, r) {
+			return false
+		}
+		if r > '9' && !strings.ContainsRune("nsew", r) {
+			letter = true
+		}
+	}
+	return letter
+}
+
+// DecodeGeohash returns the centre of a geohash cell; UncertaintyM is half the
+// cell diagonal.
+func DecodeGeohash(s string) (Coordinate, error) {
+	latLo, latHi := -90.0, 90.0
+	lonLo, lonHi := -180.0, 180.0
+	even := true // bits alternate, starting with longitude
+	for _, r := range strings.ToLower(s) {
+		v := strings.IndexRune(geohashAlphabet, r)
+		if v < 0 {
+			return Coordinate{}, fmt.Errorf("invalid geohash: %s", s)
+		}
+		for bit := 4; bit >= 0; bit-- {
+			on := v>>bit&1 == 1
+			if even {
+				mid := (lonLo + lonHi) / 2
+				if on {
+					lonLo = mid
+				} else {
+					lonHi = mid
+				}
+			} else {
+				mid := (latLo + latHi) / 2
+				if on {
+					latLo = mid
+				} else {
+					latHi = mid
+				}
+			}
+			even = !even
+		}
+	}
+	lat, lon := (latLo+latHi)/2, (lonLo+lonHi)/2
+	return Coordinate{
+		Lat:          lat,
+		Lon:          lon,
+		Format:       FormatGeohash,
+		UncertaintyM: cellUncertainty(lat, latHi-latLo, lonHi-lonLo),
+	}, nil
+}
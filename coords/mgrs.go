@@ -0,0 +1,89 @@
+package coords
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// 100 km square letters: columns cycle through three sets by zone, rows
+// through 20 letters, offset by 5 in even zones.
+var mgrsColumns = [3]string{"STUVWXYZ", "ABCDEFGH", "JKLMNPQR"}
+
+const mgrsRows = "ABCDEFGHJKLMNPQRSTUV"
+
+// ToMGRS returns the MGRS grid reference of a position with digits (1-5)
+// digits per axis, e.g. "33U XS 42146 63654". The polar UPS areas are not
+// supported.
+func ToMGRS(lat, lon float64, digits int) (string, error) {
+	band := latBand(lat)
+	if band == 0 {
+		return "", fmt.Errorf("MGRS polar regions (UPS) are not supported")
+	}
+	if digits < 1 || digits > 5 {
+		return "", fmt.Errorf("MGRS precision must be 1-5 digits")
+	}
+	zone := UTMZone(lat, lon)
+	e, n, _ := toUTM(lat, lon, zone)
+
+	col := int(math.Floor(e/100000)) - 1
+	row := (int(math.Floor(n/100000)) + map[bool]int{true: 5, false: 0}[zone%2 == 0]) % 20
+	if col < 0 || col > 7 {
+		return "", fmt.Errorf("easting %.0f outside zone %d", e, zone)
+	}
+	div := math.Pow(10, float64(5-digits))
+	ee := int(math.Floor(math.Mod(e, 100000) / div))
+	nn := int(math.Floor(math.Mod(n, 100000) / div))
+	return fmt.Sprintf("%d%c %c%c %0*d %0*d", zone, band, mgrsColumns[zone%3][col], mgrsRows[row], digits, ee, digits, nn), nil
+}
+
+// FromMGRS returns the south-west corner of an MGRS grid reference
+// ("33UXS4214663654", spaces allowed).
+func FromMGRS(s string) (lat, lon float64, err error) {
+	ref := strings.ToUpper(strings.Join(strings.Fields(s), ""))
+	i := 0
+	for i < len(ref) && unicode.IsDigit(rune(ref[i])) {
+		i++
+	}
+	zone, zerr := strconv.Atoi(ref[:i])
+	if zerr != nil || zone < 1 || zone > 60 || len(ref) < i+3 {
+		return 0, 0, fmt.Errorf("invalid MGRS reference: %s", s)
+	}
+	band := strings.IndexByte(latBands, ref[i])
+	col := strings.IndexByte(mgrsColumns[zone%3], ref[i+1])
+	row := strings.IndexByte(mgrsRows, ref[i+2])
+	digits := ref[i+3:]
+	if band < 0 || col < 0 || row < 0 || len(digits)%2 != 0 || len(digits) > 10 {
+		return 0, 0, fmt.Errorf("invalid MGRS reference: %s", s)
+	}
+
+	var ee, nn float64
+	if half := len(digits) / 2; half > 0 {
+		a, err1 := strconv.Atoi(digits[:half])
+		b, err2 := strconv.Atoi(digits[half:])
+		if err1 != nil || err2 != nil {
+			return 0, 0, fmt.Errorf("invalid MGRS reference: %s", s)
+		}
+		scale := math.Pow(10, float64(5-half))
+		ee, nn = float64(a)*scale, float64(b)*scale
+	}
+
+	if zone%2 == 0 {
+		row = (row + 15) % 20
+	}
+	north := latBands[band] >= 'N'
+	tm := utmGrid(zone, north)
+	e := float64(col+1)*100000 + ee
+	n := float64(row)*100000 + nn
+
+	// rows repeat every 2000 km: move up to the band, whose southern edge is
+	// lowest on the central meridian (less one square for truncated references)
+	_, bottom := tm.forward(float64(-80+8*band), tm.lon0)
+	for n < bottom-100000 {
+		n += 2000000
+	}
+	lat, lon = tm.inverse(e, n)
+	return lat, lon, nil
+}
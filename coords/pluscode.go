@@ -0,0 +1,73 @@
+package coords
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	plusAlphabet  = "23456789CFGHJMPQRVWX"
+	plusSeparator = 8 // position of '+' in a full code
+)
+
+// isPlusCode reports whether s is shaped like an Open Location Code.
+func isPlusCode(s string) bool {
+	i := strings.IndexByte(s, '+')
+	if i < 2 || i > plusSeparator || i%2 != 0 || strings.Count(s, "+") != 1 {
+		return false
+	}
+	for _, r := range strings.ToUpper(strings.Replace(s, "+", "", 1)) {
+		if r != '0' && !strings.ContainsRune(plusAlphabet, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// DecodePlusCode returns the centre of the area of a full Open Location Code,
+// e.g. 9F4MGC4J+2V. Short codes ("GC4J+2V Wrocław") need a reference location
+// and are rejected.
+func DecodePlusCode(s string) (Coordinate, error) {
+	code := strings.ToUpper(strings.TrimSpace(s))
+	if !isPlusCode(code) {
+		return Coordinate{}, fmt.Errorf("invalid plus code: %s", s)
+	}
+	if strings.IndexByte(code, '+') != plusSeparator {
+		return Coordinate{}, fmt.Errorf("short plus code %s needs a reference location, use the full code", s)
+	}
+	digits := strings.Replace(code, "+", "", 1)
+	if pad := strings.IndexByte(digits, '0'); pad >= 0 {
+		if pad%2 != 0 || strings.Trim(digits[pad:], "0") != "" || len(digits) > plusSeparator {
+			return Coordinate{}, fmt.Errorf("invalid plus code padding: %s", s)
+		}
+		digits = digits[:pad]
+	}
+	if len(digits) == plusSeparator+1 {
+		return Coordinate{}, fmt.Errorf("invalid plus code: %s (single digit after +)", s)
+	}
+	if strings.IndexRune(plusAlphabet, rune(digits[0])) > 8 || strings.IndexRune(plusAlphabet, rune(digits[1])) > 17 {
+		return Coordinate{}, fmt.Errorf("plus code out of range: %s", s)
+	}
+
+	lat, lon := -90.0, -180.0
+	latRes, lonRes := 400.0, 400.0
+	for i := 0; i < len(digits) && i < 10; i += 2 {
+		latRes, lonRes = latRes/20, lonRes/20
+		lat += float64(strings.IndexByte(plusAlphabet, digits[i])) * latRes
+		lon += float64(strings.IndexByte(plusAlphabet, digits[i+1])) * lonRes
+	}
+	for i := 10; i < len(digits) && i < 15; i++ { // grid refinement, 5 rows x 4 columns
+		v := strings.IndexByte(plusAlphabet, digits[i])
+		latRes, lonRes = latRes/5, lonRes/4
+		lat += float64(v/4) * latRes
+		lon += float64(v%4) * lonRes
+	}
+
+	lat, lon = lat+latRes/2, lon+lonRes/2
+	return Coordinate{
+		Lat:          lat,
+		Lon:          lon,
+		Format:       FormatPlusCode,
+		UncertaintyM: cellUncertainty(lat, latRes, lonRes),
+	}, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/michalswi/osm/geo"
+)
+
+// country is one country polygon of the offline boundaries file.
+type country struct {
+	Code     string // ISO 3166-1 alpha-2
+	Name     string
+	shape    geo.MultiPolygon
+	geometry *geo.Geometry // as read, for drawing
+	sw, ne   geo.Point
+}
+
+// countryIndex is a GeoJSON FeatureCollection of country polygons (Natural Earth
+// admin-0 or similar), reloaded when the file changes on disk.
+type countryIndex struct {
+	mu        sync.Mutex
+	path      string
+	modTime   time.Time
+	countries []country
+}
+
+// countryProps are the property names used for the ISO code and name by the
+// common country boundary datasets.
+var countryProps = struct{ code, name []string }{
+	code: []string{"ISO_A2_EH", "ISO_A2", "iso_a2", "ISO3166-1-Alpha-2", "iso2"},
+	name: []string{"NAME", "ADMIN", "name", "NAME_EN"},
+}
+
+// get returns the loaded countries; nil when no file is configured.
+func (ci *countryIndex) get() ([]country, error) {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+
+	if ci.path == "" {
+		return nil, nil
+	}
+	fi, err := os.Stat(ci.path)
+	if err != nil {
+		return nil, err
+	}
+	if ci.countries != nil && fi.ModTime().Equal(ci.modTime) {
+		return ci.countries, nil
+	}
+
+	data, err := os.ReadFile(ci.path)
+	if err != nil {
+		return nil, err
+	}
+	countries, err := readCountries(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", ci.path, err)
+	}
+	ci.countries = countries
+	ci.modTime = fi.ModTime()
+	logger.Printf("Loaded %d country polygons from %s", len(countries), ci.path)
+	return countries, nil
+}
+
+// readCountries parses a FeatureCollection; features without a usable ISO code
+// (Natural Earth uses "-99") are skipped.
+func readCountries(data []byte) ([]country, error) {
+	var fc struct {
+		Features []struct {
+			Properties map[string]any `json:"properties"`
+			Geometry   *geo.Geometry  `json:"geometry"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal(data, &fc); err != nil {
+		return nil, fmt.Errorf("invalid GeoJSON: %v", err)
+	}
+	prop := func(props map[string]any, keys []string) string {
+		for _, k := range keys {
+			if s, ok := props[k].(string); ok && s != "" && s != "-99" {
+				return s
+			}
+		}
+		return ""
+	}
+
+	countries := []country{}
+	for _, f := range fc.Features {
+		code := strings.ToUpper(prop(f.Properties, countryProps.code))
+		if len(code) != 2 || f.Geometry == nil {
+			continue
+		}
+		shape, err := f.Geometry.Polygons()
+		if err != nil {
+			return nil, fmt.Errorf("country %s: %v", code, err)
+		}
+		c := country{Code: code, Name: prop(f.Properties, countryProps.name), shape: shape, geometry: f.Geometry}
+		c.sw, c.ne = shape.Bounds()
+		countries = append(countries, c)
+	}
+	return countries, nil
+}
+
+// countryAt returns the country containing p, or nil.
+func countryAt(countries []country, p geo.Point) *country {
+	for i := range countries {
+		c := &countries[i]
+		if p.Lat < c.sw.Lat || p.Lat > c.ne.Lat || p.Lon < c.sw.Lon || p.Lon > c.ne.Lon {
+			continue
+		}
+		if c.shape.Contains(p) {
+			return c
+		}
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/michalswi/osm/geo"
+	"github.com/michalswi/osm/geoip"
+)
+
+// visitorPoints are the geolocated client addresses of requests.log, rebuilt
+// when the log changes.
+type visitorPoints struct {
+	mu      sync.Mutex
+	modTime time.Time
+	points  []geo.Point
+}
+
+var visitors = &visitorPoints{}
+
+// requestIP returns the client address of a logged request: the first
+// X-Forwarded-For entry, else the remote address.
+func requestIP(req Request) string {
+	if xff := req.XForwardedFor; xff != "" && xff != "N/A" {
+		first, _, _ := strings.Cut(xff, ",")
+		return strings.TrimSpace(first)
+	}
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
+	}
+	return req.RemoteAddr
+}
+
+// get returns one point per logged request whose address has a location.
+func (v *visitorPoints) get() ([]geo.Point, error) {
+	if geoDB == nil {
+		return nil, fmt.Errorf("no GeoIP database configured (GEOIP_DB)")
+	}
+	path := logPath + "/requests.log"
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return []geo.Point{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if v.points != nil && fi.ModTime().Equal(v.modTime) {
+		return v.points, nil
+	}
+
+	logMutex.Lock()
+	data, err := os.ReadFile(path)
+	logMutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	var requests []Request
+	if err := json.Unmarshal(data, &requests); err != nil {
+		return nil, fmt.Errorf("invalid requests.log: %v", err)
+	}
+
+	seen := map[string]*geo.Point{}
+	points := []geo.Point{}
+	for _, req := range requests {
+		ip := requestIP(req)
+		p, ok := seen[ip]
+		if !ok {
+			if addr, err := geoip.ParseAddr(ip); err == nil {
+				if rec, found := geoDB.Lookup(addr); found && rec.HasLocation {
+					p = &geo.Point{Lat: rec.Lat, Lon: rec.Lon}
+				}
+			}
+			seen[ip] = p
+		}
+		if p != nil {
+			points = append(points, *p)
+		}
+	}
+	v.points, v.modTime = points, fi.ModTime()
+	return points, nil
+}
+
+// inBBox reports whether p lies in the box, which may cross the antimeridian.
+func inBBox(p, sw, ne geo.Point) bool {
+	if p.Lat < sw.Lat || p.Lat > ne.Lat {
+		return false
+	}
+	if sw.Lon <= ne.Lon {
+		return p.Lon >= sw.Lon && p.Lon <= ne.Lon
+	}
+	return p.Lon >= sw.Lon || p.Lon <= ne.Lon
+}
+
+// apiDensity aggregates locations (with the usual filter params, weighted by
+// the numeric extra field named in ?weight=) or visitors into hex or square
+// bins for ?zoom=, limited to ?bbox=south,west,north,east.
+func apiDensity(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	zoom := 5
+	if s := q.Get("zoom"); s != "" {
+		z, err := strconv.Atoi(s)
+		if err != nil || z < 0 || z > 22 {
+			http.Error(w, "invalid zoom", http.StatusBadRequest)
+			return
+		}
+		zoom = z
+	}
+	shape := q.Get("bin")
+	if shape == "" {
+		shape = "hex"
+	}
+	sw, ne := geo.Point{Lat: -90, Lon: -180}, geo.Point{Lat: 90, Lon: 180}
+	if s := q.Get("bbox"); s != "" {
+		var err error
+		if sw, ne, err = parseBBox(s); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var pts []geo.WeightedPoint
+	source := q.Get("source")
+	switch source {
+	case "", "locations":
+		source = "locations"
+		filter, err := parseLocationFilter(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		key := q.Get("weight")
+		for _, loc := range filterLocations(getCachedLocations(), filter) {
+			weight := 1.0
+			if key != "" {
+				v, err := strconv.ParseFloat(loc.Extra[key], 64)
+				if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+					continue // no usable weight
+				}
+				weight = v
+			}
+			pts = append(pts, geo.WeightedPoint{Point: geo.Point{Lat: loc.Lat, Lon: loc.Lon}, Weight: weight})
+		}
+	case "visitors":
+		points, err := visitors.get()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		for _, p := range points {
+			pts = append(pts, geo.WeightedPoint{Point: p, Weight: 1})
+		}
+	default:
+		http.Error(w, "invalid source (want locations or visitors)", http.StatusBadRequest)
+		return
+	}
+
+	inside := pts[:0]
+	for _, p := range pts {
+		if inBBox(p.Point, sw, ne) {
+			inside = append(inside, p)
+		}
+	}
+	bins, err := geo.BinPoints(inside, shape, zoom)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var max, total float64
+	for _, b := range bins {
+		total += b.Weight
+		if b.Weight > max {
+			max = b.Weight
+		}
+	}
+	writeJSON(w, struct {
+		Source string    `json:"source"`
+		Bin    string    `json:"bin"`
+		Zoom   int       `json:"zoom"`
+		CellM  float64   `json:"cell_m"` // cell width in Web Mercator metres
+		Max    float64   `json:"max"`
+		Total  float64   `json:"total"`
+		Bins   []geo.Bin `json:"bins"`
+	}{source, shape, zoom, geo.BinCellSize(zoom), max, total, bins})
+}
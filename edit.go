@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/michalswi/osm/geoip"
+)
+
+// authorizeWrite checks the bearer token of a location write. Writes are
+// disabled unless API_TOKEN is set.
+func authorizeWrite(w http.ResponseWriter, r *http.Request) bool {
+	if apiToken == "" {
+		http.Error(w, "location writes disabled (set API_TOKEN)", http.StatusForbidden)
+		return false
+	}
+	if !validToken(r) {
+		http.Error(w, "invalid or missing bearer token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// validToken reports whether the request carries the API_TOKEN bearer token.
+func validToken(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return apiToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(apiToken)) == 1
+}
+
+// validateLocation checks that an entry would be loaded: a parsable location,
+// prefix or geometry and a valid validity range.
+func validateLocation(loc Location) error {
+	if _, _, err := parseValidity(loc); err != nil {
+		return err
+	}
+	if loc.Geometry != nil {
+		_, err := newClientArea(loc)
+		return err
+	}
+	if loc.Location == "" && loc.Prefix != "" {
+		if _, err := geoip.ParseAddr(loc.Prefix); err != nil {
+			return fmt.Errorf("invalid prefix: %s", loc.Prefix)
+		}
+		return nil
+	}
+	_, _, err := locationPoint(loc)
+	return err
+}
+
+// apiLocationsWrite handles POST (create), PUT ?id= (replace) and DELETE ?id=
+// on /api/locations.
+func apiLocationsWrite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorizeWrite(w, r) {
+		return
+	}
+	locationWriteMu.Lock()
+	defer locationWriteMu.Unlock()
+
+	id := r.URL.Query().Get("id")
+	if id == "" && r.Method != http.MethodPost {
+		http.Error(w, "missing id parameter", http.StatusBadRequest)
+		return
+	}
+	if r.Method == http.MethodDelete {
+		setWriteAuthor(r, "delete "+id)
+		if err := locationStore.Delete(id); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		recordLocationChange(requestAuthor(r), "api", "delete "+id)
+		invalidateLocationsCache()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var loc Location
+	if err := json.NewDecoder(r.Body).Decode(&loc); err != nil {
+		http.Error(w, fmt.Sprintf("invalid JSON body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := validateLocation(loc); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	status := http.StatusOK
+	switch r.Method {
+	case http.MethodPost:
+		if loc.ID == "" {
+			loc.ID = newLocationID()
+		}
+		if _, err := locationStore.Get(loc.ID); err == nil {
+			http.Error(w, "location already exists: "+loc.ID, http.StatusConflict)
+			return
+		}
+		status = http.StatusCreated
+	case http.MethodPut:
+		if _, err := locationStore.Get(id); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		loc.ID = id
+	}
+
+	message := "update " + loc.ID
+	if status == http.StatusCreated {
+		message = "create " + loc.ID
+	}
+	setWriteAuthor(r, message)
+	if err := locationStore.Put(loc); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	recordLocationChange(requestAuthor(r), "api", message)
+	invalidateLocationsCache()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(loc)
+}
+
+// setWriteAuthor passes the editor and a description of the next write to
+// stores that record them (git commits).
+func setWriteAuthor(r *http.Request, message string) {
+	if s, ok := locationStore.(authoredStore); ok {
+		s.SetAuthor(requestAuthor(r), message)
+	}
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errLocationNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, errMissingID) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	logger.Printf("Location store error: %v", err)
+	http.Error(w, "location store error", http.StatusInternalServerError)
+}
+
+// runMigrate copies the content of one store into another:
+//
+//	osm migrate -from file:source/locations.json -to kv:data/locations.db
+//
+// Entries are merged by id: ones already in the target are replaced, the rest
+// are kept. With -replace the target ends up holding only the source entries.
+// Entries without an id get one, as every store but the JSON file needs it.
+func runMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := fs.String("from", "file:"+sourceJson, "source store (kind:path)")
+	to := fs.String("to", "", "target store (kind:path)")
+	replace := fs.Bool("replace", false, "drop target entries missing from the source")
+	fs.Parse(args)
+	if *to == "" {
+		return fmt.Errorf("missing -to")
+	}
+
+	src, err := openStore(*from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := openStore(*to)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	locs, err := src.List()
+	if err != nil {
+		return err
+	}
+	for i := range locs {
+		if locs[i].ID == "" {
+			locs[i].ID = newLocationID()
+		}
+	}
+
+	merged := locs
+	if !*replace {
+		if merged, err = dst.List(); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		merged = mergeLocations(merged, locs)
+	}
+	// one Replace, so the git store records a single commit
+	if err := dst.Replace(merged); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "migrated %d locations from %s to %s (%d in target)\n", len(locs), *from, *to, len(merged))
+	return nil
+}
+
+// mergeLocations returns base with the entries of add replacing the ones with
+// the same id in place, and the others appended.
+func mergeLocations(base, add []Location) []Location {
+	at := make(map[string]int, len(base))
+	for i, loc := range base {
+		if loc.ID != "" {
+			at[loc.ID] = i
+		}
+	}
+	out := append([]Location(nil), base...)
+	for _, loc := range add {
+		if i, ok := at[loc.ID]; ok {
+			out[i] = loc
+			continue
+		}
+		at[loc.ID] = len(out)
+		out = append(out, loc)
+	}
+	return out
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// locationFilter selects locations by tags, category, country and validity time.
+// A tag prefixed with "!" excludes locations carrying that tag.
+type locationFilter struct {
+	Tags        []string   `json:"tags"`
+	ExcludeTags []string   `json:"exclude_tags"`
+	Categories  []string   `json:"categories"`
+	Countries   []string   `json:"countries"`
+	At          *time.Time `json:"at,omitempty"`
+}
+
+// parseLocationFilter builds a filter from repeated tag, category and country query
+// params and an optional at timestamp, e.g. ?tag=ix&tag=!decommissioned&category=pop&country=PL&at=2024-01-01.
+func parseLocationFilter(q url.Values) (locationFilter, error) {
+	f := locationFilter{Tags: []string{}, ExcludeTags: []string{}, Categories: []string{}, Countries: []string{}}
+	for _, t := range q["tag"] {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if strings.HasPrefix(t, "!") {
+			if t = strings.TrimSpace(t[1:]); t != "" {
+				f.ExcludeTags = append(f.ExcludeTags, t)
+			}
+			continue
+		}
+		if t != "" {
+			f.Tags = append(f.Tags, t)
+		}
+	}
+	for _, c := range q["category"] {
+		if c = strings.ToLower(strings.TrimSpace(c)); c != "" {
+			f.Categories = append(f.Categories, c)
+		}
+	}
+	for _, c := range q["country"] {
+		if c = strings.ToUpper(strings.TrimSpace(c)); c != "" {
+			f.Countries = append(f.Countries, c)
+		}
+	}
+	if at := strings.TrimSpace(q.Get("at")); at != "" {
+		t, err := parseTimestamp(at)
+		if err != nil {
+			return f, err
+		}
+		f.At = &t
+	}
+	return f, nil
+}
+
+// parseTimestamp accepts RFC 3339 timestamps, plain dates (2006-01-02) and unix seconds.
+func parseTimestamp(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(n, 0).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp: %s", s)
+}
+
+// empty reports whether the filter lets every location through.
+func (f locationFilter) empty() bool {
+	return len(f.Tags) == 0 && len(f.ExcludeTags) == 0 && len(f.Categories) == 0 && len(f.Countries) == 0 && f.At == nil
+}
+
+// match reports whether loc carries all required tags, none of the excluded ones,
+// (if any categories are given) one of the categories, lies in one of the
+// countries (if given) and was valid at f.At.
+func (f locationFilter) match(loc ClientLocation) bool {
+	return f.matchValidity(loc.ValidFrom, loc.ValidTo) && f.matchTags(loc.Tags, loc.Category) &&
+		(len(f.Countries) == 0 || slices.Contains(f.Countries, loc.placeCountry()))
+}
+
+// matchValidity reports whether the validity range [from, to) contains f.At.
+// Open ends are unbounded; without f.At everything matches.
+func (f locationFilter) matchValidity(from, to *time.Time) bool {
+	if f.At == nil {
+		return true
+	}
+	if from != nil && f.At.Before(*from) {
+		return false
+	}
+	if to != nil && !f.At.Before(*to) {
+		return false
+	}
+	return true
+}
+
+// matchTags applies the filter to a set of tags and a category.
+func (f locationFilter) matchTags(tags []string, category string) bool {
+	has := make(map[string]bool, len(tags))
+	for _, t := range tags {
+		has[strings.ToLower(t)] = true
+	}
+	for _, t := range f.Tags {
+		if !has[t] {
+			return false
+		}
+	}
+	for _, t := range f.ExcludeTags {
+		if has[t] {
+			return false
+		}
+	}
+	if len(f.Categories) == 0 {
+		return true
+	}
+	category = strings.ToLower(category)
+	for _, c := range f.Categories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// filterLocations returns the locations matching f.
+func filterLocations(locs []ClientLocation, f locationFilter) []ClientLocation {
+	if f.empty() {
+		return locs
+	}
+	out := []ClientLocation{}
+	for _, loc := range locs {
+		if f.match(loc) {
+			out = append(out, loc)
+		}
+	}
+	return out
+}
+
+// filterAreas returns the areas matching f.
+func filterAreas(areas []ClientArea, f locationFilter) []ClientArea {
+	if f.empty() {
+		return areas
+	}
+	out := []ClientArea{}
+	for _, a := range areas {
+		if f.matchValidity(a.ValidFrom, a.ValidTo) && f.matchTags(a.Tags, a.Category) {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// timeRange is the span covered by the validity ranges of the locations, driving the timeline slider.
+type timeRange struct {
+	From *time.Time `json:"from"`
+	To   *time.Time `json:"to"`
+}
+
+// locationsTimeRange returns the earliest and latest validity bound found in locs.
+func locationsTimeRange(locs []ClientLocation) timeRange {
+	var tr timeRange
+	extend := func(t *time.Time) {
+		if t == nil {
+			return
+		}
+		if tr.From == nil || t.Before(*tr.From) {
+			tr.From = t
+		}
+		if tr.To == nil || t.After(*tr.To) {
+			tr.To = t
+		}
+	}
+	for _, loc := range locs {
+		extend(loc.ValidFrom)
+		extend(loc.ValidTo)
+	}
+	return tr
+}
+
+// countryFacet is a country offered by the filter panel.
+type countryFacet struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+// locationFacets lists the distinct tags, categories and countries of locs, used to build the filter panel.
+func locationFacets(locs []ClientLocation) (tags, categories []string, countries []countryFacet) {
+	seenTags := map[string]bool{}
+	seenCategories := map[string]bool{}
+	seenCountries := map[string]bool{}
+	tags = []string{}
+	categories = []string{}
+	countries = []countryFacet{}
+	for _, loc := range locs {
+		if c := loc.placeCountry(); c != "" && !seenCountries[c] {
+			seenCountries[c] = true
+			name := c
+			if loc.Place != nil && loc.Place.CountryName != "" {
+				name = loc.Place.CountryName
+			}
+			countries = append(countries, countryFacet{Code: c, Name: name})
+		}
+		for _, t := range loc.Tags {
+			t = strings.ToLower(t)
+			if !seenTags[t] {
+				seenTags[t] = true
+				tags = append(tags, t)
+			}
+		}
+		if c := strings.ToLower(loc.Category); c != "" && !seenCategories[c] {
+			seenCategories[c] = true
+			categories = append(categories, c)
+		}
+	}
+	sort.Strings(tags)
+	sort.Strings(categories)
+	sort.Slice(countries, func(i, j int) bool { return countries[i].Name < countries[j].Name })
+	return tags, categories, countries
+}
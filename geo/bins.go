@@ -0,0 +1,115 @@
+package geo
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// binPixels is the size of a density cell on screen, in 256 px tile pixels.
+const binPixels = 48
+
+// Bin is one cell of a density grid with the number and summed weight of the
+// points falling into it.
+type Bin struct {
+	ID     string       `json:"id"`
+	Lat    float64      `json:"lat"` // cell centre
+	Lon    float64      `json:"lon"`
+	Ring   [][2]float64 `json:"ring"` // cell outline as [lat, lon] pairs
+	Count  int          `json:"count"`
+	Weight float64      `json:"weight"`
+}
+
+// WeightedPoint is a point counted with a weight.
+type WeightedPoint struct {
+	Point
+	Weight float64
+}
+
+// BinCellSize returns the cell width in Web Mercator metres used at a zoom level.
+func BinCellSize(zoom int) float64 {
+	return 2 * math.Pi * WGS84A / math.Exp2(float64(zoom)) * binPixels / 256
+}
+
+// BinPoints aggregates points into "hex" (pointy-top hexagons) or "square"
+// cells laid out in Web Mercator, sized for the zoom level. Bins are returned
+// heaviest first.
+func BinPoints(pts []WeightedPoint, shape string, zoom int) ([]Bin, error) {
+	if shape != "hex" && shape != "square" {
+		return nil, fmt.Errorf("invalid bin shape: %s (want hex or square)", shape)
+	}
+	cell := BinCellSize(zoom)
+	size := cell / math.Sqrt(3) // hexagon centre to vertex, width = cell
+
+	bins := map[[2]int]*Bin{}
+	for _, p := range pts {
+		x, y := mercator(p.Point)
+		var key [2]int
+		if shape == "square" {
+			key = [2]int{int(math.Floor(x / cell)), int(math.Floor(y / cell))}
+		} else {
+			key = hexRound((math.Sqrt(3)/3*x-y/3)/size, 2.0/3*y/size)
+		}
+		b := bins[key]
+		if b == nil {
+			b = &Bin{}
+			var cx, cy float64
+			if shape == "square" {
+				cx, cy = (float64(key[0])+0.5)*cell, (float64(key[1])+0.5)*cell
+				b.ID = fmt.Sprintf("s%d:%d:%d", zoom, key[0], key[1])
+				for _, c := range [][2]float64{{-1, -1}, {1, -1}, {1, 1}, {-1, 1}} {
+					b.Ring = append(b.Ring, inverseMercatorPair(cx+c[0]*cell/2, cy+c[1]*cell/2))
+				}
+			} else {
+				cx = size * math.Sqrt(3) * (float64(key[0]) + float64(key[1])/2)
+				cy = size * 1.5 * float64(key[1])
+				b.ID = fmt.Sprintf("h%d:%d:%d", zoom, key[0], key[1])
+				for i := 0; i < 6; i++ {
+					a := rad(float64(60*i - 30))
+					b.Ring = append(b.Ring, inverseMercatorPair(cx+size*math.Cos(a), cy+size*math.Sin(a)))
+				}
+			}
+			c := inverseMercatorPair(cx, cy)
+			b.Lat, b.Lon = c[0], c[1]
+			bins[key] = b
+		}
+		b.Count++
+		b.Weight += p.Weight
+	}
+
+	out := make([]Bin, 0, len(bins))
+	for _, b := range bins {
+		out = append(out, *b)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Weight != out[j].Weight {
+			return out[i].Weight > out[j].Weight
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out, nil
+}
+
+// hexRound rounds fractional axial hex coordinates to the containing hexagon.
+func hexRound(q, r float64) [2]int {
+	s := -q - r
+	rq, rr, rs := math.Round(q), math.Round(r), math.Round(s)
+	dq, dr, ds := math.Abs(rq-q), math.Abs(rr-r), math.Abs(rs-s)
+	switch {
+	case dq > dr && dq > ds:
+		rq = -rr - rs
+	case dr > ds:
+		rr = -rq - rs
+	}
+	return [2]int{int(rq), int(rr)}
+}
+
+// mercator projects p to spherical Web Mercator metres (EPSG:3857).
+func mercator(p Point) (x, y float64) {
+	lat := math.Max(-85.05112878, math.Min(85.05112878, p.Lat))
+	return WGS84A * rad(p.Lon), WGS84A * math.Log(math.Tan(math.Pi/4+rad(lat)/2))
+}
+
+func inverseMercatorPair(x, y float64) [2]float64 {
+	return [2]float64{deg(2*math.Atan(math.Exp(y/WGS84A)) - math.Pi/2), deg(x / WGS84A)}
+}
@@ -0,0 +1,299 @@
+package geo
+
+import "math"
+
+// WGS84 ellipsoid parameters.
+const (
+	WGS84A = 6378137.0
+	WGS84F = 1 / 298.257223563
+	WGS84B = WGS84A * (1 - WGS84F)
+
+	// EarthRadius is the mean earth radius in metres used by the spherical formulas.
+	EarthRadius = 6371008.8
+)
+
+// Point is a WGS84 position in decimal degrees.
+type Point struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+func rad(deg float64) float64 { return deg * math.Pi / 180 }
+func deg(rad float64) float64 { return rad * 180 / math.Pi }
+
+// normBearing maps a bearing in degrees to [0, 360).
+func normBearing(b float64) float64 {
+	b = math.Mod(b, 360)
+	if b < 0 {
+		b += 360
+	}
+	return b
+}
+
+// Haversine returns the great-circle distance in metres between a and b on a sphere.
+func Haversine(a, b Point) float64 {
+	dLat := rad(b.Lat - a.Lat)
+	dLon := rad(b.Lon - a.Lon)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(rad(a.Lat))*math.Cos(rad(b.Lat))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * EarthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// Bearing returns the initial great-circle bearing from a to b in degrees.
+func Bearing(a, b Point) float64 {
+	lat1, lat2 := rad(a.Lat), rad(b.Lat)
+	dLon := rad(b.Lon - a.Lon)
+	y := math.Sin(dLon) * math.Cos(lat2)
+	x := math.Cos(lat1)*math.Sin(lat2) - math.Sin(lat1)*math.Cos(lat2)*math.Cos(dLon)
+	return normBearing(deg(math.Atan2(y, x)))
+}
+
+// Inverse solves the inverse geodesic problem on the WGS84 ellipsoid (Vincenty).
+// It returns the distance in metres and the initial and final bearings in degrees.
+// For nearly antipodal points where the iteration does not converge it falls back
+// to the spherical solution.
+func Inverse(a, b Point) (dist, initial, final float64) {
+	if a == b {
+		return 0, 0, 0
+	}
+
+	L := rad(b.Lon - a.Lon)
+	U1 := math.Atan((1 - WGS84F) * math.Tan(rad(a.Lat)))
+	U2 := math.Atan((1 - WGS84F) * math.Tan(rad(b.Lat)))
+	sinU1, cosU1 := math.Sincos(U1)
+	sinU2, cosU2 := math.Sincos(U2)
+
+	lambda := L
+	var sinSigma, cosSigma, sigma, cosSqAlpha, cos2SigmaM, sinLambda, cosLambda float64
+	converged := false
+	for i := 0; i < 200; i++ {
+		sinLambda, cosLambda = math.Sincos(lambda)
+		sinSigma = math.Sqrt((cosU2*sinLambda)*(cosU2*sinLambda) +
+			(cosU1*sinU2-sinU1*cosU2*cosLambda)*(cosU1*sinU2-sinU1*cosU2*cosLambda))
+		if sinSigma == 0 {
+			return 0, 0, 0
+		}
+		cosSigma = sinU1*sinU2 + cosU1*cosU2*cosLambda
+		sigma = math.Atan2(sinSigma, cosSigma)
+		sinAlpha := cosU1 * cosU2 * sinLambda / sinSigma
+		cosSqAlpha = 1 - sinAlpha*sinAlpha
+		cos2SigmaM = 0
+		if cosSqAlpha != 0 {
+			cos2SigmaM = cosSigma - 2*sinU1*sinU2/cosSqAlpha
+		}
+		C := WGS84F / 16 * cosSqAlpha * (4 + WGS84F*(4-3*cosSqAlpha))
+		prev := lambda
+		lambda = L + (1-C)*WGS84F*sinAlpha*
+			(sigma+C*sinSigma*(cos2SigmaM+C*cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)))
+		if math.Abs(lambda-prev) < 1e-12 {
+			converged = true
+			break
+		}
+	}
+	if !converged {
+		return Haversine(a, b), Bearing(a, b), normBearing(Bearing(b, a) + 180)
+	}
+
+	uSq := cosSqAlpha * (WGS84A*WGS84A - WGS84B*WGS84B) / (WGS84B * WGS84B)
+	A := 1 + uSq/16384*(4096+uSq*(-768+uSq*(320-175*uSq)))
+	B := uSq / 1024 * (256 + uSq*(-128+uSq*(74-47*uSq)))
+	deltaSigma := B * sinSigma * (cos2SigmaM + B/4*(cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)-
+		B/6*cos2SigmaM*(-3+4*sinSigma*sinSigma)*(-3+4*cos2SigmaM*cos2SigmaM)))
+
+	dist = WGS84B * A * (sigma - deltaSigma)
+	initial = normBearing(deg(math.Atan2(cosU2*sinLambda, cosU1*sinU2-sinU1*cosU2*cosLambda)))
+	final = normBearing(deg(math.Atan2(cosU1*sinLambda, -sinU1*cosU2+cosU1*sinU2*cosLambda)))
+	return dist, initial, final
+}
+
+// Vincenty returns the ellipsoidal distance in metres between a and b.
+func Vincenty(a, b Point) float64 {
+	d, _, _ := Inverse(a, b)
+	return d
+}
+
+// Direct solves the direct geodesic problem on the WGS84 ellipsoid (Vincenty): it returns
+// the point reached from p after dist metres along initial bearing brng (degrees).
+func Direct(p Point, brng, dist float64) Point {
+	alpha1 := rad(brng)
+	sinAlpha1, cosAlpha1 := math.Sincos(alpha1)
+
+	tanU1 := (1 - WGS84F) * math.Tan(rad(p.Lat))
+	cosU1 := 1 / math.Sqrt(1+tanU1*tanU1)
+	sinU1 := tanU1 * cosU1
+	sigma1 := math.Atan2(tanU1, cosAlpha1)
+	sinAlpha := cosU1 * sinAlpha1
+	cosSqAlpha := 1 - sinAlpha*sinAlpha
+	uSq := cosSqAlpha * (WGS84A*WGS84A - WGS84B*WGS84B) / (WGS84B * WGS84B)
+	A := 1 + uSq/16384*(4096+uSq*(-768+uSq*(320-175*uSq)))
+	B := uSq / 1024 * (256 + uSq*(-128+uSq*(74-47*uSq)))
+
+	sigma := dist / (WGS84B * A)
+	var sinSigma, cosSigma, cos2SigmaM float64
+	for i := 0; i < 200; i++ {
+		cos2SigmaM = math.Cos(2*sigma1 + sigma)
+		sinSigma, cosSigma = math.Sincos(sigma)
+		deltaSigma := B * sinSigma * (cos2SigmaM + B/4*(cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)-
+			B/6*cos2SigmaM*(-3+4*sinSigma*sinSigma)*(-3+4*cos2SigmaM*cos2SigmaM)))
+		prev := sigma
+		sigma = dist/(WGS84B*A) + deltaSigma
+		if math.Abs(sigma-prev) < 1e-12 {
+			break
+		}
+	}
+	cos2SigmaM = math.Cos(2*sigma1 + sigma)
+	sinSigma, cosSigma = math.Sincos(sigma)
+
+	x := sinU1*sinSigma - cosU1*cosSigma*cosAlpha1
+	lat2 := math.Atan2(sinU1*cosSigma+cosU1*sinSigma*cosAlpha1, (1-WGS84F)*math.Sqrt(sinAlpha*sinAlpha+x*x))
+	lambda := math.Atan2(sinSigma*sinAlpha1, cosU1*cosSigma-sinU1*sinSigma*cosAlpha1)
+	C := WGS84F / 16 * cosSqAlpha * (4 + WGS84F*(4-3*cosSqAlpha))
+	L := lambda - (1-C)*WGS84F*sinAlpha*(sigma+C*sinSigma*(cos2SigmaM+C*cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)))
+
+	lon2 := math.Mod(rad(p.Lon)+L+3*math.Pi, 2*math.Pi) - math.Pi
+	return Point{Lat: deg(lat2), Lon: deg(lon2)}
+}
+
+// Path measures a polyline on the ellipsoid.
+type Path struct {
+	Segments       []Segment `json:"segments"`
+	Length         float64   `json:"length_m"`
+	InitialBearing float64   `json:"initial_bearing"`
+	FinalBearing   float64   `json:"final_bearing"`
+	Midpoint       Point     `json:"midpoint"`
+}
+
+// Segment is one geodesic leg of a Path.
+type Segment struct {
+	From           Point   `json:"from"`
+	To             Point   `json:"to"`
+	Length         float64 `json:"length_m"`
+	InitialBearing float64 `json:"initial_bearing"`
+	FinalBearing   float64 `json:"final_bearing"`
+}
+
+// MeasurePath returns the geodesic length of the polyline through pts, the bearings
+// at its start and end and the point halfway along it. pts must not be empty.
+func MeasurePath(pts []Point) Path {
+	path := Path{Segments: []Segment{}, Midpoint: pts[0]}
+	for i := 1; i < len(pts); i++ {
+		d, ib, fb := Inverse(pts[i-1], pts[i])
+		path.Segments = append(path.Segments, Segment{From: pts[i-1], To: pts[i], Length: d, InitialBearing: ib, FinalBearing: fb})
+		path.Length += d
+	}
+	if len(path.Segments) == 0 {
+		return path
+	}
+	path.InitialBearing = path.Segments[0].InitialBearing
+	path.FinalBearing = path.Segments[len(path.Segments)-1].FinalBearing
+
+	half := path.Length / 2
+	for _, s := range path.Segments {
+		if half <= s.Length {
+			path.Midpoint = Direct(s.From, s.InitialBearing, half)
+			break
+		}
+		half -= s.Length
+	}
+	return path
+}
+
+// authalicLat maps a geodetic latitude (radians) to the authalic latitude, i.e. the
+// latitude on the sphere of equal surface area.
+func authalicLat(phi float64) float64 {
+	e2 := WGS84F * (2 - WGS84F)
+	e := math.Sqrt(e2)
+	q := func(sinPhi float64) float64 {
+		return (1 - e2) * (sinPhi/(1-e2*sinPhi*sinPhi) - 1/(2*e)*math.Log((1-e*sinPhi)/(1+e*sinPhi)))
+	}
+	return math.Asin(math.Max(-1, math.Min(1, q(math.Sin(phi))/q(1))))
+}
+
+// authalicRadius is the radius of the sphere with the same surface area as WGS84.
+func authalicRadius() float64 {
+	e2 := WGS84F * (2 - WGS84F)
+	e := math.Sqrt(e2)
+	return WGS84A * math.Sqrt((1+(1-e2)/(2*e)*math.Log((1+e)/(1-e)))/2)
+}
+
+// Area returns the area in square metres of the polygon ring pts (closed or not) on
+// the WGS84 ellipsoid. The ring is mapped to the authalic sphere, where the spherical
+// excess is exact; the remaining error comes only from the edges being geodesics on
+// the ellipsoid rather than great circles on the sphere.
+func Area(pts []Point) float64 {
+	if len(pts) < 3 {
+		return 0
+	}
+	if pts[0] == pts[len(pts)-1] {
+		pts = pts[:len(pts)-1]
+	}
+	// the longitude of a pole is arbitrary; the edge to it runs along the meridian
+	// of the point before
+	pts = append([]Point(nil), pts...)
+	for i, p := range pts {
+		if math.Abs(p.Lat) == 90 {
+			pts[i].Lon = pts[(i+len(pts)-1)%len(pts)].Lon
+		}
+	}
+	R := authalicRadius()
+	var excess float64
+	for i := range pts {
+		a, b := pts[i], pts[(i+1)%len(pts)]
+		phi1, phi2 := authalicLat(rad(a.Lat)), authalicLat(rad(b.Lat))
+		dLambda := math.Remainder(rad(b.Lon-a.Lon), 2*math.Pi)
+		t1, t2 := math.Tan(phi1/2), math.Tan(phi2/2)
+		excess += 2 * math.Atan2(math.Tan(dLambda/2)*(t1+t2), 1+t1*t2)
+	}
+	area := math.Abs(excess) * R * R
+	if total := 4 * math.Pi * R * R; area > total/2 {
+		area = total - area
+	}
+	return area
+}
+
+// Perimeter returns the geodesic length of the closed ring pts in metres.
+func Perimeter(pts []Point) float64 {
+	if len(pts) < 2 {
+		return 0
+	}
+	var p float64
+	for i := range pts {
+		p += Vincenty(pts[i], pts[(i+1)%len(pts)])
+	}
+	return p
+}
+
+// GreatCircle returns n+1 points along the great circle from a to b, including both ends.
+// Longitudes are unwrapped so consecutive points never jump across the antimeridian,
+// which keeps the line drawable as a single polyline.
+func GreatCircle(a, b Point, n int) []Point {
+	lat1, lon1 := rad(a.Lat), rad(a.Lon)
+	lat2, lon2 := rad(b.Lat), rad(b.Lon)
+	d := Haversine(a, b) / EarthRadius
+	if math.Abs(math.Sin(d)) < 1e-12 || n < 1 {
+		// identical or antipodal points: no unique great circle
+		return []Point{a, b}
+	}
+
+	pts := make([]Point, 0, n+1)
+	for i := 0; i <= n; i++ {
+		f := float64(i) / float64(n)
+		A := math.Sin((1-f)*d) / math.Sin(d)
+		B := math.Sin(f*d) / math.Sin(d)
+		x := A*math.Cos(lat1)*math.Cos(lon1) + B*math.Cos(lat2)*math.Cos(lon2)
+		y := A*math.Cos(lat1)*math.Sin(lon1) + B*math.Cos(lat2)*math.Sin(lon2)
+		z := A*math.Sin(lat1) + B*math.Sin(lat2)
+		p := Point{Lat: deg(math.Atan2(z, math.Sqrt(x*x+y*y))), Lon: deg(math.Atan2(y, x))}
+		if len(pts) > 0 {
+			prev := pts[len(pts)-1].Lon
+			for p.Lon-prev > 180 {
+				p.Lon -= 360
+			}
+			for p.Lon-prev < -180 {
+				p.Lon += 360
+			}
+		}
+		pts = append(pts, p)
+	}
+	return pts
+}
@@ -0,0 +1,114 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+func dms(d, m, s float64) float64 {
+	if d < 0 {
+		return d - m/60 - s/3600
+	}
+	return d + m/60 + s/3600
+}
+
+// Flinders Peak to Buninyong, the worked example of the Vincenty formulae
+// published by Geoscience Australia.
+var (
+	flinders  = Point{Lat: dms(-37, 57, 3.72030), Lon: dms(144, 25, 29.52440)}
+	buninyong = Point{Lat: dms(-37, 39, 10.15610), Lon: dms(143, 55, 35.38390)}
+)
+
+// totalArea is the surface area of the WGS84 ellipsoid in square metres.
+const totalArea = 510065621724088.5
+
+func TestInverseReference(t *testing.T) {
+	d, initial, final := Inverse(flinders, buninyong)
+	if math.Abs(d-54972.271) > 0.001 {
+		t.Errorf("distance = %.4f m, want 54972.271", d)
+	}
+	if want := dms(306, 52, 5.37); math.Abs(initial-want) > 0.01/3600 {
+		t.Errorf("initial bearing = %.6f, want %.6f", initial, want)
+	}
+	if want := dms(307, 10, 25.07); math.Abs(final-want) > 0.01/3600 {
+		t.Errorf("final bearing = %.6f, want %.6f", final, want)
+	}
+
+	p := Direct(flinders, initial, d)
+	if math.Abs(p.Lat-buninyong.Lat) > 1e-8 || math.Abs(p.Lon-buninyong.Lon) > 1e-8 {
+		t.Errorf("Direct = %+v, want %+v", p, buninyong)
+	}
+	if d, _, _ := Inverse(flinders, flinders); d != 0 {
+		t.Errorf("distance to itself = %f", d)
+	}
+}
+
+func TestInverseAntipodal(t *testing.T) {
+	tests := []struct {
+		a, b Point
+		want float64
+	}{
+		// a quarter meridian twice: the geodesic runs over a pole
+		{Point{0, 0}, Point{0, 180}, 20003931.4586},
+		// Karney, Algorithms for geodesics (2013), where Vincenty does not converge
+		{Point{0, 0}, Point{0.5, 179.5}, 19936288.579},
+	}
+	for _, tt := range tests {
+		d, initial, final := Inverse(tt.a, tt.b)
+		if math.IsNaN(initial) || math.IsNaN(final) {
+			t.Errorf("Inverse(%v, %v) bearings %f, %f", tt.a, tt.b, initial, final)
+		}
+		// the spherical fallback is within a fraction of a percent
+		if math.Abs(d-tt.want)/tt.want > 0.002 {
+			t.Errorf("Inverse(%v, %v) = %.0f m, want about %.0f", tt.a, tt.b, d, tt.want)
+		}
+	}
+}
+
+func TestMeasurePath(t *testing.T) {
+	equatorDeg := WGS84A * math.Pi / 180
+	path := MeasurePath([]Point{{0, 0}, {0, 1}, {0, 3}})
+	if len(path.Segments) != 2 || math.Abs(path.Length-3*equatorDeg) > 1e-6 {
+		t.Errorf("length = %f m in %d segments, want %f", path.Length, len(path.Segments), 3*equatorDeg)
+	}
+	if path.InitialBearing != 90 || path.FinalBearing != 90 {
+		t.Errorf("bearings = %f, %f, want 90", path.InitialBearing, path.FinalBearing)
+	}
+	if math.Abs(path.Midpoint.Lat) > 1e-9 || math.Abs(path.Midpoint.Lon-1.5) > 1e-9 {
+		t.Errorf("midpoint = %+v, want 0,1.5", path.Midpoint)
+	}
+
+	if p := MeasurePath([]Point{flinders}); p.Length != 0 || p.Midpoint != flinders {
+		t.Errorf("single point path = %+v", p)
+	}
+}
+
+func TestArea(t *testing.T) {
+	tests := []struct {
+		name string
+		ring []Point
+		want float64
+	}{
+		// the equator and meridians are geodesics, so these are exact up to
+		// rounding of the authalic latitude near the poles
+		{"octant", []Point{{0, 0}, {0, 90}, {90, 0}}, totalArea / 8},
+		{"closed octant", []Point{{0, 0}, {0, 90}, {90, 0}, {0, 0}}, totalArea / 8},
+		{"clockwise octant", []Point{{0, 0}, {90, 0}, {0, 90}}, totalArea / 8},
+		{"octant across the antimeridian", []Point{{0, 135}, {0, -135}, {-90, 0}}, totalArea / 8},
+		{"line", []Point{{0, 0}, {1, 1}}, 0},
+	}
+	for _, tt := range tests {
+		if got := Area(tt.ring); math.Abs(got-tt.want) > tt.want*1e-7+1e-3 {
+			t.Errorf("%s: Area = %.1f, want %.1f", tt.name, got, tt.want)
+		}
+	}
+
+	// the area does not change when the ring is moved across the antimeridian
+	square := func(lon float64) []Point {
+		return []Point{{50, lon - 5}, {50, lon + 5}, {60, lon + 5}, {60, lon - 5}}
+	}
+	want := Area(square(0))
+	if got := Area(square(180)); math.Abs(got-want) > want*1e-9 {
+		t.Errorf("Area across the antimeridian = %.1f, want %.1f", got, want)
+	}
+}
@@ -0,0 +1,144 @@
+package geo
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Ring is a closed linear ring; the closing point may be omitted.
+type Ring []Point
+
+// Polygon is an outer ring followed by optional holes.
+type Polygon []Ring
+
+// MultiPolygon is a set of polygons forming one area.
+type MultiPolygon []Polygon
+
+// Geometry is a GeoJSON Polygon or MultiPolygon geometry (coordinates in lon,lat order).
+type Geometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+// Polygons decodes the geometry into a MultiPolygon.
+func (g Geometry) Polygons() (MultiPolygon, error) {
+	switch g.Type {
+	case "Polygon":
+		var coords [][][]float64
+		if err := json.Unmarshal(g.Coordinates, &coords); err != nil {
+			return nil, fmt.Errorf("invalid polygon coordinates: %v", err)
+		}
+		p, err := toPolygon(coords)
+		if err != nil {
+			return nil, err
+		}
+		return MultiPolygon{p}, nil
+	case "MultiPolygon":
+		var coords [][][][]float64
+		if err := json.Unmarshal(g.Coordinates, &coords); err != nil {
+			return nil, fmt.Errorf("invalid multipolygon coordinates: %v", err)
+		}
+		mp := make(MultiPolygon, 0, len(coords))
+		for _, c := range coords {
+			p, err := toPolygon(c)
+			if err != nil {
+				return nil, err
+			}
+			mp = append(mp, p)
+		}
+		return mp, nil
+	default:
+		return nil, fmt.Errorf("unsupported geometry type: %s", g.Type)
+	}
+}
+
+func toPolygon(coords [][][]float64) (Polygon, error) {
+	if len(coords) == 0 {
+		return nil, fmt.Errorf("polygon without rings")
+	}
+	p := make(Polygon, 0, len(coords))
+	for _, rc := range coords {
+		if len(rc) < 3 {
+			return nil, fmt.Errorf("ring needs at least 3 positions")
+		}
+		ring := make(Ring, 0, len(rc))
+		for _, pos := range rc {
+			if len(pos) < 2 {
+				return nil, fmt.Errorf("invalid position %v", pos)
+			}
+			if pos[1] < -90 || pos[1] > 90 || pos[0] < -180 || pos[0] > 180 {
+				return nil, fmt.Errorf("position out of range: %v", pos)
+			}
+			ring = append(ring, Point{Lat: pos[1], Lon: pos[0]})
+		}
+		p = append(p, ring)
+	}
+	return p, nil
+}
+
+// Contains reports whether p lies inside the ring (even-odd rule, planar in lon/lat).
+func (r Ring) Contains(p Point) bool {
+	in := false
+	for i, j := 0, len(r)-1; i < len(r); j, i = i, i+1 {
+		a, b := r[i], r[j]
+		if (a.Lat > p.Lat) != (b.Lat > p.Lat) &&
+			p.Lon < (b.Lon-a.Lon)*(p.Lat-a.Lat)/(b.Lat-a.Lat)+a.Lon {
+			in = !in
+		}
+	}
+	return in
+}
+
+// Contains reports whether p lies inside the outer ring and outside every hole.
+func (pg Polygon) Contains(p Point) bool {
+	if len(pg) == 0 || !pg[0].Contains(p) {
+		return false
+	}
+	for _, hole := range pg[1:] {
+		if hole.Contains(p) {
+			return false
+		}
+	}
+	return true
+}
+
+// Contains reports whether p lies inside any of the polygons.
+func (mp MultiPolygon) Contains(p Point) bool {
+	for _, pg := range mp {
+		if pg.Contains(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Bounds returns the bounding box of all outer rings as south-west and north-east corners.
+func (mp MultiPolygon) Bounds() (sw, ne Point) {
+	sw = Point{Lat: 90, Lon: 180}
+	ne = Point{Lat: -90, Lon: -180}
+	for _, pg := range mp {
+		if len(pg) == 0 {
+			continue
+		}
+		for _, pt := range pg[0] {
+			sw.Lat, sw.Lon = min(sw.Lat, pt.Lat), min(sw.Lon, pt.Lon)
+			ne.Lat, ne.Lon = max(ne.Lat, pt.Lat), max(ne.Lon, pt.Lon)
+		}
+	}
+	return sw, ne
+}
+
+// Area returns the geodesic area of the multipolygon in square metres, holes excluded.
+func (mp MultiPolygon) Area() float64 {
+	var a float64
+	for _, pg := range mp {
+		if len(pg) == 0 {
+			continue
+		}
+		a += Area(pg[0])
+		for _, hole := range pg[1:] {
+			a -= Area(hole)
+		}
+	}
+	return a
+}
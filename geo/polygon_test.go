@@ -0,0 +1,60 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+func TestGeometryPolygons(t *testing.T) {
+	// a 10x10 degree square with a 2x2 hole, and a separate triangle
+	g := Geometry{Type: "MultiPolygon", Coordinates: []byte(`[
+		[[[10,40],[20,40],[20,50],[10,50],[10,40]], [[14,44],[16,44],[16,46],[14,46],[14,44]]],
+		[[[30,0],[32,0],[31,2]]]
+	]`)}
+	mp, err := g.Polygons()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mp) != 2 || len(mp[0]) != 2 || len(mp[1]) != 1 {
+		t.Fatalf("decoded %d polygons", len(mp))
+	}
+	for p, want := range map[Point]bool{
+		{45, 12}:    true,
+		{45, 15}:    false, // in the hole
+		{45, 25}:    false,
+		{0.5, 31}:   true,
+		{1.9, 30.1}: false,
+	} {
+		if got := mp.Contains(p); got != want {
+			t.Errorf("Contains(%v) = %v, want %v", p, got, want)
+		}
+	}
+	if sw, ne := mp.Bounds(); sw != (Point{0, 10}) || ne != (Point{50, 32}) {
+		t.Errorf("Bounds = %v, %v", sw, ne)
+	}
+	outer, hole, tri := Area(mp[0][0]), Area(mp[0][1]), Area(mp[1][0])
+	if a := mp.Area(); math.Abs(a-(outer-hole+tri)) > 1e-3 || hole <= 0 || a >= outer+tri {
+		t.Errorf("Area = %f, want %f", a, outer-hole+tri)
+	}
+
+	single := Geometry{Type: "Polygon", Coordinates: []byte(`[[[10,40],[20,40],[20,50]]]`)}
+	if mp, err := single.Polygons(); err != nil || len(mp) != 1 || !mp.Contains(Point{41, 19}) {
+		t.Errorf("Polygon = %v, %v", mp, err)
+	}
+}
+
+func TestGeometryErrors(t *testing.T) {
+	for _, g := range []Geometry{
+		{Type: "Point", Coordinates: []byte(`[1,2]`)},
+		{Type: "Polygon", Coordinates: []byte(`[]`)},
+		{Type: "Polygon", Coordinates: []byte(`[[[1,2],[3,4]]]`)},
+		{Type: "Polygon", Coordinates: []byte(`[[[1,2],[3],[5,6]]]`)},
+		{Type: "Polygon", Coordinates: []byte(`[[[1,91],[3,4],[5,6]]]`)},
+		{Type: "MultiPolygon", Coordinates: []byte(`[[[[1,2],[3,4],[181,6]]]]`)},
+		{Type: "MultiPolygon", Coordinates: []byte(`{}`)},
+	} {
+		if _, err := g.Polygons(); err == nil {
+			t.Errorf("%s %s decoded without error", g.Type, g.Coordinates)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/michalswi/osm/coords"
+	"github.com/michalswi/osm/geo"
+)
+
+// parsePoints reads a sequence of points either from a POSTed JSON body
+// ({"points":[{"lat":..,"lon":..}, ...]}) or from repeated point query params
+// (?point=lat,lon&point=lat,lon...).
+func parsePoints(r *http.Request) ([]geo.Point, error) {
+	if r.Method == http.MethodPost {
+		var body struct {
+			Points []geo.Point `json:"points"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return nil, fmt.Errorf("invalid JSON body: %w", err)
+		}
+		for _, p := range body.Points {
+			if p.Lat < -90 || p.Lat > 90 || p.Lon < -180 || p.Lon > 180 {
+				return nil, fmt.Errorf("point out of range: %f,%f", p.Lat, p.Lon)
+			}
+		}
+		return body.Points, nil
+	}
+
+	raw := r.URL.Query()["point"]
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("missing point parameter")
+	}
+	var pts []geo.Point
+	for _, s := range raw {
+		lat, lon, err := parseLocationString(s)
+		if err != nil {
+			return nil, err
+		}
+		pts = append(pts, geo.Point{Lat: lat, Lon: lon})
+	}
+	return pts, nil
+}
+
+// parseBBox parses a "south,west,north,east" bounding box. West may exceed east
+// for a box crossing the antimeridian.
+func parseBBox(s string) (sw, ne geo.Point, err error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return sw, ne, fmt.Errorf("invalid bbox: %s", s)
+	}
+	var v [4]float64
+	for i, part := range parts {
+		if v[i], err = strconv.ParseFloat(strings.TrimSpace(part), 64); err != nil {
+			return sw, ne, fmt.Errorf("invalid bbox: %s", s)
+		}
+	}
+	sw, ne = geo.Point{Lat: v[0], Lon: v[1]}, geo.Point{Lat: v[2], Lon: v[3]}
+	inRange := func(v, max float64) bool { return v >= -max && v <= max } // false for NaN
+	if !inRange(sw.Lat, 90) || !inRange(ne.Lat, 90) || !inRange(sw.Lon, 180) || !inRange(ne.Lon, 180) || sw.Lat > ne.Lat {
+		return sw, ne, fmt.Errorf("invalid bbox: %s", s)
+	}
+	return sw, ne, nil
+}
+
+// apiParseCoordinate parses ?q= in any supported coordinate format and returns
+// {"lat","lon","format"} plus "uncertainty_m" when the input has a precision.
+func apiParseCoordinate(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	if q == "" {
+		http.Error(w, "missing q parameter", http.StatusBadRequest)
+		return
+	}
+	c, err := coords.Parse(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, c)
+}
+
+// apiConvert transforms ?coord= from ?from= (default EPSG:4326) into the
+// ?to= CRS list (comma separated), or into every supported CRS without it.
+func apiConvert(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("coord") == "" {
+		http.Error(w, "missing coord parameter", http.StatusBadRequest)
+		return
+	}
+	c, err := coords.FromCRS(q.Get("from"), q.Get("coord"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results := []coords.Projected{}
+	if to := q.Get("to"); to == "" || to == "all" {
+		results = coords.AllCRS(c.Lat, c.Lon)
+	} else {
+		for _, crs := range strings.Split(to, ",") {
+			p, err := coords.ToCRS(c.Lat, c.Lon, crs)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			results = append(results, p)
+		}
+	}
+
+	writeJSON(w, struct {
+		Lat     float64            `json:"lat"`
+		Lon     float64            `json:"lon"`
+		Results []coords.Projected `json:"results"`
+	}{c.Lat, c.Lon, results})
+}
+
+// apiGeoDistance returns the geodesic length, bearings and midpoint of a polyline.
+func apiGeoDistance(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+	pts, err := parsePoints(r)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if len(pts) < 2 {
+		http.Error(w, "at least 2 points required", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, geo.MeasurePath(pts))
+}
+
+// apiGeoArea returns the geodesic area and perimeter of a polygon ring.
+func apiGeoArea(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+	pts, err := parsePoints(r)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if len(pts) < 3 {
+		http.Error(w, "at least 3 points required", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, struct {
+		Area      float64 `json:"area_m2"`
+		Perimeter float64 `json:"perimeter_m"`
+	}{geo.Area(pts), geo.Perimeter(pts)})
+}
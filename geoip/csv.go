@@ -0,0 +1,127 @@
+package geoip
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// csvDB is a longest-prefix-match table loaded from a CSV file with the columns
+// network,lat,lon,accuracy_km,asn,as_org,country,city. Empty lat/lon mark ASN-only rows.
+type csvDB struct {
+	byBits map[int]map[netip.Prefix]Record
+	bits   []int // prefix lengths present, longest first
+}
+
+// OpenCSV loads a CSV geolocation table. A header row is skipped.
+func OpenCSV(path string) (DB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadCSV(f)
+}
+
+// ReadCSV parses a CSV geolocation table from r.
+func ReadCSV(r io.Reader) (DB, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	db := &csvDB{byBits: map[int]map[netip.Prefix]Record{}}
+	for line := 1; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(row[0]))
+		if err != nil {
+			if line == 1 {
+				continue // header
+			}
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		rec, err := csvRecord(row)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			// ::ffff:a.b.c.d/n, looked up as the plain IPv4 address
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		prefix = prefix.Masked()
+		rec.Network = prefix.String()
+		if db.byBits[prefix.Bits()] == nil {
+			db.byBits[prefix.Bits()] = map[netip.Prefix]Record{}
+		}
+		db.byBits[prefix.Bits()][prefix] = rec
+	}
+	for b := 128; b >= 0; b-- {
+		if db.byBits[b] != nil {
+			db.bits = append(db.bits, b)
+		}
+	}
+	return db, nil
+}
+
+func csvRecord(row []string) (Record, error) {
+	field := func(i int) string {
+		if i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+	var rec Record
+	var err error
+	if field(1) != "" || field(2) != "" {
+		if rec.Lat, err = strconv.ParseFloat(field(1), 64); err != nil || !(rec.Lat >= -90 && rec.Lat <= 90) {
+			return rec, fmt.Errorf("invalid latitude %q", field(1))
+		}
+		if rec.Lon, err = strconv.ParseFloat(field(2), 64); err != nil || !(rec.Lon >= -180 && rec.Lon <= 180) {
+			return rec, fmt.Errorf("invalid longitude %q", field(2))
+		}
+		rec.HasLocation = true
+	}
+	if a := field(3); a != "" {
+		if rec.AccuracyKm, err = strconv.ParseFloat(a, 64); err != nil || !(rec.AccuracyKm >= 0 && rec.AccuracyKm <= 20038) {
+			return rec, fmt.Errorf("invalid accuracy %q", a)
+		}
+	}
+	if a := strings.TrimPrefix(strings.ToUpper(field(4)), "AS"); a != "" {
+		n, err := strconv.ParseUint(a, 10, 32)
+		if err != nil {
+			return rec, fmt.Errorf("invalid asn %q", field(4))
+		}
+		rec.ASN = uint32(n)
+	}
+	rec.ASOrg = field(5)
+	rec.Country = strings.ToUpper(field(6))
+	rec.City = field(7)
+	return rec, nil
+}
+
+// Lookup returns the record of the most specific network containing ip.
+func (db *csvDB) Lookup(ip netip.Addr) (Record, bool) {
+	ip = ip.Unmap()
+	for _, b := range db.bits {
+		if b > ip.BitLen() {
+			continue
+		}
+		p, err := ip.Prefix(b)
+		if err != nil {
+			continue
+		}
+		if rec, ok := db.byBits[b][p]; ok {
+			return rec, true
+		}
+	}
+	return Record{}, false
+}
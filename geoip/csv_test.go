@@ -0,0 +1,89 @@
+package geoip
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+const testCSV = `network,lat,lon,accuracy_km,asn,as_org,country,city
+10.0.0.0/8,52.23,21.01,500,AS5617,Orange,pl,Warsaw
+10.1.0.0/16,51.1,17.03,50,,,PL,Wroclaw
+10.1.2.0/24,50.06,19.94,5,,,PL,Krakow
+10.1.2.3/32,,,,64512,Private,,
+2001:db8::/32,52.37,4.89,20,3333,RIPE-NCC,NL,Amsterdam
+2001:db8:1::/48,48.85,2.35,10,,,FR,Paris
+::ffff:192.0.2.0/120,40.42,-3.70,25,,,ES,Madrid
+0.0.0.0/0,0,0,20000,,,,
+`
+
+func TestCSVLookup(t *testing.T) {
+	db, err := ReadCSV(strings.NewReader(testCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ip, network, city string
+		asn               uint32
+		hasLocation       bool
+	}{
+		{"10.9.9.9", "10.0.0.0/8", "Warsaw", 5617, true},
+		{"10.1.9.9", "10.1.0.0/16", "Wroclaw", 0, true},
+		{"10.1.2.9", "10.1.2.0/24", "Krakow", 0, true},
+		{"10.1.2.3", "10.1.2.3/32", "", 64512, false}, // ASN-only row
+		{"::ffff:10.1.2.9", "10.1.2.0/24", "Krakow", 0, true},
+		{"2001:db8:2::1", "2001:db8::/32", "Amsterdam", 3333, true},
+		{"2001:db8:1::1", "2001:db8:1::/48", "Paris", 0, true},
+		{"192.0.2.7", "192.0.2.0/24", "Madrid", 0, true},
+		{"8.8.8.8", "0.0.0.0/0", "", 0, true},
+	}
+	for _, tt := range tests {
+		rec, ok := db.Lookup(netip.MustParseAddr(tt.ip))
+		if !ok {
+			t.Errorf("Lookup(%s) not found", tt.ip)
+			continue
+		}
+		if rec.Network != tt.network || rec.City != tt.city || rec.ASN != tt.asn || rec.HasLocation != tt.hasLocation {
+			t.Errorf("Lookup(%s) = %+v, want network %s city %q asn %d", tt.ip, rec, tt.network, tt.city, tt.asn)
+		}
+	}
+	if rec, _ := db.Lookup(netip.MustParseAddr("10.9.9.9")); rec.Country != "PL" {
+		t.Errorf("country = %q, want it upper-cased", rec.Country)
+	}
+	if _, ok := db.Lookup(netip.MustParseAddr("2001:db9::1")); ok {
+		t.Error("2001:db9::1 found without an IPv6 default route")
+	}
+}
+
+func TestCSVErrors(t *testing.T) {
+	for _, row := range []string{
+		"10.0.0.0/8,NaN,21,1,,,,",
+		"10.0.0.0/8,52,181,1,,,,",
+		"10.0.0.0/8,52,21,x,,,,",
+		"10.0.0.0/8,52,21,NaN,,,,",
+		"10.0.0.0/8,52,21,Inf,,,,",
+		"10.0.0.0/8,52,21,-5,,,,",
+		"10.0.0.0/8,52,21,1,ASx,,,",
+		"1.2.3.0/24,52,21,1,,,,\nnot-a-prefix,1,1,1,,,,",
+	} {
+		if _, err := ReadCSV(strings.NewReader(row)); err == nil {
+			t.Errorf("ReadCSV(%q) gave no error", row)
+		}
+	}
+}
+
+func TestMultiDB(t *testing.T) {
+	city, err := ReadCSV(strings.NewReader("10.0.0.0/8,52.23,21.01,500,,,PL,Warsaw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	asn, err := ReadCSV(strings.NewReader("10.1.0.0/16,,,,5617,Orange,DE,\n10.0.0.0/8,1,1,1,1,Other,,Other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, ok := multiDB{city, asn}.Lookup(netip.MustParseAddr("10.1.1.1"))
+	want := Record{Network: "10.0.0.0/8", Lat: 52.23, Lon: 21.01, AccuracyKm: 500, Country: "PL", City: "Warsaw", ASN: 5617, ASOrg: "Orange", HasLocation: true}
+	if !ok || rec != want {
+		t.Errorf("Lookup = %+v, want %+v", rec, want)
+	}
+}
@@ -0,0 +1,99 @@
+package geoip
+
+import (
+	"fmt"
+	"net/netip"
+	"path/filepath"
+	"strings"
+)
+
+// Record is what an offline database knows about an address.
+type Record struct {
+	Network     string  `json:"network,omitempty"`
+	Lat         float64 `json:"lat"`
+	Lon         float64 `json:"lon"`
+	AccuracyKm  float64 `json:"accuracy_km"`
+	Country     string  `json:"country,omitempty"`
+	City        string  `json:"city,omitempty"`
+	ASN         uint32  `json:"asn,omitempty"`
+	ASOrg       string  `json:"as_org,omitempty"`
+	HasLocation bool    `json:"-"`
+}
+
+// DB resolves IP addresses to geolocation and origin AS data.
+type DB interface {
+	Lookup(ip netip.Addr) (Record, bool)
+}
+
+// Open loads one or more comma-separated database files. Files ending in .mmdb are
+// read as MaxMind DB (GeoLite2 City/ASN layout), everything else as CSV.
+// Results of several files are merged, so a City and an ASN database can be combined.
+func Open(paths string) (DB, error) {
+	var dbs multiDB
+	for _, p := range strings.Split(paths, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		var db DB
+		var err error
+		if strings.EqualFold(filepath.Ext(p), ".mmdb") {
+			db, err = OpenMMDB(p)
+		} else {
+			db, err = OpenCSV(p)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", p, err)
+		}
+		dbs = append(dbs, db)
+	}
+	if len(dbs) == 0 {
+		return nil, fmt.Errorf("no database files given")
+	}
+	return dbs, nil
+}
+
+// multiDB merges lookups from several databases; earlier ones win per field.
+type multiDB []DB
+
+func (m multiDB) Lookup(ip netip.Addr) (Record, bool) {
+	var out Record
+	found := false
+	for _, db := range m {
+		r, ok := db.Lookup(ip)
+		if !ok {
+			continue
+		}
+		found = true
+		if out.Network == "" {
+			out.Network = r.Network
+		}
+		if !out.HasLocation && r.HasLocation {
+			out.Lat, out.Lon, out.AccuracyKm, out.HasLocation = r.Lat, r.Lon, r.AccuracyKm, true
+		}
+		if out.Country == "" {
+			out.Country = r.Country
+		}
+		if out.City == "" {
+			out.City = r.City
+		}
+		if out.ASN == 0 {
+			out.ASN, out.ASOrg = r.ASN, r.ASOrg
+		}
+	}
+	return out, found
+}
+
+// ParseAddr accepts a single IP address or a CIDR prefix and returns the address
+// to look up (the network address for prefixes).
+func ParseAddr(s string) (netip.Addr, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Addr{}, err
+		}
+		return p.Masked().Addr(), nil
+	}
+	return netip.ParseAddr(s)
+}
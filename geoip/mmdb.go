@@ -0,0 +1,336 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+)
+
+// mmdbDB is a minimal reader for the MaxMind DB format
+// (https://maxmind.github.io/MaxMind-DB/), enough for GeoLite2 City and ASN files.
+type mmdbDB struct {
+	buf        []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	data       []byte
+	ipv4Start  uint
+}
+
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// OpenMMDB reads a .mmdb file into memory.
+func OpenMMDB(path string) (DB, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ReadMMDB(buf)
+}
+
+// ReadMMDB parses a MaxMind DB held in buf.
+func ReadMMDB(buf []byte) (DB, error) {
+	i := bytes.LastIndex(buf, metadataMarker)
+	if i < 0 {
+		return nil, fmt.Errorf("not a MaxMind DB file")
+	}
+	metaBuf := buf[i+len(metadataMarker):]
+	v, _, err := decode(metaBuf, 0)
+	if err != nil {
+		return nil, fmt.Errorf("metadata: %v", err)
+	}
+	meta, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("metadata is not a map")
+	}
+
+	db := &mmdbDB{
+		buf:        buf,
+		nodeCount:  uint(toUint(meta["node_count"])),
+		recordSize: uint(toUint(meta["record_size"])),
+		ipVersion:  uint(toUint(meta["ip_version"])),
+	}
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", db.recordSize)
+	}
+	treeSize := db.recordSize * 2 / 8 * db.nodeCount
+	if treeSize+16 > uint(i) {
+		return nil, fmt.Errorf("search tree larger than file")
+	}
+	db.data = buf[treeSize+16 : i]
+
+	if db.ipVersion == 6 {
+		node := uint(0)
+		for j := 0; j < 96 && node < db.nodeCount; j++ {
+			node = db.record(node, 0)
+		}
+		db.ipv4Start = node
+	}
+	return db, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of a search tree node.
+func (db *mmdbDB) record(node uint, bit uint) uint {
+	switch db.recordSize {
+	case 24:
+		off := node * 6
+		b := db.buf[off+bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		off := node * 7
+		b := db.buf[off:]
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		off := node * 8
+		return uint(binary.BigEndian.Uint32(db.buf[off+bit*4:]))
+	}
+}
+
+// Lookup walks the search tree for ip and decodes the data record it points at.
+func (db *mmdbDB) Lookup(ip netip.Addr) (Record, bool) {
+	ip = ip.Unmap()
+	var addr []byte
+	node := uint(0)
+	if ip.Is4() {
+		a := ip.As4()
+		addr = a[:]
+		node = db.ipv4Start
+	} else {
+		if db.ipVersion == 4 {
+			return Record{}, false
+		}
+		a := ip.As16()
+		addr = a[:]
+	}
+
+	bits := len(addr) * 8
+	depth := 0
+	for ; depth < bits && node < db.nodeCount; depth++ {
+		bit := uint(addr[depth/8]>>(7-depth%8)) & 1
+		node = db.record(node, bit)
+	}
+	if node <= db.nodeCount {
+		return Record{}, false
+	}
+
+	off := int(node-db.nodeCount) - 16
+	if off < 0 || off >= len(db.data) {
+		return Record{}, false
+	}
+	v, _, err := decode(db.data, off)
+	if err != nil {
+		return Record{}, false
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return Record{}, false
+	}
+
+	// for IPv4 in an IPv6 tree depth only counts the bits below the IPv4 subtree
+	rec, err := toRecord(m)
+	if err != nil {
+		return Record{}, false
+	}
+	if p, err := ip.Prefix(depth); err == nil {
+		rec.Network = p.String()
+	}
+	return rec, true
+}
+
+// toRecord maps the GeoLite2 City / ASN layouts onto a Record. A location out
+// of range (or NaN) is an error, as in a CSV database.
+func toRecord(m map[string]any) (Record, error) {
+	var rec Record
+	if loc, ok := m["location"].(map[string]any); ok {
+		lat, okLat := loc["latitude"].(float64)
+		lon, okLon := loc["longitude"].(float64)
+		if okLat && okLon {
+			if !(lat >= -90 && lat <= 90) || !(lon >= -180 && lon <= 180) {
+				return rec, fmt.Errorf("invalid location %v,%v", lat, lon)
+			}
+			rec.Lat, rec.Lon, rec.HasLocation = lat, lon, true
+		}
+		rec.AccuracyKm = float64(toUint(loc["accuracy_radius"]))
+	}
+	if c, ok := m["country"].(map[string]any); ok {
+		rec.Country, _ = c["iso_code"].(string)
+	}
+	if c, ok := m["city"].(map[string]any); ok {
+		if names, ok := c["names"].(map[string]any); ok {
+			rec.City, _ = names["en"].(string)
+		}
+	}
+	rec.ASN = uint32(toUint(m["autonomous_system_number"]))
+	rec.ASOrg, _ = m["autonomous_system_organization"].(string)
+	return rec, nil
+}
+
+func toUint(v any) uint64 {
+	switch n := v.(type) {
+	case uint64:
+		return n
+	case int64:
+		return uint64(n)
+	case float64:
+		return uint64(n)
+	}
+	return 0
+}
+
+// maxDecodeDepth bounds the nesting of maps, arrays and pointers. Real
+// databases nest a few levels; a pointer back into an enclosing map would
+// otherwise recurse until the stack runs out.
+const maxDecodeDepth = 32
+
+// decode reads the data field at off and returns its value and the offset after it.
+func decode(buf []byte, off int) (any, int, error) {
+	return decodeAt(buf, off, 0)
+}
+
+func decodeAt(buf []byte, off, depth int) (any, int, error) {
+	if depth > maxDecodeDepth {
+		return nil, 0, fmt.Errorf("data nested too deep at %d", off)
+	}
+	if off >= len(buf) {
+		return nil, 0, fmt.Errorf("offset %d out of range", off)
+	}
+	ctrl := buf[off]
+	off++
+	typ := int(ctrl >> 5)
+
+	if typ == 1 { // pointer
+		ss := (ctrl >> 3) & 0x3
+		vvv := uint(ctrl & 0x7)
+		n := int(ss) + 1
+		if off+n > len(buf) {
+			return nil, 0, fmt.Errorf("truncated pointer")
+		}
+		b := buf[off : off+n]
+		var p uint
+		switch ss {
+		case 0:
+			p = vvv<<8 | uint(b[0])
+		case 1:
+			p = (vvv<<16 | uint(b[0])<<8 | uint(b[1])) + 2048
+		case 2:
+			p = (vvv<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) + 526336
+		default:
+			p = uint(binary.BigEndian.Uint32(b))
+		}
+		if int(p) < len(buf) && buf[p]>>5 == 1 {
+			return nil, 0, fmt.Errorf("pointer to pointer at %d", p) // not allowed, could loop
+		}
+		v, _, err := decodeAt(buf, int(p), depth+1)
+		return v, off + n, err
+	}
+
+	if typ == 0 { // extended type
+		if off >= len(buf) {
+			return nil, 0, fmt.Errorf("truncated extended type")
+		}
+		typ = 7 + int(buf[off])
+		off++
+	}
+
+	size := int(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if off+n > len(buf) {
+			return nil, 0, fmt.Errorf("truncated size")
+		}
+		b := buf[off : off+n]
+		switch n {
+		case 1:
+			size = 29 + int(b[0])
+		case 2:
+			size = 285 + (int(b[0])<<8 | int(b[1]))
+		default:
+			size = 65821 + (int(b[0])<<16 | int(b[1])<<8 | int(b[2]))
+		}
+		off += n
+	}
+
+	switch typ {
+	case 7: // map
+		m := make(map[string]any, size)
+		for i := 0; i < size; i++ {
+			k, next, err := decodeAt(buf, off, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("map key is not a string")
+			}
+			v, next, err := decodeAt(buf, next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+			off = next
+		}
+		return m, off, nil
+	case 11: // array
+		a := make([]any, 0, size)
+		for i := 0; i < size; i++ {
+			v, next, err := decodeAt(buf, off, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+			off = next
+		}
+		return a, off, nil
+	case 14: // boolean, value stored in size
+		return size != 0, off, nil
+	}
+
+	if off+size > len(buf) {
+		return nil, 0, fmt.Errorf("truncated value of type %d", typ)
+	}
+	b := buf[off : off+size]
+	off += size
+	switch typ {
+	case 2: // utf8 string
+		return string(b), off, nil
+	case 3: // double
+		if size != 8 {
+			return nil, 0, fmt.Errorf("invalid double size %d", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), off, nil
+	case 4: // bytes
+		return append([]byte(nil), b...), off, nil
+	case 5, 6, 9: // uint16, uint32, uint64
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n, off, nil
+	case 10: // uint128, only the low 64 bits are kept
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n, off, nil
+	case 8: // int32
+		var n int32
+		for _, c := range b {
+			n = n<<8 | int32(c)
+		}
+		return int64(n), off, nil
+	case 15: // float
+		if size != 4 {
+			return nil, 0, fmt.Errorf("invalid float size %d", size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), off, nil
+	case 12, 13: // data cache container, end marker
+		return nil, off, nil
+	}
+	return nil, 0, fmt.Errorf("unknown data type %d", typ)
+}
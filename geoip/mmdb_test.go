@@ -0,0 +1,307 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/netip"
+	"testing"
+)
+
+// The fixtures are built here rather than checked in, so the bytes under test
+// are visible next to the assertions.
+
+func mmdbCtrl(typ, size int) []byte {
+	var ext []byte
+	switch {
+	case size >= 285:
+		ext = []byte{byte((size - 285) >> 8), byte(size - 285)}
+		size = 30
+	case size >= 29:
+		ext = []byte{byte(size - 29)}
+		size = 29
+	}
+	var b []byte
+	if typ <= 7 {
+		b = []byte{byte(typ<<5 | size)}
+	} else {
+		b = []byte{byte(size), byte(typ - 7)} // extended type
+	}
+	return append(b, ext...)
+}
+
+func mmdbString(s string) []byte { return append(mmdbCtrl(2, len(s)), s...) }
+
+func mmdbDouble(f float64) []byte {
+	return binary.BigEndian.AppendUint64(mmdbCtrl(3, 8), math.Float64bits(f))
+}
+
+func mmdbUint16(n uint16) []byte { return binary.BigEndian.AppendUint16(mmdbCtrl(5, 2), n) }
+func mmdbUint32(n uint32) []byte { return binary.BigEndian.AppendUint32(mmdbCtrl(6, 4), n) }
+
+// mmdbMap encodes alternating keys and encoded values.
+func mmdbMap(kv ...any) []byte {
+	out := mmdbCtrl(7, len(kv)/2)
+	for i := 0; i < len(kv); i += 2 {
+		out = append(out, mmdbString(kv[i].(string))...)
+		out = append(out, kv[i+1].([]byte)...)
+	}
+	return out
+}
+
+func mmdbArray(items ...[]byte) []byte {
+	out := mmdbCtrl(11, len(items))
+	for _, it := range items {
+		out = append(out, it...)
+	}
+	return out
+}
+
+// mmdbPointer encodes a pointer to off in the data section (11 bits, or 19 bits + 2048).
+func mmdbPointer(off int) []byte {
+	if off < 2048 {
+		return []byte{byte(1<<5 | off>>8), byte(off)}
+	}
+	off -= 2048
+	return []byte{byte(1<<5 | 1<<3 | off>>16), byte(off >> 8), byte(off)}
+}
+
+type testNetwork struct {
+	prefix string
+	data   int // offset in the data section
+}
+
+// buildMMDB writes a database with the networks (IPv4 ones at ::a.b.c.d/96+n in
+// an IPv6 tree), the data section and metadata. Later, more specific networks
+// split the earlier ones.
+func buildMMDB(t *testing.T, recordSize, ipVersion int, networks []testNetwork, data []byte) []byte {
+	t.Helper()
+	const empty, leaf = -1, -2
+	type child struct{ kind, v int } // kind: node index, empty or leaf (v = data offset)
+	nodes := [][2]child{{{kind: empty}, {kind: empty}}}
+
+	for _, n := range networks {
+		p := netip.MustParsePrefix(n.prefix)
+		addr, bits := p.Addr().AsSlice(), p.Bits()
+		if ipVersion == 6 && p.Addr().Is4() {
+			a16 := [16]byte{}
+			copy(a16[12:], addr)
+			addr, bits = a16[:], bits+96
+		}
+		node := 0
+		for depth := 0; depth < bits; depth++ {
+			bit := addr[depth/8] >> (7 - depth%8) & 1
+			c := nodes[node][bit]
+			if depth == bits-1 {
+				nodes[node][bit] = child{kind: leaf, v: n.data}
+				break
+			}
+			if c.kind < 0 { // empty or a shorter network: split it
+				nodes = append(nodes, [2]child{c, c})
+				nodes[node][bit] = child{kind: len(nodes) - 1}
+			}
+			node = nodes[node][bit].kind
+		}
+	}
+
+	count := len(nodes)
+	value := func(c child) uint32 {
+		switch c.kind {
+		case empty:
+			return uint32(count)
+		case leaf:
+			return uint32(count + 16 + c.v)
+		}
+		return uint32(c.kind)
+	}
+	var tree []byte
+	for _, nd := range nodes {
+		l, r := value(nd[0]), value(nd[1])
+		switch recordSize {
+		case 24:
+			tree = append(tree, byte(l>>16), byte(l>>8), byte(l), byte(r>>16), byte(r>>8), byte(r))
+		case 28:
+			tree = append(tree, byte(l>>16), byte(l>>8), byte(l), byte(l>>24<<4|r>>24&0x0f), byte(r>>16), byte(r>>8), byte(r))
+		case 32:
+			tree = binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(tree, l), r)
+		}
+	}
+
+	meta := mmdbMap(
+		"binary_format_major_version", mmdbUint16(2),
+		"database_type", mmdbString("Test-City"),
+		"ip_version", mmdbUint16(uint16(ipVersion)),
+		"languages", mmdbArray(mmdbString("en"), mmdbString("pl")),
+		"node_count", mmdbUint32(uint32(count)),
+		"record_size", mmdbUint16(uint16(recordSize)),
+	)
+	var buf bytes.Buffer
+	buf.Write(tree)
+	buf.Write(make([]byte, 16))
+	buf.Write(data)
+	buf.Write(metadataMarker)
+	buf.Write(meta)
+	return buf.Bytes()
+}
+
+// testData returns a data section with a shared city name (reached through a
+// pointer), a City record, a more specific country-only record and an ASN record.
+func testData() (data []byte, city, country, asn int) {
+	data = mmdbString("Wrocław")
+	city = len(data)
+	data = append(data, mmdbMap(
+		"city", mmdbMap("names", mmdbMap("en", mmdbPointer(0))),
+		"country", mmdbMap("iso_code", mmdbString("PL")),
+		"location", mmdbMap(
+			"accuracy_radius", mmdbUint16(20),
+			"latitude", mmdbDouble(51.1),
+			"longitude", mmdbDouble(17.03),
+		),
+		"subdivisions", mmdbArray(mmdbMap("iso_code", mmdbString("02"))),
+	)...)
+	country = len(data)
+	data = append(data, mmdbMap("country", mmdbMap("iso_code", mmdbString("DE")))...)
+	asn = len(data)
+	data = append(data, mmdbMap(
+		"autonomous_system_number", mmdbUint32(49242),
+		"autonomous_system_organization", mmdbString("INTERNETUNION"),
+	)...)
+	return data, city, country, asn
+}
+
+func TestMMDBLookup(t *testing.T) {
+	data, city, country, asn := testData()
+	networks := []testNetwork{
+		{"1.2.3.0/24", city},
+		{"1.2.3.128/25", country},
+		{"2001:db8::/32", asn},
+	}
+	wroclaw := Record{Lat: 51.1, Lon: 17.03, AccuracyKm: 20, Country: "PL", City: "Wrocław", HasLocation: true}
+
+	tests := []struct {
+		ip      string
+		found   bool
+		want    Record
+		network string
+	}{
+		{"1.2.3.4", true, wroclaw, "1.2.3.0/25"}, // the /24 was split by the /25
+		{"::ffff:1.2.3.4", true, wroclaw, "1.2.3.0/25"},
+		{"::1.2.3.4", true, wroclaw, "::102:300/121"},
+		{"1.2.3.200", true, Record{Country: "DE"}, "1.2.3.128/25"},
+		{"2001:db8:1::1", true, Record{ASN: 49242, ASOrg: "INTERNETUNION"}, "2001:db8::/32"},
+		{"1.2.4.1", false, Record{}, ""},
+		{"8.8.8.8", false, Record{}, ""},
+		{"2001:db9::1", false, Record{}, ""},
+	}
+	for _, size := range []int{24, 28, 32} {
+		db, err := ReadMMDB(buildMMDB(t, size, 6, networks, data))
+		if err != nil {
+			t.Fatalf("record size %d: %v", size, err)
+		}
+		for _, tt := range tests {
+			got, ok := db.Lookup(netip.MustParseAddr(tt.ip))
+			if ok != tt.found {
+				t.Errorf("record size %d: Lookup(%s) found = %v, want %v", size, tt.ip, ok, tt.found)
+				continue
+			}
+			tt.want.Network = tt.network
+			if ok && got != tt.want {
+				t.Errorf("record size %d: Lookup(%s) = %+v, want %+v", size, tt.ip, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestMMDBIPv4Only(t *testing.T) {
+	data, city, _, _ := testData()
+	db, err := ReadMMDB(buildMMDB(t, 24, 4, []testNetwork{{"1.2.3.0/24", city}}, data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec, ok := db.Lookup(netip.MustParseAddr("1.2.3.4")); !ok || rec.City != "Wrocław" || rec.Network != "1.2.3.0/24" {
+		t.Errorf("Lookup(1.2.3.4) = %+v, %v", rec, ok)
+	}
+	if _, ok := db.Lookup(netip.MustParseAddr("2001:db8::1")); ok {
+		t.Error("IPv6 address found in an IPv4 database")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	long := string(bytes.Repeat([]byte("x"), 300))
+	data := mmdbString(long) // at 0, needs a two byte size
+	arr := len(data)
+	data = append(data, mmdbArray(mmdbPointer(0), mmdbUint16(7), mmdbMap("k", mmdbArray()))...)
+
+	v, next, err := decode(data, arr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != len(data) {
+		t.Errorf("next offset = %d, want %d", next, len(data))
+	}
+	a, ok := v.([]any)
+	if !ok || len(a) != 3 {
+		t.Fatalf("decoded %#v, want a 3 element array", v)
+	}
+	if a[0] != long {
+		t.Errorf("pointer resolved to %q", a[0])
+	}
+	if a[1] != uint64(7) {
+		t.Errorf("a[1] = %#v, want uint64(7)", a[1])
+	}
+	if m, ok := a[2].(map[string]any); !ok || len(m["k"].([]any)) != 0 {
+		t.Errorf("a[2] = %#v, want map with an empty array", a[2])
+	}
+
+	// a pointer to a pointer could loop forever and is rejected
+	loop := append(mmdbPointer(2), mmdbPointer(0)...)
+	if _, _, err := decode(loop, 0); err == nil {
+		t.Error("pointer to pointer decoded without error")
+	}
+	// nor can a loop through a map: a pointer to a map whose value points back
+	mapLoop := []byte{0x20, 0x02, 0xe1, 0x41, 'a', 0x20, 0x02}
+	if _, _, err := decode(mapLoop, 0); err == nil {
+		t.Error("pointer loop through a map decoded without error")
+	}
+	deep := mmdbUint16(1)
+	for i := 0; i < 40; i++ {
+		deep = mmdbArray(deep)
+	}
+	if _, _, err := decode(deep, 0); err == nil {
+		t.Error("data nested 40 levels deep decoded without error")
+	}
+	if _, _, err := decode(mmdbString("abc")[:2], 0); err == nil {
+		t.Error("truncated string decoded without error")
+	}
+}
+
+func TestReadMMDBErrors(t *testing.T) {
+	if _, err := ReadMMDB([]byte("not a database")); err == nil {
+		t.Error("no error for a file without metadata")
+	}
+	data, city, _, _ := testData()
+	buf := buildMMDB(t, 24, 6, []testNetwork{{"1.2.3.0/24", city}}, data)
+	if _, err := ReadMMDB(buf[len(buf)/2:]); err == nil {
+		t.Error("no error for a search tree larger than the file")
+	}
+}
+
+func TestMMDBInvalidLocation(t *testing.T) {
+	var data []byte
+	var networks []testNetwork
+	for i, loc := range [][2]float64{{math.NaN(), 17}, {51, math.Inf(1)}, {95, 17}, {51, -181}} {
+		networks = append(networks, testNetwork{fmt.Sprintf("10.%d.0.0/16", i), len(data)})
+		data = append(data, mmdbMap("location", mmdbMap("latitude", mmdbDouble(loc[0]), "longitude", mmdbDouble(loc[1])))...)
+	}
+	db, err := ReadMMDB(buildMMDB(t, 24, 6, networks, data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range networks {
+		ip := netip.MustParsePrefix(n.prefix).Addr()
+		if rec, ok := db.Lookup(ip); ok {
+			t.Errorf("Lookup(%s) = %+v, want the invalid record rejected", ip, rec)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package gitrepo
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signature is the author or committer of a commit.
+type Signature struct {
+	Name  string
+	Email string
+	When  time.Time
+}
+
+func (s Signature) String() string {
+	_, offset := s.When.Zone()
+	sign := '+'
+	if offset < 0 {
+		sign, offset = '-', -offset
+	}
+	return fmt.Sprintf("%s <%s> %d %c%02d%02d", s.Name, s.Email, s.When.Unix(), sign, offset/3600, offset%3600/60)
+}
+
+// parseSignature reads "Name <email> 1700000000 +0100".
+func parseSignature(s string) Signature {
+	var sig Signature
+	lt, gt := strings.Index(s, "<"), strings.LastIndex(s, ">")
+	if lt < 0 || gt < lt {
+		sig.Name = s
+		return sig
+	}
+	sig.Name = strings.TrimSpace(s[:lt])
+	sig.Email = s[lt+1 : gt]
+	fields := strings.Fields(s[gt+1:])
+	if len(fields) == 2 {
+		sec, _ := strconv.ParseInt(fields[0], 10, 64)
+		tz := fields[1]
+		loc := time.UTC
+		if len(tz) == 5 {
+			h, _ := strconv.Atoi(tz[1:3])
+			m, _ := strconv.Atoi(tz[3:5])
+			off := h*3600 + m*60
+			if tz[0] == '-' {
+				off = -off
+			}
+			loc = time.FixedZone(tz, off)
+		}
+		sig.When = time.Unix(sec, 0).In(loc)
+	}
+	return sig
+}
+
+// Commit is a parsed commit object.
+type Commit struct {
+	Hash      Hash
+	Tree      Hash
+	Parents   []Hash
+	Author    Signature
+	Committer Signature
+	Message   string
+}
+
+// ReadCommit reads and parses a commit. Annotated tags are peeled.
+func (r *Repo) ReadCommit(h Hash) (*Commit, error) {
+	for {
+		typ, data, err := r.ReadObject(h)
+		if err != nil {
+			return nil, err
+		}
+		if typ == "tag" {
+			target, _, _ := strings.Cut(strings.TrimPrefix(string(data), "object "), "\n")
+			if h, err = ParseHash(target); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if typ != "commit" {
+			return nil, fmt.Errorf("object %s is a %s, not a commit", h, typ)
+		}
+		return parseCommit(h, data)
+	}
+}
+
+func parseCommit(h Hash, data []byte) (*Commit, error) {
+	c := &Commit{Hash: h}
+	header, msg, _ := bytes.Cut(data, []byte("\n\n"))
+	c.Message = string(msg)
+	for _, line := range strings.Split(string(header), "\n") {
+		key, val, _ := strings.Cut(line, " ")
+		switch key {
+		case "tree":
+			t, err := ParseHash(val)
+			if err != nil {
+				return nil, err
+			}
+			c.Tree = t
+		case "parent":
+			p, err := ParseHash(val)
+			if err != nil {
+				return nil, err
+			}
+			c.Parents = append(c.Parents, p)
+		case "author":
+			c.Author = parseSignature(val)
+		case "committer":
+			c.Committer = parseSignature(val)
+		}
+	}
+	if c.Tree.IsZero() {
+		return nil, fmt.Errorf("commit %s has no tree", h)
+	}
+	return c, nil
+}
+
+// TreeEntry is one entry of a tree object.
+type TreeEntry struct {
+	Mode string
+	Name string
+	Hash Hash
+}
+
+// IsDir reports whether the entry is a subtree.
+func (e TreeEntry) IsDir() bool { return e.Mode == "40000" }
+
+// ReadTree reads the entries of a tree object.
+func (r *Repo) ReadTree(h Hash) ([]TreeEntry, error) {
+	typ, data, err := r.ReadObject(h)
+	if err != nil {
+		return nil, err
+	}
+	if typ != "tree" {
+		return nil, fmt.Errorf("object %s is a %s, not a tree", h, typ)
+	}
+	var entries []TreeEntry
+	for len(data) > 0 {
+		sp := bytes.IndexByte(data, ' ')
+		nul := bytes.IndexByte(data, 0)
+		if sp < 0 || nul < sp || len(data) < nul+21 {
+			return nil, fmt.Errorf("tree %s is corrupt", h)
+		}
+		e := TreeEntry{Mode: string(data[:sp]), Name: string(data[sp+1 : nul])}
+		copy(e.Hash[:], data[nul+1:nul+21])
+		entries = append(entries, e)
+		data = data[nul+21:]
+	}
+	return entries, nil
+}
+
+// WriteTree stores entries as a tree object, in git's canonical order.
+func (r *Repo) WriteTree(entries []TreeEntry) (Hash, error) {
+	sorted := append([]TreeEntry(nil), entries...)
+	key := func(e TreeEntry) string {
+		if e.IsDir() {
+			return e.Name + "/"
+		}
+		return e.Name
+	}
+	sort.Slice(sorted, func(i, j int) bool { return key(sorted[i]) < key(sorted[j]) })
+	var buf bytes.Buffer
+	for _, e := range sorted {
+		fmt.Fprintf(&buf, "%s %s\x00", e.Mode, e.Name)
+		buf.Write(e.Hash[:])
+	}
+	return r.WriteObject("tree", buf.Bytes())
+}
+
+// ReadFile returns the content of the file at path (slash separated) in a commit.
+func (r *Repo) ReadFile(commit Hash, path string) ([]byte, error) {
+	c, err := r.ReadCommit(commit)
+	if err != nil {
+		return nil, err
+	}
+	tree := c.Tree
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for i, name := range parts {
+		entries, err := r.ReadTree(tree)
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		for _, e := range entries {
+			if e.Name != name {
+				continue
+			}
+			if i == len(parts)-1 {
+				if e.IsDir() {
+					return nil, fmt.Errorf("%s is a directory", path)
+				}
+				_, data, err := r.ReadObject(e.Hash)
+				return data, err
+			}
+			if !e.IsDir() {
+				break
+			}
+			tree, found = e.Hash, true
+			break
+		}
+		if !found {
+			break
+		}
+	}
+	return nil, fmt.Errorf("%s in %s: %w", path, commit, ErrNotFound)
+}
+
+// updateTree returns a copy of tree (ZeroHash: empty) with the file at parts set to blob.
+func (r *Repo) updateTree(tree Hash, parts []string, blob Hash) (Hash, error) {
+	var entries []TreeEntry
+	if !tree.IsZero() {
+		var err error
+		if entries, err = r.ReadTree(tree); err != nil {
+			return ZeroHash, err
+		}
+	}
+
+	name := parts[0]
+	i := 0
+	for i < len(entries) && entries[i].Name != name {
+		i++
+	}
+	if i == len(entries) {
+		entries = append(entries, TreeEntry{Name: name})
+	}
+
+	if len(parts) == 1 {
+		entries[i].Mode, entries[i].Hash = "100644", blob
+		return r.WriteTree(entries)
+	}
+	sub := ZeroHash
+	if entries[i].IsDir() {
+		sub = entries[i].Hash
+	}
+	h, err := r.updateTree(sub, parts[1:], blob)
+	if err != nil {
+		return ZeroHash, err
+	}
+	entries[i].Mode, entries[i].Hash = "40000", h
+	return r.WriteTree(entries)
+}
+
+// CommitFile commits content as the file at path on top of parent (ZeroHash:
+// a root commit) and advances branch to it. It fails if branch no longer points
+// at parent, so concurrent updates are never lost.
+func (r *Repo) CommitFile(branch string, parent Hash, path string, content []byte, author Signature, message string) (Hash, error) {
+	tree := ZeroHash
+	if !parent.IsZero() {
+		c, err := r.ReadCommit(parent)
+		if err != nil {
+			return ZeroHash, err
+		}
+		tree = c.Tree
+	}
+
+	blob, err := r.WriteObject("blob", content)
+	if err != nil {
+		return ZeroHash, err
+	}
+	newTree, err := r.updateTree(tree, strings.Split(strings.Trim(path, "/"), "/"), blob)
+	if err != nil {
+		return ZeroHash, err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "tree %s\n", newTree)
+	if !parent.IsZero() {
+		fmt.Fprintf(&buf, "parent %s\n", parent)
+	}
+	fmt.Fprintf(&buf, "author %s\ncommitter %s\n\n%s", author, author, message)
+	if !strings.HasSuffix(message, "\n") {
+		buf.WriteByte('\n')
+	}
+	h, err := r.WriteObject("commit", buf.Bytes())
+	if err != nil {
+		return ZeroHash, err
+	}
+	return h, r.UpdateRef("refs/heads/"+branch, h, parent)
+}
@@ -0,0 +1,428 @@
+package gitrepo
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Object types as stored in pack files.
+const (
+	typeCommit   = 1
+	typeTree     = 2
+	typeBlob     = 3
+	typeTag      = 4
+	typeOfsDelta = 6
+	typeRefDelta = 7
+)
+
+var typeNames = map[int]string{typeCommit: "commit", typeTree: "tree", typeBlob: "blob", typeTag: "tag"}
+
+func (r *Repo) loosePath(h Hash) string {
+	s := h.String()
+	return filepath.Join(r.dir, "objects", s[:2], s[2:])
+}
+
+// Has reports whether the object exists.
+func (r *Repo) Has(h Hash) bool {
+	if _, err := os.Stat(r.loosePath(h)); err == nil {
+		return true
+	}
+	_, _, err := r.findPacked(h)
+	return err == nil
+}
+
+// maxDeltaDepth bounds delta chains, counting both offset and ref deltas, so a
+// malformed pack cannot recurse without end. git itself writes chains of at
+// most 50.
+const maxDeltaDepth = 64
+
+// ReadObject returns the type ("commit", "tree", "blob" or "tag") and content of an object.
+func (r *Repo) ReadObject(h Hash) (string, []byte, error) {
+	return r.readObject(h, 0)
+}
+
+// readObject is ReadObject for an object read as a delta base, depth links
+// down the chain.
+func (r *Repo) readObject(h Hash, depth int) (string, []byte, error) {
+	f, err := os.Open(r.loosePath(h))
+	if err == nil {
+		defer f.Close()
+		return readLoose(f, h)
+	}
+	if !os.IsNotExist(err) {
+		return "", nil, err
+	}
+
+	p, off, err := r.findPacked(h)
+	if err != nil {
+		return "", nil, err
+	}
+	typ, data, err := p.read(r, off, depth)
+	if err != nil {
+		return "", nil, fmt.Errorf("object %s: %v", h, err)
+	}
+	return typeNames[typ], data, nil
+}
+
+func readLoose(f io.Reader, h Hash) (string, []byte, error) {
+	zr, err := zlib.NewReader(f)
+	if err != nil {
+		return "", nil, fmt.Errorf("object %s: %v", h, err)
+	}
+	defer zr.Close()
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return "", nil, fmt.Errorf("object %s: %v", h, err)
+	}
+	header, data, ok := bytes.Cut(raw, []byte{0})
+	if !ok {
+		return "", nil, fmt.Errorf("object %s: missing header", h)
+	}
+	typ, size, _ := strings.Cut(string(header), " ")
+	if n, err := strconv.Atoi(size); err != nil || n != len(data) {
+		return "", nil, fmt.Errorf("object %s: bad size", h)
+	}
+	return typ, data, nil
+}
+
+// WriteObject stores data as a loose object of the given type and returns its id.
+func (r *Repo) WriteObject(typ string, data []byte) (Hash, error) {
+	header := fmt.Sprintf("%s %d\x00", typ, len(data))
+	sum := sha1.New()
+	sum.Write([]byte(header))
+	sum.Write(data)
+	var h Hash
+	copy(h[:], sum.Sum(nil))
+	if r.Has(h) {
+		return h, nil
+	}
+
+	path := r.loosePath(h)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return h, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "tmp_obj_")
+	if err != nil {
+		return h, err
+	}
+	zw := zlib.NewWriter(tmp)
+	zw.Write([]byte(header))
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return h, err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return h, err
+	}
+	os.Chmod(tmp.Name(), 0444)
+	return h, os.Rename(tmp.Name(), path)
+}
+
+// pack is a pack file with its version 2 index.
+type pack struct {
+	path string
+	idx  *packIndex
+}
+
+// packIndex is the content of a .idx file (version 2).
+type packIndex struct {
+	fanout  [256]uint32
+	names   []Hash
+	offsets []uint64
+}
+
+func readPackIndex(path string) (*packIndex, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8+256*4 || !bytes.Equal(data[:4], []byte{0xff, 't', 'O', 'c'}) || binary.BigEndian.Uint32(data[4:8]) != 2 {
+		return nil, fmt.Errorf("%s: unsupported pack index", path)
+	}
+	idx := &packIndex{}
+	pos := 8
+	for i := range idx.fanout {
+		idx.fanout[i] = binary.BigEndian.Uint32(data[pos:])
+		pos += 4
+	}
+	n := int(idx.fanout[255])
+	if len(data) < pos+n*(20+4+4) {
+		return nil, fmt.Errorf("%s: truncated pack index", path)
+	}
+	idx.names = make([]Hash, n)
+	for i := range idx.names {
+		copy(idx.names[i][:], data[pos:])
+		pos += 20
+	}
+	pos += 4 * n // crc32 values
+	small := data[pos : pos+4*n]
+	large := data[pos+4*n:]
+	idx.offsets = make([]uint64, n)
+	for i := range idx.offsets {
+		off := binary.BigEndian.Uint32(small[4*i:])
+		if off&0x80000000 == 0 {
+			idx.offsets[i] = uint64(off)
+			continue
+		}
+		j := int(off & 0x7fffffff)
+		if len(large) < 8*(j+1) {
+			return nil, fmt.Errorf("%s: bad large offset", path)
+		}
+		idx.offsets[i] = binary.BigEndian.Uint64(large[8*j:])
+	}
+	return idx, nil
+}
+
+func (idx *packIndex) find(h Hash) (uint64, bool) {
+	lo := 0
+	if h[0] > 0 {
+		lo = int(idx.fanout[h[0]-1])
+	}
+	hi := int(idx.fanout[h[0]])
+	i := lo + sort.Search(hi-lo, func(i int) bool { return bytes.Compare(idx.names[lo+i][:], h[:]) >= 0 })
+	if i < hi && idx.names[i] == h {
+		return idx.offsets[i], true
+	}
+	return 0, false
+}
+
+func (idx *packIndex) withPrefix(prefix string) []Hash {
+	var out []Hash
+	for _, h := range idx.names {
+		if strings.HasPrefix(h.String(), prefix) {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+// loadPacks returns the known packs, rescanning objects/pack when asked to
+// (new packs appear after a push or gc).
+func (r *Repo) loadPacks(rescan bool) ([]*pack, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.packs != nil && !rescan {
+		return r.packs, nil
+	}
+	files, err := filepath.Glob(filepath.Join(r.dir, "objects", "pack", "pack-*.idx"))
+	if err != nil {
+		return nil, err
+	}
+	known := map[string]*pack{}
+	for _, p := range r.packs {
+		known[p.path] = p
+	}
+	packs := []*pack{}
+	for _, f := range files {
+		path := strings.TrimSuffix(f, ".idx") + ".pack"
+		if p, ok := known[path]; ok {
+			packs = append(packs, p)
+			continue
+		}
+		idx, err := readPackIndex(f)
+		if err != nil {
+			return nil, err
+		}
+		packs = append(packs, &pack{path: path, idx: idx})
+	}
+	r.packs = packs
+	return packs, nil
+}
+
+func (r *Repo) findPacked(h Hash) (*pack, uint64, error) {
+	for _, rescan := range []bool{false, true} {
+		packs, err := r.loadPacks(rescan)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, p := range packs {
+			if off, ok := p.idx.find(h); ok {
+				return p, off, nil
+			}
+		}
+	}
+	return nil, 0, fmt.Errorf("object %s: %w", h, ErrNotFound)
+}
+
+// read returns the (undeltified) object at off, depth deltas down a chain.
+func (p *pack) read(r *Repo, off uint64, depth int) (int, []byte, error) {
+	f, err := os.Open(p.path)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+	return p.readAt(r, f, off, depth)
+}
+
+func (p *pack) readAt(r *Repo, f *os.File, off uint64, depth int) (int, []byte, error) {
+	if depth > maxDeltaDepth {
+		return 0, nil, errors.New("delta chain too long")
+	}
+	br := bufio.NewReader(io.NewSectionReader(f, int64(off), 1<<62))
+
+	c, err := br.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	typ := int(c>>4) & 7
+	size := uint64(c & 0x0f)
+	for shift := 4; c&0x80 != 0; shift += 7 {
+		if c, err = br.ReadByte(); err != nil {
+			return 0, nil, err
+		}
+		size |= uint64(c&0x7f) << shift
+	}
+
+	var baseType int
+	var base []byte
+	switch typ {
+	case typeCommit, typeTree, typeBlob, typeTag:
+		data, err := inflate(br, size)
+		return typ, data, err
+	case typeOfsDelta:
+		c, err := br.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		rel := uint64(c & 0x7f)
+		for c&0x80 != 0 {
+			if c, err = br.ReadByte(); err != nil {
+				return 0, nil, err
+			}
+			rel = ((rel + 1) << 7) | uint64(c&0x7f)
+		}
+		if rel > off {
+			return 0, nil, errors.New("bad delta offset")
+		}
+		if baseType, base, err = p.readAt(r, f, off-rel, depth+1); err != nil {
+			return 0, nil, err
+		}
+	case typeRefDelta:
+		var h Hash
+		if _, err := io.ReadFull(br, h[:]); err != nil {
+			return 0, nil, err
+		}
+		name, data, err := r.readObject(h, depth+1)
+		if err != nil {
+			return 0, nil, err
+		}
+		for t, n := range typeNames {
+			if n == name {
+				baseType = t
+			}
+		}
+		base = data
+	default:
+		return 0, nil, fmt.Errorf("unknown pack object type %d", typ)
+	}
+
+	delta, err := inflate(br, size)
+	if err != nil {
+		return 0, nil, err
+	}
+	data, err := applyDelta(base, delta)
+	return baseType, data, err
+}
+
+func inflate(r io.Reader, size uint64) ([]byte, error) {
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	data := make([]byte, size)
+	if _, err := io.ReadFull(zr, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// applyDelta rebuilds an object from its base and a git delta.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	varint := func() (uint64, error) {
+		var v uint64
+		for shift := 0; ; shift += 7 {
+			if len(delta) == 0 {
+				return 0, errors.New("truncated delta")
+			}
+			c := delta[0]
+			delta = delta[1:]
+			v |= uint64(c&0x7f) << shift
+			if c&0x80 == 0 {
+				return v, nil
+			}
+		}
+	}
+	srcSize, err := varint()
+	if err != nil {
+		return nil, err
+	}
+	if srcSize != uint64(len(base)) {
+		return nil, errors.New("delta base size mismatch")
+	}
+	dstSize, err := varint()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, dstSize)
+	for len(delta) > 0 {
+		cmd := delta[0]
+		delta = delta[1:]
+		switch {
+		case cmd&0x80 != 0:
+			var off, n uint64
+			for i := 0; i < 4; i++ {
+				if cmd&(1<<i) != 0 {
+					if len(delta) == 0 {
+						return nil, errors.New("truncated delta")
+					}
+					off |= uint64(delta[0]) << (8 * i)
+					delta = delta[1:]
+				}
+			}
+			for i := 0; i < 3; i++ {
+				if cmd&(0x10<<i) != 0 {
+					if len(delta) == 0 {
+						return nil, errors.New("truncated delta")
+					}
+					n |= uint64(delta[0]) << (8 * i)
+					delta = delta[1:]
+				}
+			}
+			if n == 0 {
+				n = 0x10000
+			}
+			if off+n > uint64(len(base)) {
+				return nil, errors.New("delta copy out of range")
+			}
+			out = append(out, base[off:off+n]...)
+		case cmd != 0:
+			if int(cmd) > len(delta) {
+				return nil, errors.New("truncated delta")
+			}
+			out = append(out, delta[:cmd]...)
+			delta = delta[cmd:]
+		default:
+			return nil, errors.New("invalid delta opcode")
+		}
+	}
+	if uint64(len(out)) != dstSize {
+		return nil, errors.New("delta result size mismatch")
+	}
+	return out, nil
+}
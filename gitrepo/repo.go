@@ -0,0 +1,265 @@
+// Package gitrepo is a minimal pure-Go implementation of the parts of git the
+// location store needs: reading refs, loose and packed objects, commits and
+// trees, and writing loose objects, commits and branch refs. No git binary is used.
+package gitrepo
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrNotFound is returned for missing refs, objects and paths.
+var ErrNotFound = errors.New("not found")
+
+// ErrInvalidRef is returned for ref names and revisions git would not accept.
+var ErrInvalidRef = errors.New("invalid ref name")
+
+// Hash is a SHA-1 object id.
+type Hash [20]byte
+
+// ZeroHash is the id of no object (e.g. the parent of a root commit).
+var ZeroHash Hash
+
+func (h Hash) String() string { return hex.EncodeToString(h[:]) }
+
+// IsZero reports whether h is ZeroHash.
+func (h Hash) IsZero() bool { return h == ZeroHash }
+
+// ParseHash parses a full 40 character hex object id.
+func ParseHash(s string) (Hash, error) {
+	var h Hash
+	if len(s) != 40 {
+		return h, errors.New("invalid object id")
+	}
+	if _, err := hex.Decode(h[:], []byte(s)); err != nil {
+		return h, errors.New("invalid object id")
+	}
+	return h, nil
+}
+
+// Repo is a git repository on disk, bare or with a working tree (only the .git
+// directory is used).
+type Repo struct {
+	dir string
+
+	mu    sync.Mutex
+	packs []*pack
+}
+
+// Open opens the repository at path: a bare repository or a directory holding .git.
+func Open(path string) (*Repo, error) {
+	dir := path
+	if fi, err := os.Stat(filepath.Join(path, ".git")); err == nil && fi.IsDir() {
+		dir = filepath.Join(path, ".git")
+	}
+	for _, p := range []string{"HEAD", "objects", "refs"} {
+		if _, err := os.Stat(filepath.Join(dir, p)); err != nil {
+			return nil, fmt.Errorf("%s is not a git repository", path)
+		}
+	}
+	return &Repo{dir: dir}, nil
+}
+
+// Init creates an empty bare repository at path with HEAD pointing to branch.
+func Init(path, branch string) (*Repo, error) {
+	for _, d := range []string{"objects/pack", "refs/heads", "refs/tags"} {
+		if err := os.MkdirAll(filepath.Join(path, d), 0755); err != nil {
+			return nil, err
+		}
+	}
+	head := filepath.Join(path, "HEAD")
+	if _, err := os.Stat(head); os.IsNotExist(err) {
+		if err := os.WriteFile(head, []byte("ref: refs/heads/"+branch+"\n"), 0644); err != nil {
+			return nil, err
+		}
+		config := "[core]\n\trepositoryformatversion = 0\n\tbare = true\n"
+		if err := os.WriteFile(filepath.Join(path, "config"), []byte(config), 0644); err != nil {
+			return nil, err
+		}
+	}
+	return Open(path)
+}
+
+// checkRefName reports whether name is a well-formed ref name following the
+// rules of git check-ref-format, so that it can be joined onto the repository
+// directory without leaving it.
+func checkRefName(name string) error {
+	if name == "HEAD" {
+		return nil
+	}
+	bad := name == "" || name == "@" || strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") ||
+		strings.HasSuffix(name, ".") || strings.Contains(name, "..") || strings.Contains(name, "//") ||
+		strings.Contains(name, "@{") || strings.ContainsAny(name, " ~^:?*[\\")
+	for _, c := range name {
+		if c < 0x20 || c == 0x7f {
+			bad = true
+		}
+	}
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || strings.HasSuffix(part, ".lock") {
+			bad = true
+		}
+	}
+	if bad {
+		return ErrInvalidRef
+	}
+	return nil
+}
+
+// Ref returns the object id a ref points to, following symbolic refs.
+// name is a full ref ("refs/heads/main") or "HEAD".
+func (r *Repo) Ref(name string) (Hash, error) {
+	for i := 0; i < 5; i++ {
+		if err := checkRefName(name); err != nil {
+			return ZeroHash, err
+		}
+		data, err := os.ReadFile(filepath.Join(r.dir, filepath.FromSlash(name)))
+		if os.IsNotExist(err) {
+			return r.packedRef(name)
+		}
+		if err != nil {
+			return ZeroHash, err
+		}
+		s := strings.TrimSpace(string(data))
+		if target, ok := strings.CutPrefix(s, "ref: "); ok {
+			name = target
+			continue
+		}
+		return ParseHash(s)
+	}
+	return ZeroHash, fmt.Errorf("symbolic ref loop at %s", name)
+}
+
+func (r *Repo) packedRef(name string) (Hash, error) {
+	f, err := os.Open(filepath.Join(r.dir, "packed-refs"))
+	if os.IsNotExist(err) {
+		return ZeroHash, fmt.Errorf("ref %s: %w", name, ErrNotFound)
+	}
+	if err != nil {
+		return ZeroHash, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "^") {
+			continue
+		}
+		if id, ref, ok := strings.Cut(line, " "); ok && ref == name {
+			return ParseHash(id)
+		}
+	}
+	return ZeroHash, fmt.Errorf("ref %s: %w", name, ErrNotFound)
+}
+
+// UpdateRef points name at id if it currently points at old (ZeroHash: the ref
+// must not exist yet). The update is done with a lock file like git does.
+func (r *Repo) UpdateRef(name string, id, old Hash) error {
+	if err := checkRefName(name); err != nil {
+		return err
+	}
+	path := filepath.Join(r.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	lock, err := os.OpenFile(path+".lock", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("ref %s is locked: %v", name, err)
+	}
+	defer os.Remove(path + ".lock")
+
+	cur, err := r.Ref(name)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		lock.Close()
+		return err
+	}
+	if cur != old {
+		lock.Close()
+		return fmt.Errorf("ref %s moved to %s, expected %s", name, cur, old)
+	}
+	if _, err := lock.WriteString(id.String() + "\n"); err != nil {
+		lock.Close()
+		return err
+	}
+	if err := lock.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".lock", path)
+}
+
+// Resolve turns a revision into a commit id: a full or abbreviated (4+ hex)
+// object id, a branch name, a full ref or HEAD.
+func (r *Repo) Resolve(rev string) (Hash, error) {
+	if err := checkRefName(rev); err != nil {
+		return ZeroHash, err
+	}
+	if rev == "HEAD" || strings.HasPrefix(rev, "refs/") {
+		return r.Ref(rev)
+	}
+	if h, err := r.Ref("refs/heads/" + rev); err == nil {
+		return h, nil
+	}
+	if h, err := r.Ref("refs/tags/" + rev); err == nil {
+		return h, nil
+	}
+	if len(rev) == 40 {
+		h, err := ParseHash(rev)
+		if err != nil {
+			return h, err
+		}
+		if !r.Has(h) {
+			return h, fmt.Errorf("object %s: %w", rev, ErrNotFound)
+		}
+		return h, nil
+	}
+	if len(rev) >= 4 && len(rev) < 40 && isHex(rev) {
+		return r.expand(strings.ToLower(rev))
+	}
+	return ZeroHash, fmt.Errorf("revision %s: %w", rev, ErrNotFound)
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// expand finds the single object whose id starts with prefix.
+func (r *Repo) expand(prefix string) (Hash, error) {
+	matches := map[Hash]bool{}
+	entries, _ := os.ReadDir(filepath.Join(r.dir, "objects", prefix[:2]))
+	for _, e := range entries {
+		if strings.HasPrefix(prefix[:2]+e.Name(), prefix) {
+			if h, err := ParseHash(prefix[:2] + e.Name()); err == nil {
+				matches[h] = true
+			}
+		}
+	}
+	packs, err := r.loadPacks(false)
+	if err != nil {
+		return ZeroHash, err
+	}
+	for _, p := range packs {
+		for _, h := range p.idx.withPrefix(prefix) {
+			matches[h] = true
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return ZeroHash, fmt.Errorf("revision %s: %w", prefix, ErrNotFound)
+	case 1:
+		for h := range matches {
+			return h, nil
+		}
+	}
+	return ZeroHash, fmt.Errorf("ambiguous revision %s", prefix)
+}
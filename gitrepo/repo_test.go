@@ -0,0 +1,310 @@
+package gitrepo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The tests check the reader and writer against the git binary: repositories
+// built and packed by git are read back, and commits written here must pass
+// git fsck.
+
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1",
+		"GIT_AUTHOR_NAME=Test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=Test", "GIT_COMMITTER_EMAIL=test@example.com",
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func needGit(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git binary not found")
+	}
+}
+
+// makeHistory creates a repository with git and returns the commit ids, oldest
+// first. locations.json grows a little in every commit, so packing stores most
+// versions as deltas.
+func makeHistory(t *testing.T) (dir string, commits []string) {
+	t.Helper()
+	dir = t.TempDir()
+	git(t, dir, "init", "-q", "-b", "main")
+	var data bytes.Buffer
+	for i := 0; i < 30; i++ {
+		fmt.Fprintf(&data, "{\"id\":\"loc%d\",\"location\":\"51.%d,17.03\"}\n", i, i)
+		if err := os.WriteFile(filepath.Join(dir, "locations.json"), data.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if i%10 == 0 {
+			os.MkdirAll(filepath.Join(dir, "data", "eu"), 0755)
+			os.WriteFile(filepath.Join(dir, "data", "eu", "pl.json"), []byte(fmt.Sprintf("[%d]\n", i)), 0644)
+		}
+		git(t, dir, "add", "-A")
+		git(t, dir, "commit", "-q", "-m", fmt.Sprintf("change %d", i))
+		commits = append(commits, git(t, dir, "rev-parse", "HEAD"))
+	}
+	git(t, dir, "tag", "-a", "-m", "release", "v1", commits[20])
+	return dir, commits
+}
+
+// checkHistory reads every version of the files through r and compares it
+// with git's view.
+func checkHistory(t *testing.T, dir string, r *Repo, commits []string) {
+	t.Helper()
+	for _, c := range commits {
+		h, err := r.Resolve(c)
+		if err != nil {
+			t.Fatalf("Resolve(%s): %v", c, err)
+		}
+		for _, path := range []string{"locations.json", "data/eu/pl.json"} {
+			got, err := r.ReadFile(h, path)
+			if err != nil {
+				t.Fatalf("ReadFile(%s, %s): %v", c[:7], path, err)
+			}
+			if want := git(t, dir, "show", c+":"+path); strings.TrimSpace(string(got)) != want {
+				t.Fatalf("ReadFile(%s, %s) differs from git show", c[:7], path)
+			}
+		}
+	}
+
+	last := commits[len(commits)-1]
+	for _, rev := range []string{"main", "HEAD", "refs/heads/main", last[:7]} {
+		if h, err := r.Resolve(rev); err != nil || h.String() != last {
+			t.Errorf("Resolve(%s) = %s, %v, want %s", rev, h, err, last)
+		}
+	}
+	// the annotated tag peels to its commit
+	h, err := r.Resolve("v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := r.ReadCommit(h)
+	if err != nil || c.Hash.String() != commits[20] || c.Message != "change 20\n" || c.Author.Email != "test@example.com" {
+		t.Errorf("ReadCommit(v1) = %+v, %v", c, err)
+	}
+	if _, err := r.ReadFile(h, "missing.json"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ReadFile(missing.json) = %v, want ErrNotFound", err)
+	}
+	if _, err := r.Resolve("nosuchbranch"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Resolve(nosuchbranch) = %v, want ErrNotFound", err)
+	}
+}
+
+// packDeltas returns the number of deltified objects in the repository's packs.
+func packDeltas(t *testing.T, dir string) int {
+	t.Helper()
+	packs, _ := filepath.Glob(filepath.Join(dir, ".git", "objects", "pack", "pack-*.idx"))
+	n := 0
+	for _, p := range packs {
+		for _, line := range strings.Split(git(t, dir, "verify-pack", "-v", p), "\n") {
+			// "<id> <type> <size> <packed size> <offset> <depth> <base id>"
+			if len(strings.Fields(line)) == 7 {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func TestReadLoose(t *testing.T) {
+	needGit(t)
+	dir, commits := makeHistory(t)
+	r, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkHistory(t, dir, r, commits)
+}
+
+func TestReadPacked(t *testing.T) {
+	needGit(t)
+	dir, commits := makeHistory(t)
+	git(t, dir, "gc", "-q", "--aggressive") // offset deltas, packed-refs
+	if loose, _ := filepath.Glob(filepath.Join(dir, ".git", "refs", "heads", "*")); len(loose) > 0 {
+		t.Fatalf("refs not packed: %v", loose)
+	}
+	if packDeltas(t, dir) == 0 {
+		t.Fatal("git gc stored no deltas")
+	}
+	r, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkHistory(t, dir, r, commits)
+}
+
+func TestReadRefDeltas(t *testing.T) {
+	needGit(t)
+	dir, commits := makeHistory(t)
+	git(t, dir, "-c", "repack.useDeltaBaseOffset=false", "repack", "-q", "-a", "-d", "-f")
+	if packDeltas(t, dir) == 0 {
+		t.Fatal("git repack stored no deltas")
+	}
+	r, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkHistory(t, dir, r, commits)
+}
+
+func TestReadLargeOffsets(t *testing.T) {
+	needGit(t)
+	dir, commits := makeHistory(t)
+	git(t, dir, "repack", "-q", "-a", "-d")
+	packs, _ := filepath.Glob(filepath.Join(dir, ".git", "objects", "pack", "pack-*.pack"))
+	if len(packs) != 1 {
+		t.Fatalf("want one pack, got %v", packs)
+	}
+	// rebuild the index with every offset above 32 bytes in the 64-bit table
+	idx := strings.TrimSuffix(packs[0], ".pack") + ".idx"
+	os.Remove(idx)
+	git(t, dir, "index-pack", "--index-version=2,32", packs[0])
+	r, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkHistory(t, dir, r, commits)
+}
+
+func TestCommitFile(t *testing.T) {
+	needGit(t)
+	dir := filepath.Join(t.TempDir(), "locations.git")
+	r, err := Init(dir, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	author := Signature{Name: "Jan Kowalski", Email: "jan@example.com", When: time.Date(2024, 5, 1, 12, 0, 0, 0, time.FixedZone("", 2*3600))}
+
+	parent := ZeroHash
+	for i, f := range []struct{ path, content string }{
+		{"locations.json", "[]\n"},
+		{"data/eu/pl.json", "[1]\n"},
+		{"locations.json", "[{\"id\":\"a\"}]\n"},
+		{"data/eu/de.json", "[2]\n"},
+		{"data/eu.json", "[3]\n"}, // git sorts it before the data/eu tree
+	} {
+		h, err := r.CommitFile("main", parent, f.path, []byte(f.content), author, fmt.Sprintf("change %d", i))
+		if err != nil {
+			t.Fatalf("CommitFile(%s): %v", f.path, err)
+		}
+		parent = h
+	}
+	if _, err := r.CommitFile("main", ZeroHash, "x.json", nil, author, "stale"); err == nil {
+		t.Error("CommitFile on a stale parent succeeded")
+	}
+
+	git(t, dir, "fsck", "--strict", "--no-dangling")
+	if got := git(t, dir, "log", "--format=%an <%ae> %ai %s", "-1", "main"); got != "Jan Kowalski <jan@example.com> 2024-05-01 12:00:00 +0200 change 4" {
+		t.Errorf("git log = %q", got)
+	}
+	if got := git(t, dir, "rev-list", "--count", "main"); got != "5" {
+		t.Errorf("%s commits, want 5", got)
+	}
+	for path, want := range map[string]string{
+		"locations.json":  `[{"id":"a"}]`,
+		"data/eu/pl.json": "[1]",
+		"data/eu/de.json": "[2]",
+		"data/eu.json":    "[3]",
+	} {
+		if got := git(t, dir, "show", "main:"+path); got != want {
+			t.Errorf("git show main:%s = %q, want %q", path, got, want)
+		}
+	}
+
+	// commit on top of history that git packed, then let git check it again
+	git(t, dir, "gc", "-q")
+	h, err := r.CommitFile("main", parent, "locations.json", []byte("[]\n"), author, "after gc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	git(t, dir, "fsck", "--strict", "--no-dangling")
+	if got := git(t, dir, "rev-parse", "main"); got != h.String() {
+		t.Errorf("main = %s, want %s", got, h)
+	}
+}
+
+func TestResolveRejectsBadRefs(t *testing.T) {
+	needGit(t)
+	dir, _ := makeHistory(t)
+	r, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rev := range []string{
+		"refs/../../../../etc/hostname",
+		"refs/heads/../config",
+		"../config",
+		"/etc/hostname",
+		"refs/heads/main.lock",
+		"refs/heads/.hidden",
+		"refs//heads/main",
+		"refs/heads/",
+		"main@{1}",
+		"main~1",
+		"main^",
+		"ma:in",
+		"ma in",
+		"ma\x00in",
+		"ma\\in",
+		"@",
+		"",
+	} {
+		if _, err := r.Resolve(rev); !errors.Is(err, ErrInvalidRef) {
+			t.Errorf("Resolve(%q) = %v, want ErrInvalidRef", rev, err)
+		}
+	}
+	if err := r.UpdateRef("refs/heads/../../x", ZeroHash, ZeroHash); !errors.Is(err, ErrInvalidRef) {
+		t.Errorf("UpdateRef outside refs = %v, want ErrInvalidRef", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "x.lock")); err == nil {
+		t.Error("lock file written outside the repository")
+	}
+	if _, err := ParseHash("secret file contents"); err == nil || strings.Contains(err.Error(), "secret") {
+		t.Errorf("ParseHash error = %v, must not echo its input", err)
+	}
+}
+
+func TestRefDeltaLoop(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "loop.git")
+	r, err := Init(dir, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a pack whose only object is a ref delta against itself
+	h := Hash{0x42, 1, 2, 3}
+	pack := append([]byte("PACK\x00\x00\x00\x02\x00\x00\x00\x01\x70"), h[:]...)
+	idx := []byte{0xff, 't', 'O', 'c', 0, 0, 0, 2}
+	for i := 0; i < 256; i++ {
+		n := byte(0)
+		if i >= int(h[0]) {
+			n = 1
+		}
+		idx = append(idx, 0, 0, 0, n)
+	}
+	idx = append(idx, h[:]...)
+	idx = append(idx, 0, 0, 0, 0, 0, 0, 0, 12) // crc32, offset
+	base := filepath.Join(dir, "objects", "pack", "pack-loop")
+	os.WriteFile(base+".pack", pack, 0644)
+	os.WriteFile(base+".idx", idx, 0644)
+
+	if _, _, err := r.ReadObject(h); err == nil || !strings.Contains(err.Error(), "delta chain too long") {
+		t.Errorf("ReadObject = %v, want delta chain too long", err)
+	}
+}
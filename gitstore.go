@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/michalswi/osm/gitrepo"
+)
+
+// gitStore keeps locations as a JSON file in a git repository (typically the bare
+// repository the team pushes reviewed changes to). Every write is a commit on the
+// branch; new commits pushed by others are picked up on the next List.
+type gitStore struct {
+	mu     sync.Mutex
+	repo   *gitrepo.Repo
+	branch string
+	file   string
+
+	// author and message of the next commit, see SetAuthor
+	author  string
+	message string
+
+	tip    gitrepo.Hash
+	cached []Location
+}
+
+// openGitStore opens "path[#branch[:file]]", creating a bare repository if
+// path does not exist or is an empty directory. Defaults are branch main and
+// file locations.json.
+func openGitStore(spec string) (*gitStore, error) {
+	path, ref, _ := strings.Cut(spec, "#")
+	s := &gitStore{branch: "main", file: "locations.json"}
+	if ref != "" {
+		branch, file, ok := strings.Cut(ref, ":")
+		if branch != "" {
+			s.branch = branch
+		}
+		if ok && file != "" {
+			s.file = file
+		}
+	}
+
+	repo, err := gitrepo.Open(path)
+	if err != nil {
+		// never turn an existing directory (e.g. a mistyped path) into a repository
+		if entries, rerr := os.ReadDir(path); !os.IsNotExist(rerr) && (rerr != nil || len(entries) > 0) {
+			return nil, err
+		}
+		if repo, err = gitrepo.Init(path, s.branch); err != nil {
+			return nil, err
+		}
+		logger.Printf("git store: created bare repository %s", path)
+	}
+	s.repo = repo
+	return s, nil
+}
+
+// SetAuthor sets the author ("Name" or "Name <email>") and message of the next
+// commit. Writes are serialized by locationWriteMu, so this is per request.
+func (s *gitStore) SetAuthor(author, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.author, s.message = author, message
+}
+
+// LastChange returns the author and subject of the commit at the branch tip.
+func (s *gitStore) LastChange() (author, message string) {
+	tip, err := s.repo.Ref("refs/heads/" + s.branch)
+	if err != nil {
+		return "", ""
+	}
+	c, err := s.repo.ReadCommit(tip)
+	if err != nil {
+		return "", ""
+	}
+	subject, _, _ := strings.Cut(c.Message, "\n")
+	return c.Author.Name, fmt.Sprintf("%s %s", tip.String()[:7], subject)
+}
+
+// head returns the branch tip and the locations stored there.
+func (s *gitStore) head() (gitrepo.Hash, []Location, error) {
+	tip, err := s.repo.Ref("refs/heads/" + s.branch)
+	if errors.Is(err, gitrepo.ErrNotFound) {
+		return gitrepo.ZeroHash, []Location{}, nil
+	}
+	if err != nil {
+		return tip, nil, err
+	}
+	if tip == s.tip && s.cached != nil {
+		return tip, append([]Location(nil), s.cached...), nil
+	}
+
+	locs, err := s.readAt(tip)
+	if err != nil {
+		return tip, nil, err
+	}
+	if !s.tip.IsZero() {
+		logger.Printf("git store: %s moved to %s", s.branch, tip)
+	}
+	s.tip, s.cached = tip, locs
+	return tip, append([]Location(nil), locs...), nil
+}
+
+func (s *gitStore) readAt(commit gitrepo.Hash) ([]Location, error) {
+	data, err := s.repo.ReadFile(commit, s.file)
+	if errors.Is(err, gitrepo.ErrNotFound) {
+		return []Location{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var locs []Location
+	if err := json.Unmarshal(data, &locs); err != nil {
+		return nil, fmt.Errorf("%s at %s: %v", s.file, commit, err)
+	}
+	return locs, nil
+}
+
+// ListAt returns the locations as of a revision (commit id, abbreviated id or branch).
+func (s *gitStore) ListAt(rev string) ([]Location, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, err := s.repo.Resolve(rev)
+	if err != nil {
+		return nil, err
+	}
+	return s.readAt(h)
+}
+
+// signatureField cleans a name or email for a commit signature: angle brackets
+// and control characters (newlines included) would break the author line.
+func signatureField(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == '<' || r == '>' || unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+	return strings.TrimSpace(s)
+}
+
+// commit applies change to the locations at the tip and commits the result,
+// retrying when someone pushed in between.
+func (s *gitStore) commit(change func([]Location) ([]Location, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer func() { s.author, s.message = "", "" }()
+
+	author := gitrepo.Signature{Name: "osm", When: time.Now()}
+	if s.author != "" {
+		name, email, _ := strings.Cut(s.author, "<")
+		author.Name = signatureField(name)
+		author.Email = signatureField(email)
+		if author.Name == "" {
+			author.Name = "osm"
+		}
+	}
+	message := s.message
+	if message == "" {
+		message = "Update locations"
+	}
+
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		var tip gitrepo.Hash
+		var locs []Location
+		if tip, locs, err = s.head(); err != nil {
+			return err
+		}
+		if locs, err = change(locs); err != nil {
+			return err
+		}
+		var data []byte
+		if data, err = json.MarshalIndent(locs, "", "    "); err != nil {
+			return err
+		}
+		var h gitrepo.Hash
+		h, err = s.repo.CommitFile(s.branch, tip, s.file, append(data, '\n'), author, message)
+		if err == nil {
+			s.tip, s.cached = h, locs
+			return nil
+		}
+	}
+	return err
+}
+
+func (s *gitStore) List() ([]Location, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, locs, err := s.head()
+	return locs, err
+}
+
+func (s *gitStore) Get(id string) (Location, error) {
+	if id == "" {
+		return Location{}, errMissingID
+	}
+	locs, err := s.List()
+	if err != nil {
+		return Location{}, err
+	}
+	for _, loc := range locs {
+		if loc.ID == id {
+			return loc, nil
+		}
+	}
+	return Location{}, errLocationNotFound
+}
+
+func (s *gitStore) Put(loc Location) error {
+	if loc.ID == "" {
+		return errMissingID
+	}
+	return s.commit(func(locs []Location) ([]Location, error) {
+		for i := range locs {
+			if locs[i].ID == loc.ID {
+				locs[i] = loc
+				return locs, nil
+			}
+		}
+		return append(locs, loc), nil
+	})
+}
+
+func (s *gitStore) Delete(id string) error {
+	if id == "" {
+		return errMissingID
+	}
+	return s.commit(func(locs []Location) ([]Location, error) {
+		for i := range locs {
+			if locs[i].ID == id {
+				return append(locs[:i], locs[i+1:]...), nil
+			}
+		}
+		return nil, errLocationNotFound
+	})
+}
+
+func (s *gitStore) Replace(locs []Location) error {
+	return s.commit(func([]Location) ([]Location, error) { return locs, nil })
+}
+
+func (s *gitStore) Close() error { return nil }
@@ -2,4 +2,9 @@ module github.com/michalswi/osm
 
 go 1.25.3
 
-require golang.org/x/net v0.47.0
+require (
+	go.etcd.io/bbolt v1.5.0
+	golang.org/x/net v0.47.0
+)
+
+require golang.org/x/sys v0.45.0 // indirect
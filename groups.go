@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// locationGroup is one group of the sidebar location list.
+type locationGroup struct {
+	Key       string           `json:"key"`
+	Label     string           `json:"label"`
+	Locations []ClientLocation `json:"locations"`
+}
+
+// groupLocations groups locs by "as", "asname", "country" or "place" (country,
+// region and nearest city, as "Poland › Dolnośląskie › Wrocław"). Groups are
+// sorted by AS number or label, locations by asname, AS and position;
+// locations without a value end up in a last group with an empty key.
+func groupLocations(locs []ClientLocation, by string) ([]locationGroup, error) {
+	var keyOf func(ClientLocation) (key, label string)
+	switch by {
+	case "", "as":
+		keyOf = func(loc ClientLocation) (string, string) {
+			if asn, ok := parseASN(loc.As); ok {
+				return fmt.Sprintf("AS%d", asn), strings.TrimSpace(fmt.Sprintf("AS%d %s", asn, loc.Asname))
+			}
+			return loc.As, loc.As
+		}
+	case "asname":
+		keyOf = func(loc ClientLocation) (string, string) {
+			return strings.ToLower(loc.Asname), loc.Asname
+		}
+	case "country":
+		keyOf = func(loc ClientLocation) (string, string) {
+			code := loc.placeCountry()
+			if loc.Place != nil && loc.Place.CountryName != "" {
+				return code, loc.Place.CountryName
+			}
+			return code, code
+		}
+	case "place":
+		keyOf = func(loc ClientLocation) (string, string) {
+			code := loc.placeCountry()
+			var pl place
+			if loc.Place != nil {
+				pl = *loc.Place
+			}
+			if pl.CountryName == "" {
+				pl.CountryName = code
+			}
+			if code == "" && pl.Region == "" && pl.City == "" {
+				return "", ""
+			}
+			var parts []string
+			for _, s := range []string{pl.CountryName, pl.Region, pl.City} {
+				if s != "" {
+					parts = append(parts, s)
+				}
+			}
+			return code + "/" + pl.Region + "/" + pl.City, strings.Join(parts, " › ")
+		}
+	default:
+		return nil, fmt.Errorf("invalid group: %s (want as, asname, country or place)", by)
+	}
+
+	byKey := map[string]*locationGroup{}
+	for _, loc := range locs {
+		key, label := keyOf(loc)
+		g := byKey[key]
+		if g == nil {
+			if key == "" {
+				label = "(none)"
+			}
+			g = &locationGroup{Key: key, Label: label}
+			byKey[key] = g
+		}
+		g.Locations = append(g.Locations, loc)
+	}
+
+	groups := make([]locationGroup, 0, len(byKey))
+	for _, g := range byKey {
+		sort.SliceStable(g.Locations, func(i, j int) bool {
+			a, b := g.Locations[i], g.Locations[j]
+			if !strings.EqualFold(a.Asname, b.Asname) {
+				return strings.ToLower(a.Asname) < strings.ToLower(b.Asname)
+			}
+			if a.As != b.As {
+				return a.As < b.As
+			}
+			if a.Lat != b.Lat {
+				return a.Lat < b.Lat
+			}
+			return a.Lon < b.Lon
+		})
+		groups = append(groups, *g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		a, b := groups[i], groups[j]
+		if (a.Key == "") != (b.Key == "") {
+			return b.Key == ""
+		}
+		if by == "" || by == "as" {
+			na, oka := parseASN(a.Key)
+			nb, okb := parseASN(b.Key)
+			if oka && okb {
+				return na < nb
+			}
+		}
+		return strings.ToLower(a.Label) < strings.ToLower(b.Label)
+	})
+	return groups, nil
+}
+
+// apiLocationsGroups returns the filtered locations grouped by ?by=as|asname|country|place.
+func apiLocationsGroups(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter, err := parseLocationFilter(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	groups, err := groupLocations(filterLocations(getCachedLocations(), filter), q.Get("by"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, groups)
+}
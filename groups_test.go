@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestGroupLocationsByPlace(t *testing.T) {
+	wro := &place{Country: "PL", CountryName: "Poland", Region: "Dolnośląskie", City: "Wrocław"}
+	locs := []ClientLocation{
+		{As: "AS2", Asname: "b", Place: wro},
+		{As: "AS1", Asname: "a", Place: wro},
+		{As: "AS3", Country: "de"}, // no place data: declared country
+		{As: "AS4", Place: &place{Country: "PL", CountryName: "Poland"}},
+		{As: "AS5"},
+	}
+	groups, err := groupLocations(locs, "place")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		label string
+		as    []string
+	}{
+		{"DE", []string{"AS3"}},
+		{"Poland", []string{"AS4"}},
+		{"Poland › Dolnośląskie › Wrocław", []string{"AS1", "AS2"}},
+		{"(none)", []string{"AS5"}},
+	}
+	if len(groups) != len(want) {
+		t.Fatalf("got %d groups: %+v", len(groups), groups)
+	}
+	for i, w := range want {
+		g := groups[i]
+		if g.Label != w.label || len(g.Locations) != len(w.as) {
+			t.Errorf("group %d = %q with %d locations, want %q with %d", i, g.Label, len(g.Locations), w.label, len(w.as))
+			continue
+		}
+		for j, as := range w.as {
+			if g.Locations[j].As != as {
+				t.Errorf("group %q location %d = %s, want %s", g.Label, j, g.Locations[j].As, as)
+			}
+		}
+	}
+
+	if _, err := groupLocations(locs, "city"); err == nil {
+		t.Error("by=city accepted")
+	}
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/michalswi/osm/utils"
+)
+
+// locationChange is one entry of a diff between two location sets.
+type locationChange struct {
+	Op     string    `json:"op"` // add, remove or change
+	Key    string    `json:"key"`
+	Fields []string  `json:"fields,omitempty"`
+	Before *Location `json:"before,omitempty"`
+	After  *Location `json:"after,omitempty"`
+}
+
+// revision is an immutable record of one change to the location set.
+type revision struct {
+	ID      int              `json:"id"`
+	Time    time.Time        `json:"time"`
+	Author  string           `json:"author"`
+	Source  string           `json:"source"` // file, git, api or rollback
+	Message string           `json:"message,omitempty"`
+	Added   int              `json:"added"`
+	Removed int              `json:"removed"`
+	Changed int              `json:"changed"`
+	Changes []locationChange `json:"changes,omitempty"`
+}
+
+// locationSet is a keyed, ordered snapshot of the location set.
+type locationSet struct {
+	keys  []string
+	items map[string]Location
+}
+
+// newLocationSet keys locations by id. Entries without an id are keyed by their
+// content, so editing them shows up as a remove and an add.
+func newLocationSet(locs []Location) locationSet {
+	set := locationSet{items: map[string]Location{}}
+	for _, loc := range locs {
+		key := loc.ID
+		if key == "" {
+			key = strings.Trim(strings.Join([]string{loc.As, loc.Location, loc.Prefix, loc.Name}, "|"), "|")
+		}
+		base := key
+		for n := 2; ; n++ {
+			if _, dup := set.items[key]; !dup {
+				break
+			}
+			key = fmt.Sprintf("%s#%d", base, n)
+		}
+		set.keys = append(set.keys, key)
+		set.items[key] = loc
+	}
+	return set
+}
+
+func (s locationSet) list() []Location {
+	out := make([]Location, 0, len(s.keys))
+	for _, k := range s.keys {
+		out = append(out, s.items[k])
+	}
+	return out
+}
+
+// apply replays changes on a copy of the set.
+func (s locationSet) apply(changes []locationChange) locationSet {
+	out := locationSet{items: make(map[string]Location, len(s.items))}
+	removed := map[string]bool{}
+	for _, c := range changes {
+		switch c.Op {
+		case "remove":
+			removed[c.Key] = true
+		case "change":
+			out.items[c.Key] = *c.After
+		}
+	}
+	for _, k := range s.keys {
+		if removed[k] {
+			continue
+		}
+		out.keys = append(out.keys, k)
+		if _, changed := out.items[k]; !changed {
+			out.items[k] = s.items[k]
+		}
+	}
+	for _, c := range changes {
+		if c.Op == "add" {
+			out.keys = append(out.keys, c.Key)
+			out.items[c.Key] = *c.After
+		}
+	}
+	return out
+}
+
+// diffLocationSets lists what changed between two snapshots.
+func diffLocationSets(from, to locationSet) []locationChange {
+	changes := []locationChange{}
+	for _, k := range from.keys {
+		before := from.items[k]
+		after, ok := to.items[k]
+		if !ok {
+			changes = append(changes, locationChange{Op: "remove", Key: k, Before: &before})
+			continue
+		}
+		if reflect.DeepEqual(before, after) {
+			continue
+		}
+		if fields := changedFields(before, after); len(fields) > 0 {
+			changes = append(changes, locationChange{Op: "change", Key: k, Fields: fields, Before: &before, After: &after})
+		}
+	}
+	for _, k := range to.keys {
+		if _, ok := from.items[k]; !ok {
+			after := to.items[k]
+			changes = append(changes, locationChange{Op: "add", Key: k, After: &after})
+		}
+	}
+	return changes
+}
+
+// changedFields names the JSON fields that differ between two locations.
+func changedFields(a, b Location) []string {
+	var ma, mb map[string]any
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	json.Unmarshal(ja, &ma)
+	json.Unmarshal(jb, &mb)
+	var fields []string
+	for k := range ma {
+		if !reflect.DeepEqual(ma[k], mb[k]) {
+			fields = append(fields, k)
+		}
+	}
+	for k := range mb {
+		if _, ok := ma[k]; !ok {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// locationHistory is the append-only revision log of the location set.
+type locationHistory struct {
+	mu   sync.Mutex
+	path string
+	revs []revision
+	head locationSet
+}
+
+// initHistory loads the revision log (LOCATION_HISTORY). The first load of the
+// store records its content if it differs from the last revision.
+func initHistory() {
+	h := &locationHistory{
+		path: utils.GetEnv("LOCATION_HISTORY", "source/history.jsonl"),
+		head: newLocationSet(nil),
+	}
+	if err := h.load(); err != nil {
+		logger.Fatalf("Location history setup failed: %v", err)
+	}
+	history = h
+	logger.Printf("Location history: %s (%d revisions)", h.path, len(h.revs))
+}
+
+func (h *locationHistory) load() error {
+	f, err := os.Open(h.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 1<<20), 1<<30)
+	for sc.Scan() {
+		if len(strings.TrimSpace(sc.Text())) == 0 {
+			continue
+		}
+		var rev revision
+		if err := json.Unmarshal(sc.Bytes(), &rev); err != nil {
+			return fmt.Errorf("%s: corrupt revision after %d: %v", h.path, len(h.revs), err)
+		}
+		h.head = h.head.apply(rev.Changes)
+		h.revs = append(h.revs, rev)
+	}
+	return sc.Err()
+}
+
+// record stores a revision if locs differ from the latest one. It returns the
+// new revision, or nil when nothing changed.
+func (h *locationHistory) record(locs []Location, author, source, message string) (*revision, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	next := newLocationSet(locs)
+	changes := diffLocationSets(h.head, next)
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	rev := revision{
+		ID:      len(h.revs) + 1,
+		Time:    time.Now().UTC(),
+		Author:  author,
+		Source:  source,
+		Message: message,
+		Changes: changes,
+	}
+	for _, c := range changes {
+		switch c.Op {
+		case "add":
+			rev.Added++
+		case "remove":
+			rev.Removed++
+		case "change":
+			rev.Changed++
+		}
+	}
+
+	line, err := json.Marshal(rev)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(h.path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		return nil, err
+	}
+
+	h.revs = append(h.revs, rev)
+	h.head = next
+	return &rev, nil
+}
+
+// snapshot returns the location set as of revision id (0 = empty).
+func (h *locationHistory) snapshot(id int) (locationSet, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if id < 0 || id > len(h.revs) {
+		return locationSet{}, fmt.Errorf("unknown revision: %d", id)
+	}
+	set := newLocationSet(nil)
+	for _, rev := range h.revs[:id] {
+		set = set.apply(rev.Changes)
+	}
+	return set, nil
+}
+
+func (h *locationHistory) latest() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.revs)
+}
+
+// loadStoredLocations lists the store and records a revision when its content
+// changed behind our back (e.g. locations.json edited by hand).
+func loadStoredLocations() ([]Location, error) {
+	locationWriteMu.Lock()
+	defer locationWriteMu.Unlock()
+	locs, err := locationStore.List()
+	if err != nil {
+		return nil, err
+	}
+	author, source, message := "filesystem", "file", ""
+	if c, ok := locationStore.(changeDescriber); ok {
+		author, message = c.LastChange()
+		source = "git"
+	}
+	if _, err := history.record(locs, author, source, message); err != nil {
+		logger.Printf("Failed to record location revision: %v", err)
+	}
+	return locs, nil
+}
+
+// recordLocationChange records the current store content as a revision after an
+// API write, logging failures. The caller holds locationWriteMu.
+func recordLocationChange(author, source, message string) {
+	locs, err := locationStore.List()
+	if err != nil {
+		logger.Printf("Failed to record location revision: %v", err)
+		return
+	}
+	if _, err := history.record(locs, author, source, message); err != nil {
+		logger.Printf("Failed to record location revision: %v", err)
+	}
+}
+
+// requestAuthor names the editor of an API write (X-Author header).
+func requestAuthor(r *http.Request) string {
+	if a := strings.TrimSpace(r.Header.Get("X-Author")); a != "" {
+		return a
+	}
+	return "api"
+}
+
+func parseRevision(s string, def int) (int, error) {
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid revision: %s", s)
+	}
+	return n, nil
+}
+
+// apiLocationsHistory lists the revisions, newest first, or returns a single one
+// with its changes (?rev=).
+func apiLocationsHistory(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	history.mu.Lock()
+	defer history.mu.Unlock()
+
+	if q.Get("rev") != "" {
+		id, err := parseRevision(q.Get("rev"), 0)
+		if err != nil || id < 1 || id > len(history.revs) {
+			http.Error(w, "unknown revision: "+q.Get("rev"), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, history.revs[id-1])
+		return
+	}
+
+	limit, err := parseRevision(q.Get("limit"), 50)
+	if err != nil || limit < 1 {
+		http.Error(w, "invalid limit", http.StatusBadRequest)
+		return
+	}
+	out := []revision{}
+	for i := len(history.revs) - 1; i >= 0 && len(out) < limit; i-- {
+		rev := history.revs[i]
+		rev.Changes = nil
+		out = append(out, rev)
+	}
+	writeJSON(w, out)
+}
+
+// apiLocationsDiff compares two revisions (from defaults to to-1, to to the
+// latest). format=text gives a readable listing.
+func apiLocationsDiff(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	to, err := parseRevision(q.Get("to"), history.latest())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, err := parseRevision(q.Get("from"), to-1)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if from < 0 {
+		from = 0
+	}
+
+	a, err := history.snapshot(from)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	b, err := history.snapshot(to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	changes := diffLocationSets(a, b)
+
+	if q.Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "revision %d..%d: %d changes\n", from, to, len(changes))
+		for _, c := range changes {
+			switch c.Op {
+			case "add":
+				j, _ := json.Marshal(c.After)
+				fmt.Fprintf(w, "+ %s %s\n", c.Key, j)
+			case "remove":
+				j, _ := json.Marshal(c.Before)
+				fmt.Fprintf(w, "- %s %s\n", c.Key, j)
+			case "change":
+				bj, _ := json.Marshal(c.Before)
+				aj, _ := json.Marshal(c.After)
+				fmt.Fprintf(w, "~ %s (%s)\n  - %s\n  + %s\n", c.Key, strings.Join(c.Fields, ", "), bj, aj)
+			}
+		}
+		return
+	}
+
+	writeJSON(w, struct {
+		From    int              `json:"from"`
+		To      int              `json:"to"`
+		Changes []locationChange `json:"changes"`
+	}{from, to, changes})
+}
+
+// apiLocationsRollback restores the location set of an earlier revision
+// (POST ?rev=) in one atomic store write, recorded as a new revision.
+func apiLocationsRollback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorizeWrite(w, r) {
+		return
+	}
+	locationWriteMu.Lock()
+	defer locationWriteMu.Unlock()
+
+	id, err := parseRevision(r.URL.Query().Get("rev"), -1)
+	if err != nil || id < 1 {
+		http.Error(w, "missing or invalid rev parameter", http.StatusBadRequest)
+		return
+	}
+	set, err := history.snapshot(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	// entries recorded without an id (hand-edited files) get one, as the kv
+	// store keys every entry by id
+	locs := set.list()
+	for i := range locs {
+		if locs[i].ID == "" {
+			locs[i].ID = newLocationID()
+		}
+	}
+
+	message := fmt.Sprintf("rollback to revision %d", id)
+	setWriteAuthor(r, message)
+	if err := locationStore.Replace(locs); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	invalidateLocationsCache()
+
+	rev, err := history.record(locs, requestAuthor(r), "rollback", message)
+	if err != nil {
+		logger.Printf("Failed to record location revision: %v", err)
+	}
+	if rev == nil {
+		writeJSON(w, struct {
+			Message string `json:"message"`
+		}{"already at revision " + strconv.Itoa(id)})
+		return
+	}
+	rev.Changes = nil
+	writeJSON(w, rev)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestRollbackAssignsIDs(t *testing.T) {
+	dir := t.TempDir()
+	store, err := openKVStore(filepath.Join(dir, "locations.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	oldStore, oldHistory, oldToken := locationStore, history, apiToken
+	locationStore, apiToken = store, "secret"
+	history = &locationHistory{path: filepath.Join(dir, "history.jsonl"), head: newLocationSet(nil)}
+	defer func() { locationStore, history, apiToken = oldStore, oldHistory, oldToken }()
+
+	// a revision recorded from a hand-edited file, entries without ids
+	if _, err := history.record([]Location{{As: "AS1", Location: "51.1,17.03"}, {As: "AS2", Location: "52.23,21.01"}}, "file", "file", ""); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/api/locations/rollback?rev=1", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	apiLocationsRollback(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("rollback = %d %s", w.Code, w.Body)
+	}
+
+	locs, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locs) != 2 || locs[0].As != "AS1" || locs[1].As != "AS2" {
+		t.Fatalf("store after rollback = %+v", locs)
+	}
+	for _, loc := range locs {
+		if loc.ID == "" {
+			t.Errorf("%s restored without an id", loc.As)
+		}
+	}
+}
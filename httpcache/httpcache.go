@@ -0,0 +1,266 @@
+// Package httpcache is a small LRU cache of upstream HTTP responses with
+// per-entry expiry, optionally persisted to a size-capped directory so it
+// survives restarts.
+package httpcache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is one cached response.
+type Entry struct {
+	Key         string    `json:"key"`
+	Status      int       `json:"status"`
+	ContentType string    `json:"content_type"`
+	Body        []byte    `json:"body"`
+	Expires     time.Time `json:"expires"`
+}
+
+// Stats are the cache counters since start.
+type Stats struct {
+	Entries   int    `json:"entries"`
+	Capacity  int    `json:"capacity"`
+	Hits      uint64 `json:"hits"`
+	DiskHits  uint64 `json:"disk_hits"` // included in Hits
+	Misses    uint64 `json:"misses"`
+	Stores    uint64 `json:"stores"`
+	Evictions uint64 `json:"evictions"`
+	Dir       string `json:"dir,omitempty"`
+
+	DiskEntries   int    `json:"disk_entries,omitempty"`
+	DiskCapacity  int    `json:"disk_capacity,omitempty"`
+	DiskEvictions uint64 `json:"disk_evictions,omitempty"` // expired files included
+}
+
+// Cache is safe for concurrent use.
+type Cache struct {
+	mu        sync.Mutex
+	size      int
+	dir       string
+	diskSize  int
+	diskCount int        // files in dir
+	ll        *list.List // front: most recently used
+	items     map[string]*list.Element
+	stats     Stats
+}
+
+// New returns a cache holding up to size entries in memory. When dir is not
+// empty, entries are also written there, up to diskSize files, and read back
+// on memory misses. Each file's modification time is set to its expiry, so the
+// directory is pruned without reading the files: expired ones first, then the
+// ones closest to expiry.
+func New(size int, dir string, diskSize int) (*Cache, error) {
+	if size < 1 {
+		size = 1
+	}
+	if diskSize < 1 {
+		diskSize = 1
+	}
+	c := &Cache{size: size, dir: dir, diskSize: diskSize, ll: list.New(), items: map[string]*list.Element{}}
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		if err := c.pruneDisk(diskSize); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Get returns the unexpired entry for key.
+func (c *Cache) Get(key string) (Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(Entry)
+		if now.Before(e.Expires) {
+			c.ll.MoveToFront(el)
+			c.stats.Hits++
+			return e, true
+		}
+		c.ll.Remove(el)
+		delete(c.items, key)
+	}
+	if e, ok := c.readDisk(key); ok {
+		if now.Before(e.Expires) {
+			c.add(e)
+			c.stats.Hits++
+			c.stats.DiskHits++
+			return e, true
+		}
+		c.removeDisk(c.diskPath(key))
+	}
+	c.stats.Misses++
+	return Entry{}, false
+}
+
+// Put stores e under e.Key until e.Expires.
+func (c *Cache) Put(e Entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[e.Key]; ok {
+		c.ll.Remove(el)
+		delete(c.items, e.Key)
+	}
+	c.add(e)
+	c.stats.Stores++
+	if c.dir != "" {
+		c.writeDisk(e)
+	}
+}
+
+// Stats returns a snapshot of the counters.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Entries, s.Capacity, s.Dir = c.ll.Len(), c.size, c.dir
+	if c.dir != "" {
+		s.DiskEntries, s.DiskCapacity = c.diskCount, c.diskSize
+	}
+	return s
+}
+
+func (c *Cache) add(e Entry) {
+	c.items[e.Key] = c.ll.PushFront(e)
+	for c.ll.Len() > c.size {
+		el := c.ll.Back()
+		c.ll.Remove(el)
+		delete(c.items, el.Value.(Entry).Key)
+		c.stats.Evictions++
+	}
+}
+
+func (c *Cache) diskPath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// writeDisk stores e in the directory and prunes it when it is over capacity.
+func (c *Cache) writeDisk(e Entry) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	path := c.diskPath(e.Key)
+	_, err = os.Stat(path)
+	existed := err == nil
+	// write then rename so a crash never leaves a half-written entry
+	tmp := path + ".tmp"
+	if os.WriteFile(tmp, data, 0644) != nil {
+		os.Remove(tmp)
+		return
+	}
+	os.Chtimes(tmp, time.Now(), e.Expires)
+	if os.Rename(tmp, path) != nil {
+		os.Remove(tmp)
+		return
+	}
+	if !existed {
+		c.diskCount++
+	}
+	if c.diskCount > c.diskSize {
+		// prune to 90% so a full cache is not rescanned on every write
+		c.pruneDisk(c.diskSize * 9 / 10)
+	}
+}
+
+func (c *Cache) removeDisk(path string) {
+	if os.Remove(path) == nil {
+		c.diskCount--
+		c.stats.DiskEvictions++
+	}
+}
+
+// pruneDisk removes the expired files and then the ones closest to expiry
+// until at most keep are left, and recounts the files.
+func (c *Cache) pruneDisk(keep int) error {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	type file struct {
+		path    string
+		expires time.Time
+	}
+	var files []file
+	for _, de := range entries {
+		if de.IsDir() || filepath.Ext(de.Name()) != ".json" {
+			continue
+		}
+		if fi, err := de.Info(); err == nil {
+			files = append(files, file{filepath.Join(c.dir, de.Name()), fi.ModTime()})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].expires.Before(files[j].expires) })
+
+	c.diskCount = len(files)
+	now := time.Now()
+	for _, f := range files {
+		if c.diskCount <= keep && now.Before(f.expires) {
+			break
+		}
+		c.removeDisk(f.path)
+	}
+	return nil
+}
+
+func (c *Cache) readDisk(key string) (Entry, bool) {
+	if c.dir == "" {
+		return Entry{}, false
+	}
+	data, err := os.ReadFile(c.diskPath(key))
+	if err != nil {
+		return Entry{}, false
+	}
+	var e Entry
+	if json.Unmarshal(data, &e) != nil || e.Key != key {
+		return Entry{}, false
+	}
+	return e, true
+}
+
+// TTL returns how long a response may be cached: its Cache-Control max-age
+// (s-maxage first), else def. ok is false when the response must not be
+// stored (no-store, no-cache, private or a zero max-age).
+func TTL(h http.Header, def time.Duration) (ttl time.Duration, ok bool) {
+	ttl = def
+	var maxAge, sMaxAge = -1, -1
+	for _, d := range strings.Split(h.Get("Cache-Control"), ",") {
+		name, val, _ := strings.Cut(strings.TrimSpace(strings.ToLower(d)), "=")
+		switch name {
+		case "no-store", "no-cache", "private":
+			return 0, false
+		case "max-age":
+			if n, err := strconv.Atoi(strings.Trim(val, `"`)); err == nil {
+				maxAge = n
+			}
+		case "s-maxage":
+			if n, err := strconv.Atoi(strings.Trim(val, `"`)); err == nil {
+				sMaxAge = n
+			}
+		}
+	}
+	switch {
+	case sMaxAge >= 0:
+		ttl = time.Duration(sMaxAge) * time.Second
+	case maxAge >= 0:
+		ttl = time.Duration(maxAge) * time.Second
+	}
+	return ttl, ttl > 0
+}
@@ -0,0 +1,163 @@
+package httpcache
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func entry(key string, ttl time.Duration) Entry {
+	return Entry{Key: key, Status: 200, ContentType: "application/json", Body: []byte(`["` + key + `"]`), Expires: time.Now().Add(ttl)}
+}
+
+func diskFiles(t *testing.T, dir string) int {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(files)
+}
+
+func TestLRU(t *testing.T) {
+	c, err := New(2, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Put(entry("a", time.Hour))
+	c.Put(entry("b", time.Hour))
+	c.Get("a") // b is now the least recently used
+	c.Put(entry("c", time.Hour))
+	if _, ok := c.Get("b"); ok {
+		t.Error("b not evicted")
+	}
+	for _, k := range []string{"a", "c"} {
+		if e, ok := c.Get(k); !ok || string(e.Body) != `["`+k+`"]` {
+			t.Errorf("Get(%s) = %+v, %v", k, e, ok)
+		}
+	}
+
+	c.Put(entry("old", -time.Second)) // evicts a, and is dropped when read
+	if _, ok := c.Get("old"); ok {
+		t.Error("expired entry returned")
+	}
+	s := c.Stats()
+	if s.Entries != 1 || s.Hits != 3 || s.Misses != 2 || s.Stores != 4 || s.Evictions != 2 {
+		t.Errorf("stats = %+v", s)
+	}
+}
+
+func TestDiskPersistence(t *testing.T) {
+	dir := t.TempDir()
+	c, err := New(10, dir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Put(entry("a", time.Hour))
+	c.Put(entry("a", time.Hour)) // rewriting a file keeps the count
+	c.Put(entry("gone", -time.Second))
+	if s := c.Stats(); s.DiskEntries != 2 {
+		t.Errorf("disk entries = %d, want 2", s.DiskEntries)
+	}
+
+	// a new cache, as after a restart: the expired file is dropped on open
+	c, err = New(10, dir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := c.Stats(); s.DiskEntries != 1 || diskFiles(t, dir) != 1 {
+		t.Errorf("disk entries = %d, files = %d, want 1", s.DiskEntries, diskFiles(t, dir))
+	}
+	e, ok := c.Get("a")
+	if !ok || string(e.Body) != `["a"]` {
+		t.Fatalf("Get(a) from disk = %+v, %v", e, ok)
+	}
+	if s := c.Stats(); s.DiskHits != 1 {
+		t.Errorf("disk hits = %d, want 1", s.DiskHits)
+	}
+}
+
+func TestDiskCapacity(t *testing.T) {
+	dir := t.TempDir()
+	c, err := New(1, dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 25; i++ {
+		c.Put(entry(fmt.Sprintf("k%02d", i), time.Duration(i+1)*time.Hour))
+		if n := diskFiles(t, dir); n > 10 {
+			t.Fatalf("%d files after %d puts, want at most 10", n, i+1)
+		}
+	}
+	if s := c.Stats(); s.DiskEntries != diskFiles(t, dir) || s.DiskEvictions == 0 {
+		t.Errorf("stats = %+v, %d files", s, diskFiles(t, dir))
+	}
+	// the entries closest to expiry went first
+	if _, ok := c.Get("k00"); ok {
+		t.Error("k00 still cached")
+	}
+	if _, ok := c.Get("k23"); !ok {
+		t.Error("k23 not cached")
+	}
+
+	// a directory over the cap (e.g. after lowering it) is pruned on open
+	if c, err = New(1, dir, 3); err != nil {
+		t.Fatal(err)
+	}
+	if n := diskFiles(t, dir); n != 3 || c.Stats().DiskEntries != 3 {
+		t.Errorf("%d files after reopening with a cap of 3", n)
+	}
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("x"), 0644)
+	if _, err := New(1, dir, 3); err != nil || diskFiles(t, dir) != 3 {
+		t.Errorf("foreign file: %v", err)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	def := time.Hour
+	tests := []struct {
+		cacheControl string
+		ttl          time.Duration
+		ok           bool
+	}{
+		{"", def, true},
+		{"max-age=60", time.Minute, true},
+		{"public, max-age=60, s-maxage=120", 2 * time.Minute, true},
+		{`max-age="30"`, 30 * time.Second, true},
+		{"max-age=0", 0, false},
+		{"no-store", 0, false},
+		{"No-Cache", 0, false},
+		{"private, max-age=60", 0, false},
+		{"max-age=abc", def, true},
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		if tt.cacheControl != "" {
+			h.Set("Cache-Control", tt.cacheControl)
+		}
+		ttl, ok := TTL(h, def)
+		if ok != tt.ok || ok && ttl != tt.ttl {
+			t.Errorf("TTL(%q) = %s, %v, want %s, %v", tt.cacheControl, ttl, ok, tt.ttl, tt.ok)
+		}
+	}
+}
+
+func TestDiskExpiredOnGet(t *testing.T) {
+	dir := t.TempDir()
+	c, err := New(1, dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Put(entry("a", 20*time.Millisecond))
+	c.Put(entry("b", time.Hour)) // a is now only on disk
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("expired entry returned from disk")
+	}
+	if s := c.Stats(); s.DiskEntries != 1 || diskFiles(t, dir) != 1 || s.DiskEvictions != 1 {
+		t.Errorf("stats = %+v, %d files, want one entry and one eviction", s, diskFiles(t, dir))
+	}
+}
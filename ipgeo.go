@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/michalswi/osm/geoip"
+)
+
+// initGeoIP opens the offline geolocation/ASN databases listed in GEOIP_DB, if any.
+func initGeoIP() {
+	paths := os.Getenv("GEOIP_DB")
+	if paths == "" {
+		logger.Println("GeoIP disabled - IP prefixes cannot be resolved")
+		return
+	}
+
+	db, err := geoip.Open(paths)
+	if err != nil {
+		logger.Fatalf("GeoIP database setup failed: %v", err)
+	}
+	geoDB = db
+	logger.Println("GeoIP database loaded:", paths)
+}
+
+// resolvePrefix looks up an IP address or CIDR prefix in the offline database.
+func resolvePrefix(prefix string) (geoip.Record, error) {
+	if geoDB == nil {
+		return geoip.Record{}, fmt.Errorf("no GeoIP database configured (GEOIP_DB)")
+	}
+	addr, err := geoip.ParseAddr(prefix)
+	if err != nil {
+		return geoip.Record{}, fmt.Errorf("invalid prefix: %s", prefix)
+	}
+	rec, ok := geoDB.Lookup(addr)
+	if !ok || !rec.HasLocation {
+		return geoip.Record{}, fmt.Errorf("no location known for %s", prefix)
+	}
+	return rec, nil
+}
+
+// maxLookupIPs limits the size of a batch lookup.
+const maxLookupIPs = 10000
+
+// ipLookupResult is what the offline database knows about one looked up address.
+type ipLookupResult struct {
+	IP    string `json:"ip"`
+	Found bool   `json:"found"`
+	Error string `json:"error,omitempty"`
+	geoip.Record
+}
+
+// lookupIP resolves a single address; failures are reported in the result.
+func lookupIP(s string) ipLookupResult {
+	res := ipLookupResult{IP: s}
+	addr, err := geoip.ParseAddr(s)
+	if err != nil {
+		res.Error = "invalid address"
+		return res
+	}
+	res.IP = addr.String()
+	rec, ok := geoDB.Lookup(addr)
+	if !ok {
+		res.Error = "not found"
+		return res
+	}
+	res.Record, res.Found = rec, rec.HasLocation
+	if !rec.HasLocation {
+		res.Error = "no location known"
+	}
+	return res
+}
+
+// splitIPs splits pasted text on whitespace, commas and semicolons.
+func splitIPs(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+}
+
+// parseLookupIPs reads the addresses to look up: repeated ip params, a POSTed
+// JSON body ({"ips":[...]}) or POSTed plain text with one or more addresses per line.
+func parseLookupIPs(r *http.Request) ([]string, error) {
+	var raw []string
+	for _, v := range r.URL.Query()["ip"] {
+		raw = append(raw, splitIPs(v)...)
+	}
+	if r.Method == http.MethodPost {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(strings.TrimSpace(string(data)), "{") {
+			var body struct {
+				IPs []string `json:"ips"`
+			}
+			if err := json.Unmarshal(data, &body); err != nil {
+				return nil, fmt.Errorf("invalid JSON body: %v", err)
+			}
+			raw = append(raw, body.IPs...)
+		} else {
+			raw = append(raw, splitIPs(string(data))...)
+		}
+	}
+
+	seen := map[string]bool{}
+	ips := []string{}
+	for _, ip := range raw {
+		ip = strings.Trim(strings.TrimSpace(ip), "[]\"'")
+		if ip == "" || seen[ip] {
+			continue
+		}
+		seen[ip] = true
+		ips = append(ips, ip)
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("missing ip parameter")
+	}
+	if len(ips) > maxLookupIPs {
+		return nil, fmt.Errorf("too many addresses (max %d)", maxLookupIPs)
+	}
+	return ips, nil
+}
+
+// apiIPLookup geolocates one or many addresses through the offline database.
+// format=csv or format=geojson exports the result.
+func apiIPLookup(w http.ResponseWriter, r *http.Request) {
+	if geoDB == nil {
+		http.Error(w, "no GeoIP database configured (GEOIP_DB)", http.StatusNotFound)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+	ips, err := parseLookupIPs(r)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	results := make([]ipLookupResult, 0, len(ips))
+	for _, ip := range ips {
+		results = append(results, lookupIP(ip))
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "csv":
+		writeLookupCSV(w, results)
+	case "geojson":
+		writeLookupGeoJSON(w, results)
+	case "", "json":
+		if r.Method == http.MethodGet && len(results) == 1 {
+			writeJSON(w, results[0])
+			return
+		}
+		writeJSON(w, results)
+	default:
+		http.Error(w, "unknown format (json, csv, geojson)", http.StatusBadRequest)
+	}
+}
+
+func writeLookupCSV(w http.ResponseWriter, results []ipLookupResult) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="ip-lookup.csv"`)
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"ip", "found", "lat", "lon", "accuracy_km", "country", "city", "asn", "as_org", "network", "error"})
+	for _, res := range results {
+		row := []string{res.IP, strconv.FormatBool(res.Found), "", "", "", res.Country, res.City, "", res.ASOrg, res.Network, res.Error}
+		if res.Found {
+			row[2] = strconv.FormatFloat(res.Lat, 'f', -1, 64)
+			row[3] = strconv.FormatFloat(res.Lon, 'f', -1, 64)
+			row[4] = strconv.FormatFloat(res.AccuracyKm, 'f', -1, 64)
+		}
+		if res.ASN != 0 {
+			row[7] = fmt.Sprintf("AS%d", res.ASN)
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+}
+
+func writeLookupGeoJSON(w http.ResponseWriter, results []ipLookupResult) {
+	type feature struct {
+		Type     string `json:"type"`
+		Geometry struct {
+			Type        string     `json:"type"`
+			Coordinates [2]float64 `json:"coordinates"`
+		} `json:"geometry"`
+		Properties ipLookupResult `json:"properties"`
+	}
+	fc := struct {
+		Type     string    `json:"type"`
+		Features []feature `json:"features"`
+	}{Type: "FeatureCollection", Features: []feature{}}
+	for _, res := range results {
+		if !res.Found {
+			continue
+		}
+		f := feature{Type: "Feature", Properties: res}
+		f.Geometry.Type = "Point"
+		f.Geometry.Coordinates = [2]float64{res.Lon, res.Lat}
+		fc.Features = append(fc.Features, f)
+	}
+	w.Header().Set("Content-Type", "application/geo+json")
+	w.Header().Set("Content-Disposition", `attachment; filename="ip-lookup.geojson"`)
+	if err := json.NewEncoder(w).Encode(fc); err != nil {
+		http.Error(w, "encode error", 500)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// errQueueFull is returned when too many callers already wait for a token.
+var errQueueFull = errors.New("too many queued requests")
+
+// limiter is a token bucket holding a single token, refilled every interval,
+// so callers are spaced out by interval. At most maxQueue callers wait for
+// their turn; more are rejected instead of piling up.
+type limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	maxQueue int
+	next     time.Time // when the next token is available
+	waiting  int
+	stat     limiterStats
+}
+
+// limiterStats are the limiter counters since start.
+type limiterStats struct {
+	PerSecond float64 `json:"per_second"`
+	MaxQueue  int     `json:"max_queue"`
+	Waiting   int     `json:"waiting"`
+	Passed    uint64  `json:"passed"`
+	Delayed   uint64  `json:"delayed"` // included in Passed
+	Rejected  uint64  `json:"rejected"`
+}
+
+func newLimiter(perSecond float64, maxQueue int) *limiter {
+	return &limiter{interval: time.Duration(float64(time.Second) / perSecond), maxQueue: maxQueue}
+}
+
+// wait blocks until the caller may make its request. It returns errQueueFull
+// right away when the queue is full, or the context's error if it is cancelled
+// first (the reserved slot is then left unused).
+func (l *limiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	if delay > 0 && l.waiting >= l.maxQueue {
+		l.stat.Rejected++
+		l.mu.Unlock()
+		return errQueueFull
+	}
+	l.next = l.next.Add(l.interval)
+	l.stat.Passed++
+	if delay == 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	l.waiting++
+	l.stat.Delayed++
+	l.mu.Unlock()
+
+	defer func() {
+		l.mu.Lock()
+		l.waiting--
+		l.mu.Unlock()
+	}()
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryAfter is the number of seconds until a full queue has room again.
+func (l *limiter) retryAfter() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(time.Until(l.next)/time.Second) + 1
+}
+
+func (l *limiter) stats() limiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := l.stat
+	s.PerSecond, s.MaxQueue, s.Waiting = float64(time.Second)/float64(l.interval), l.maxQueue, l.waiting
+	return s
+}
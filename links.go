@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/michalswi/osm/geo"
+)
+
+// Link is an AS-to-AS or site-to-site edge as written in links.json. From and To
+// reference a location id or an AS number ("AS49242").
+type Link struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Type     string `json:"type"`
+	Capacity string `json:"capacity,omitempty"`
+	Details  string `json:"details,omitempty"`
+}
+
+// ClientLink is a link resolved against the loaded locations, ready to draw.
+type ClientLink struct {
+	From         ClientLocation `json:"from"`
+	To           ClientLocation `json:"to"`
+	Type         string         `json:"type"`
+	Capacity     string         `json:"capacity,omitempty"`
+	CapacityMbps float64        `json:"capacity_mbps,omitempty"`
+	Details      string         `json:"details,omitempty"`
+	DistanceKm   float64        `json:"distance_km"`
+	Path         []geo.Point    `json:"path"`
+}
+
+var linkTypes = map[string]bool{"transit": true, "peer": true, "customer": true}
+
+// readLinks loads links.json. A missing file simply means no links.
+func readLinks() ([]Link, error) {
+	data, err := os.ReadFile(linksJson)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Link{}, nil
+		}
+		return nil, err
+	}
+
+	var links []Link
+	if err := json.Unmarshal(data, &links); err != nil {
+		return nil, err
+	}
+
+	valid := []Link{}
+	for _, l := range links {
+		l.Type = strings.ToLower(strings.TrimSpace(l.Type))
+		if !linkTypes[l.Type] {
+			logger.Printf("Skipping link %s-%s with unknown type %q", l.From, l.To, l.Type)
+			continue
+		}
+		valid = append(valid, l)
+	}
+	return valid, nil
+}
+
+// parseCapacity converts "10G", "400M", "1.6T" or a bare number of Mbps to Mbps.
+func parseCapacity(s string) (float64, error) {
+	s = strings.TrimSpace(strings.ToUpper(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "BPS"), "B")
+	mult := 1.0
+	switch {
+	case strings.HasSuffix(s, "T"):
+		mult, s = 1e6, strings.TrimSuffix(s, "T")
+	case strings.HasSuffix(s, "G"):
+		mult, s = 1e3, strings.TrimSuffix(s, "G")
+	case strings.HasSuffix(s, "M"):
+		s = strings.TrimSuffix(s, "M")
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid capacity: %s", s)
+	}
+	return v * mult, nil
+}
+
+// endpointCandidates returns the locations a link endpoint refers to: the location
+// with that id, otherwise every location of that AS.
+func endpointCandidates(ref string, locs []ClientLocation) []ClientLocation {
+	var out []ClientLocation
+	for _, loc := range locs {
+		if loc.ID != "" && loc.ID == ref {
+			return []ClientLocation{loc}
+		}
+	}
+	asn, ok := parseASN(ref)
+	if !ok {
+		return nil
+	}
+	for _, loc := range locs {
+		if n, ok := parseASN(loc.As); ok && n == asn {
+			out = append(out, loc)
+		}
+	}
+	return out
+}
+
+// resolveLinks places every link between its endpoints. When an endpoint is an AS
+// with several sites, the closest pair of sites is used.
+func resolveLinks(links []Link, locs []ClientLocation) []ClientLink {
+	out := []ClientLink{}
+	for _, l := range links {
+		froms := endpointCandidates(l.From, locs)
+		tos := endpointCandidates(l.To, locs)
+		if len(froms) == 0 || len(tos) == 0 {
+			continue
+		}
+
+		best := -1.0
+		var from, to ClientLocation
+		for _, f := range froms {
+			for _, t := range tos {
+				d := geo.Haversine(geo.Point{Lat: f.Lat, Lon: f.Lon}, geo.Point{Lat: t.Lat, Lon: t.Lon})
+				if best < 0 || d < best {
+					best, from, to = d, f, t
+				}
+			}
+		}
+
+		a, b := geo.Point{Lat: from.Lat, Lon: from.Lon}, geo.Point{Lat: to.Lat, Lon: to.Lon}
+		cl := ClientLink{
+			From:       from,
+			To:         to,
+			Type:       l.Type,
+			Capacity:   l.Capacity,
+			Details:    l.Details,
+			DistanceKm: geo.Vincenty(a, b) / 1000,
+			Path:       geo.GreatCircle(a, b, 64),
+		}
+		if l.Capacity != "" {
+			if mbps, err := parseCapacity(l.Capacity); err == nil {
+				cl.CapacityMbps = mbps
+			}
+		}
+		out = append(out, cl)
+	}
+	return out
+}
+
+// apiLinks returns the links whose endpoints are among the (filtered) locations.
+func apiLinks(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseLocationFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	locs := filterLocations(getCachedLocations(), filter)
+	writeJSON(w, resolveLinks(getCachedLinks(), locs))
+}
@@ -3,18 +3,20 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/michalswi/osm/coords"
+	"github.com/michalswi/osm/geo"
+	"github.com/michalswi/osm/gitrepo"
 	"github.com/michalswi/osm/server"
 	"github.com/michalswi/osm/utils"
 )
@@ -35,22 +37,59 @@ type Request struct {
 }
 
 type Location struct {
-	Location string `json:"location"`
-	As       string `json:"as"`
-	Asname   string `json:"asname"`
-	Details  string `json:"details"`
+	ID        string            `json:"id,omitempty"`
+	Location  string            `json:"location"`
+	CRS       string            `json:"crs,omitempty"`
+	As        string            `json:"as"`
+	Asname    string            `json:"asname"`
+	Details   string            `json:"details"`
+	Country   string            `json:"country,omitempty"`
+	Tags      []string          `json:"tags,omitempty"`
+	Category  string            `json:"category,omitempty"`
+	Extra     map[string]string `json:"extra,omitempty"`
+	Name      string            `json:"name,omitempty"`
+	Geometry  *geo.Geometry     `json:"geometry,omitempty"`
+	ValidFrom string            `json:"valid_from,omitempty"`
+	ValidTo   string            `json:"valid_to,omitempty"`
+	Prefix    string            `json:"prefix,omitempty"`
 }
 
 type ClientLocation struct {
-	Lat     float64 `json:"lat"`
-	Lon     float64 `json:"lon"`
-	As      string  `json:"as"`
-	Asname  string  `json:"asname"`
-	Details string  `json:"details"`
+	ID         string            `json:"id,omitempty"`
+	Lat        float64           `json:"lat"`
+	Lon        float64           `json:"lon"`
+	As         string            `json:"as"`
+	Asname     string            `json:"asname"`
+	Details    string            `json:"details"`
+	Country    string            `json:"country,omitempty"`
+	Tags       []string          `json:"tags"`
+	Category   string            `json:"category"`
+	Extra      map[string]string `json:"extra,omitempty"`
+	ValidFrom  *time.Time        `json:"valid_from,omitempty"`
+	ValidTo    *time.Time        `json:"valid_to,omitempty"`
+	Prefix     string            `json:"prefix,omitempty"`
+	Derived    bool              `json:"derived,omitempty"`
+	AccuracyKm float64           `json:"accuracy_km,omitempty"`
+	Place      *place            `json:"place,omitempty"`
+
+	// registryCountry is set when Country was filled in from the ASN registry
+	// (the AS's home country) rather than given in the location entry.
+	registryCountry bool
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			logger.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+
 	initProxy()
+	initGeocoder()
+	initGeoIP()
+	initStore()
+	initHistory()
 
 	logDir := utils.GetEnv("LOG_DIR", "oms")
 	logPath = logDirCreation(logDir)
@@ -60,12 +99,36 @@ func main() {
 	mux.HandleFunc("/hz", hz)
 	mux.HandleFunc("/robots.txt", robots)
 	mux.HandleFunc("/api/locations", apiLocations)
+	mux.HandleFunc("/api/locations/search", apiLocationsSearch)
+	mux.HandleFunc("/api/locations/groups", apiLocationsGroups)
+	mux.HandleFunc("/api/locations/validate", apiLocationsValidate)
+	mux.HandleFunc("/api/locations/nearest", apiLocationsNearest)
+	mux.HandleFunc("/api/locations/within", apiLocationsWithin)
+	mux.HandleFunc("/api/locations/history", apiLocationsHistory)
+	mux.HandleFunc("/api/locations/diff", apiLocationsDiff)
+	mux.HandleFunc("/api/locations/rollback", apiLocationsRollback)
+	mux.HandleFunc("/api/density", apiDensity)
+	mux.HandleFunc("/api/stats/countries", apiStatsCountries)
+	mux.HandleFunc("/api/geocoder/stats", apiGeocoderStats)
+	mux.HandleFunc("/proxy/nominatim", proxyNominatim)
+	mux.HandleFunc("/proxy/reverse", proxyReverse)
+	mux.HandleFunc("/api/geo/distance", apiGeoDistance)
+	mux.HandleFunc("/api/parse-coordinate", apiParseCoordinate)
+	mux.HandleFunc("/api/convert", apiConvert)
+	mux.HandleFunc("/api/geo/area", apiGeoArea)
+	mux.HandleFunc("/api/geo/contains", apiGeoContains)
+	mux.HandleFunc("/api/areas", apiAreas)
+	mux.HandleFunc("/api/links", apiLinks)
+	mux.HandleFunc("/api/peeringdb/facilities", apiPeeringDBFacilities)
+	mux.HandleFunc("/api/peeringdb/ixps", apiPeeringDBIXPs)
+	mux.HandleFunc("/api/ip/lookup", apiIPLookup)
+	mux.HandleFunc("/api/trace", apiTrace)
+	mux.HandleFunc("/trace", tracePage)
 	mux.Handle("/web/", http.StripPrefix("/web/",
 		http.FileServer(http.Dir("web"))))
 
 	if proxyEnabled {
 		mux.HandleFunc("/proxy/tiles/", proxyTiles)
-		mux.HandleFunc("/proxy/nominatim", proxyNominatim)
 		logger.Println("Proxy endpoints enabled")
 	}
 
@@ -81,14 +144,62 @@ func main() {
 	gracefulShutdown(srv)
 }
 
-// apiLocations returns the current (possibly cached) list of client locations as JSON.
+// apiLocations returns the current (possibly cached) list of client locations as JSON,
+// optionally filtered by tag, category and at (validity time) query params. rev
+// serves an older state of a git store. Other methods edit the store.
 func apiLocations(w http.ResponseWriter, r *http.Request) {
-	locs := getCachedLocations()
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		apiLocationsWrite(w, r)
+		return
+	}
+	filter, err := parseLocationFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if rev := r.URL.Query().Get("rev"); rev != "" {
+		rs, ok := locationStore.(revisionedStore)
+		if !ok {
+			http.Error(w, "the location store keeps no revisions (use a git store)", http.StatusBadRequest)
+			return
+		}
+		stored, err := rs.ListAt(rev)
+		if errors.Is(err, gitrepo.ErrInvalidRef) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		locs, _ := convertLocations(stored)
+		enrichLocations(locs)
+		reverseGeocode(locs)
+		writeJSON(w, filterLocations(locs, filter))
+		return
+	}
+
+	writeJSON(w, filterLocations(getCachedLocations(), filter))
+}
+
+// writeJSON encodes v as the JSON response body.
+func writeJSON(w http.ResponseWriter, v any) {
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(locs); err != nil {
+	if err := json.NewEncoder(w).Encode(v); err != nil {
 		http.Error(w, "encode error", 500)
+	}
+}
+
+// writeBodyError reports an unreadable request body, with 413 when it is over the
+// http.MaxBytesReader limit.
+func writeBodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("request body larger than %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
 		return
 	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
 }
 
 // logDirCreation ensures the log directory exists under /tmp and returns its full path.
@@ -199,63 +310,136 @@ func logRequestDetails(r *http.Request) {
 	}
 }
 
-// parseLocationString splits a "latitude,longitude" string into floats with validation.
+// parseLocationString parses a location string into floats with validation.
+// Besides "latitude,longitude" it accepts every format of the coords package
+// (DMS, DDM, geo: URI, geohash, plus code).
 func parseLocationString(locStr string) (lat, lon float64, err error) {
-	parts := strings.Split(locStr, ",")
-	if len(parts) != 2 {
-		return 0, 0, fmt.Errorf("invalid location format: %s", locStr)
-	}
-
-	lat, err = strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	c, err := coords.Parse(locStr)
 	if err != nil {
-		return 0, 0, fmt.Errorf("invalid latitude: %s", parts[0])
-	}
-	if lat < -90 || lat > 90 {
-		return 0, 0, fmt.Errorf("latitude out of range: %f", lat)
+		return 0, 0, err
 	}
+	return c.Lat, c.Lon, nil
+}
 
-	lon, err = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
-	if err != nil {
-		return 0, 0, fmt.Errorf("invalid longitude: %s", parts[1])
+// locationPoint returns the WGS84 position of a location, reading Location in
+// its crs (e.g. "EPSG:2180" with "easting,northing") when one is set.
+func locationPoint(loc Location) (lat, lon float64, err error) {
+	if loc.CRS == "" {
+		return parseLocationString(loc.Location)
 	}
-	if lon < -180 || lon > 180 {
-		return 0, 0, fmt.Errorf("longitude out of range: %f", lon)
+	c, err := coords.FromCRS(loc.CRS, loc.Location)
+	if err != nil {
+		return 0, 0, err
 	}
-
-	return lat, lon, nil
+	return c.Lat, c.Lon, nil
 }
 
-// readLocations loads locations.json, validates coordinates, and converts to ClientLocation slice.
-func readLocations() ([]ClientLocation, error) {
-	data, err := os.ReadFile(sourceJson)
-	if err != nil {
-		return nil, err
+// parseValidity parses the optional valid_from/valid_to bounds of a location.
+func parseValidity(loc Location) (from, to *time.Time, err error) {
+	if loc.ValidFrom != "" {
+		t, err := parseTimestamp(loc.ValidFrom)
+		if err != nil {
+			return nil, nil, fmt.Errorf("valid_from: %v", err)
+		}
+		from = &t
+	}
+	if loc.ValidTo != "" {
+		t, err := parseTimestamp(loc.ValidTo)
+		if err != nil {
+			return nil, nil, fmt.Errorf("valid_to: %v", err)
+		}
+		to = &t
 	}
+	if from != nil && to != nil && !to.After(*from) {
+		return nil, nil, fmt.Errorf("valid_to %s is not after valid_from %s", loc.ValidTo, loc.ValidFrom)
+	}
+	return from, to, nil
+}
 
-	var locations []Location
-	err = json.Unmarshal(data, &locations)
+// readLocations loads the stored locations, validates coordinates, and converts to ClientLocation
+// and ClientArea slices. Entries with a geometry are areas, all others are points.
+func readLocations() ([]ClientLocation, []ClientArea, error) {
+	locations, err := loadStoredLocations()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	locs, areas := convertLocations(locations)
+	return locs, areas, nil
+}
 
+// convertLocations turns stored entries into points and areas, skipping invalid ones.
+func convertLocations(locations []Location) ([]ClientLocation, []ClientArea) {
 	var clientLocations []ClientLocation
+	clientAreas := []ClientArea{}
 	for _, loc := range locations {
-		lat, lon, err := parseLocationString(loc.Location)
+		validFrom, validTo, err := parseValidity(loc)
 		if err != nil {
-			logger.Printf("Skipping invalid location: %v", err)
+			logger.Printf("Skipping location with invalid validity range: %v", err)
+			continue
+		}
+
+		if loc.Geometry != nil {
+			area, err := newClientArea(loc)
+			if err != nil {
+				logger.Printf("Skipping invalid area %q: %v", loc.Name, err)
+				continue
+			}
+			area.ValidFrom, area.ValidTo = validFrom, validTo
+			clientAreas = append(clientAreas, area)
 			continue
 		}
 
-		clientLocations = append(clientLocations, ClientLocation{
-			Lat:     lat,
-			Lon:     lon,
-			As:      loc.As,
-			Asname:  loc.Asname,
-			Details: loc.Details,
-		})
+		tags := loc.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+
+		cl := ClientLocation{
+			ID:        loc.ID,
+			As:        loc.As,
+			Asname:    loc.Asname,
+			Details:   loc.Details,
+			Country:   strings.ToUpper(loc.Country),
+			Tags:      tags,
+			Category:  loc.Category,
+			Extra:     loc.Extra,
+			ValidFrom: validFrom,
+			ValidTo:   validTo,
+			Prefix:    loc.Prefix,
+		}
+
+		if loc.Location == "" && loc.Prefix != "" {
+			// no coordinates given, derive them (and the origin AS) from the IP prefix
+			rec, err := resolvePrefix(loc.Prefix)
+			if err != nil {
+				logger.Printf("Skipping unresolvable prefix: %v", err)
+				continue
+			}
+			cl.Lat, cl.Lon = rec.Lat, rec.Lon
+			cl.Derived = true
+			cl.AccuracyKm = rec.AccuracyKm
+			if cl.As == "" && rec.ASN != 0 {
+				cl.As = fmt.Sprintf("AS%d", rec.ASN)
+			}
+			if cl.Asname == "" {
+				cl.Asname = rec.ASOrg
+			}
+			if cl.Country == "" {
+				cl.Country = rec.Country
+			}
+		} else {
+			lat, lon, err := locationPoint(loc)
+			if err != nil {
+				logger.Printf("Skipping invalid location: %v", err)
+				continue
+			}
+			cl.Lat, cl.Lon = lat, lon
+		}
+
+		clientLocations = append(clientLocations, cl)
 	}
 
-	return clientLocations, nil
+	return clientLocations, clientAreas
 }
 
 // proxyTiles proxies external tile requests (OSM, Google, Carto) through the configured proxy client.
@@ -352,29 +536,3 @@ func proxyTiles(w http.ResponseWriter, r *http.Request) {
 
 	logRequestDetails(r)
 }
-
-// proxyNominatim proxies a geocoding search query to the Nominatim API through the proxy client.
-func proxyNominatim(w http.ResponseWriter, r *http.Request) {
-	query := r.URL.Query().Get("q")
-	if query == "" {
-		http.Error(w, "Missing query parameter", http.StatusBadRequest)
-		return
-	}
-
-	nominatimURL := fmt.Sprintf("https://nominatim.openstreetmap.org/search?format=json&q=%s",
-		url.QueryEscape(query))
-
-	resp, err := ProxyClient.Get(nominatimURL)
-	if err != nil {
-		logger.Printf("Error fetching from Nominatim: %v", err)
-		http.Error(w, "Failed to search location", http.StatusInternalServerError)
-		return
-	}
-	defer resp.Body.Close()
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(resp.StatusCode)
-	io.Copy(w, resp.Body)
-
-	logRequestDetails(r)
-}
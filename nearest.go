@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/michalswi/osm/coords"
+	"github.com/michalswi/osm/geo"
+)
+
+// nearbyLocation is a known location with its distance and bearing from a query point.
+type nearbyLocation struct {
+	Location   ClientLocation `json:"location"`
+	DistanceKm float64        `json:"distance_km"`
+	Bearing    float64        `json:"bearing"`
+}
+
+// parsePointParams reads and validates the lat and lon query params.
+func parsePointParams(q url.Values) (geo.Point, error) {
+	lat, err := coords.ParseAxis(q.Get("lat"), true)
+	if err != nil {
+		return geo.Point{}, fmt.Errorf("invalid latitude: %s", q.Get("lat"))
+	}
+	lon, err := coords.ParseAxis(q.Get("lon"), false)
+	if err != nil {
+		return geo.Point{}, fmt.Errorf("invalid longitude: %s", q.Get("lon"))
+	}
+	return geo.Point{Lat: lat, Lon: lon}, nil
+}
+
+// locationsByDistance measures every location from p, using Vincenty when
+// method is "vincenty" and haversine otherwise, sorted nearest first.
+func locationsByDistance(locs []ClientLocation, p geo.Point, method string) []nearbyLocation {
+	out := make([]nearbyLocation, 0, len(locs))
+	for _, loc := range locs {
+		to := geo.Point{Lat: loc.Lat, Lon: loc.Lon}
+		var dist, bearing float64
+		if method == "vincenty" {
+			dist, bearing, _ = geo.Inverse(p, to)
+		} else {
+			dist, bearing = geo.Haversine(p, to), geo.Bearing(p, to)
+		}
+		out = append(out, nearbyLocation{Location: loc, DistanceKm: dist / 1000, Bearing: bearing})
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].DistanceKm < out[j].DistanceKm })
+	return out
+}
+
+// apiLocationsNearest returns the k known locations closest to lat/lon.
+func apiLocationsNearest(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	p, err := parsePointParams(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	k := 5
+	if q.Has("k") {
+		k, err = strconv.Atoi(q.Get("k"))
+		if err != nil || k < 1 {
+			http.Error(w, "invalid k", http.StatusBadRequest)
+			return
+		}
+	}
+
+	filter, err := parseLocationFilter(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	locs := filterLocations(getCachedLocations(), filter)
+	hits := locationsByDistance(locs, p, q.Get("method"))
+	if len(hits) > k {
+		hits = hits[:k]
+	}
+
+	writeJSON(w, hits)
+}
+
+// apiLocationsWithin returns the known locations within radius_km of lat/lon.
+func apiLocationsWithin(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	p, err := parsePointParams(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	radius, err := strconv.ParseFloat(q.Get("radius_km"), 64)
+	if err != nil || !(radius >= 0) || math.IsInf(radius, 1) { // also rejects NaN
+		http.Error(w, "invalid radius_km", http.StatusBadRequest)
+		return
+	}
+
+	filter, err := parseLocationFilter(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	locs := filterLocations(getCachedLocations(), filter)
+	hits := []nearbyLocation{}
+	for _, h := range locationsByDistance(locs, p, q.Get("method")) {
+		if h.DistanceKm > radius {
+			break
+		}
+		hits = append(hits, h)
+	}
+
+	writeJSON(w, hits)
+}
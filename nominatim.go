@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/michalswi/osm/httpcache"
+	"github.com/michalswi/osm/utils"
+)
+
+var (
+	nominatimURL      = strings.TrimSuffix(utils.GetEnv("NOMINATIM_URL", "https://nominatim.openstreetmap.org"), "/")
+	nominatimCache    *httpcache.Cache
+	nominatimCacheTTL time.Duration
+
+	// Nominatim usage policy: at most 1 request/s and an identifying User-Agent
+	nominatimLimiter   *limiter
+	nominatimUserAgent = utils.GetEnv("NOMINATIM_USER_AGENT", "osm/1.0 (+https://github.com/michalswi/osm)")
+	nominatimEmail     = os.Getenv("NOMINATIM_EMAIL")
+)
+
+// nominatimParams are the search params passed on upstream besides q.
+var nominatimParams = []string{"limit", "countrycodes", "viewbox", "bounded", "accept-language", "addressdetails"}
+
+// initGeocoder sets up the Nominatim response cache from NOMINATIM_CACHE_SIZE
+// (entries, default 1000), NOMINATIM_CACHE_TTL (default 24h, used when the
+// response has no max-age), NOMINATIM_CACHE_DIR (optional, on disk) and
+// NOMINATIM_CACHE_DISK_SIZE (files kept there, default 10000), and the
+// upstream rate limit from NOMINATIM_RATE (requests/s, default 1) and
+// NOMINATIM_QUEUE (requests allowed to wait for their turn, default 10).
+func initGeocoder() {
+	size, err := strconv.Atoi(utils.GetEnv("NOMINATIM_CACHE_SIZE", "1000"))
+	if err != nil {
+		logger.Fatalf("Invalid NOMINATIM_CACHE_SIZE: %v", err)
+	}
+	nominatimCacheTTL, err = time.ParseDuration(utils.GetEnv("NOMINATIM_CACHE_TTL", "24h"))
+	if err != nil {
+		logger.Fatalf("Invalid NOMINATIM_CACHE_TTL: %v", err)
+	}
+	dir := os.Getenv("NOMINATIM_CACHE_DIR")
+	diskSize, err := strconv.Atoi(utils.GetEnv("NOMINATIM_CACHE_DISK_SIZE", "10000"))
+	if err != nil {
+		logger.Fatalf("Invalid NOMINATIM_CACHE_DISK_SIZE: %v", err)
+	}
+	if nominatimCache, err = httpcache.New(size, dir, diskSize); err != nil {
+		logger.Fatalf("Nominatim cache setup failed: %v", err)
+	}
+	rate, err := strconv.ParseFloat(utils.GetEnv("NOMINATIM_RATE", "1"), 64)
+	if err != nil || rate <= 0 {
+		logger.Fatalf("Invalid NOMINATIM_RATE: %s", os.Getenv("NOMINATIM_RATE"))
+	}
+	queue, err := strconv.Atoi(utils.GetEnv("NOMINATIM_QUEUE", "10"))
+	if err != nil || queue < 0 {
+		logger.Fatalf("Invalid NOMINATIM_QUEUE: %s", os.Getenv("NOMINATIM_QUEUE"))
+	}
+	nominatimLimiter = newLimiter(rate, queue)
+	if nominatimEmail == "" {
+		logger.Println("NOMINATIM_EMAIL not set - set a contact address when using the public Nominatim")
+	}
+	logger.Printf("Geocoder: %s (cache %d entries, ttl %s, dir %q up to %d files, %g req/s, queue %d)", nominatimURL, size, nominatimCacheTTL, dir, diskSize, rate, queue)
+}
+
+// normalizeQuery lower-cases a free-text query and collapses its whitespace, so
+// "Wrocław  Rynek" and "wrocław rynek" share a cache entry.
+func normalizeQuery(q string) string {
+	return strings.Join(strings.Fields(strings.ToLower(q)), " ")
+}
+
+// fetchNominatim GETs endpoint ("search", ...) with params, answering from the
+// cache when possible. The cache key is the upstream URL, whose params are
+// sorted by url.Values.Encode. With refresh set the cache is not read.
+// Concurrent misses for the same key share one upstream call.
+func fetchNominatim(ctx context.Context, endpoint string, params url.Values, refresh bool) (e httpcache.Entry, hit bool, err error) {
+	params.Set("format", "json")
+	key := nominatimURL + "/" + endpoint + "?" + params.Encode()
+	if !refresh {
+		if e, ok := nominatimCache.Get(key); ok {
+			return e, true, nil
+		}
+	}
+
+	for {
+		nominatimFlightsMu.Lock()
+		f, ok := nominatimFlights[key]
+		if !ok {
+			f = &nominatimFlight{done: make(chan struct{})}
+			nominatimFlights[key] = f
+			nominatimFlightsMu.Unlock()
+
+			f.e, f.err = fetchUpstream(ctx, key)
+			nominatimFlightsMu.Lock()
+			delete(nominatimFlights, key)
+			nominatimFlightsMu.Unlock()
+			close(f.done)
+			return f.e, false, f.err
+		}
+		nominatimFlightsMu.Unlock()
+
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return e, false, ctx.Err()
+		}
+		// the request that went upstream was cancelled by its own client: try again
+		if (errors.Is(f.err, context.Canceled) || errors.Is(f.err, context.DeadlineExceeded)) && ctx.Err() == nil {
+			continue
+		}
+		return f.e, false, f.err
+	}
+}
+
+// nominatimFlight is an upstream call in progress, waited on by identical
+// requests that missed the cache meanwhile.
+type nominatimFlight struct {
+	done chan struct{}
+	e    httpcache.Entry
+	err  error
+}
+
+var (
+	nominatimFlightsMu sync.Mutex
+	nominatimFlights   = map[string]*nominatimFlight{}
+)
+
+// fetchUpstream GETs the Nominatim URL key and caches a successful response.
+// It waits for nominatimLimiter and fails with errQueueFull when too many
+// calls are already waiting.
+func fetchUpstream(ctx context.Context, key string) (e httpcache.Entry, err error) {
+	if err := nominatimLimiter.wait(ctx); err != nil {
+		return e, err
+	}
+	upstream := key
+	if nominatimEmail != "" {
+		upstream += "&email=" + url.QueryEscape(nominatimEmail)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", upstream, nil)
+	if err != nil {
+		return e, err
+	}
+	req.Header.Set("User-Agent", nominatimUserAgent)
+	if nominatimEmail != "" {
+		req.Header.Set("From", nominatimEmail)
+	}
+	resp, err := ProxyClient.Do(req)
+	if err != nil {
+		return e, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return e, err
+	}
+	e = httpcache.Entry{Key: key, Status: resp.StatusCode, ContentType: resp.Header.Get("Content-Type"), Body: body}
+	if ttl, ok := httpcache.TTL(resp.Header, nominatimCacheTTL); ok && resp.StatusCode == http.StatusOK {
+		e.Expires = time.Now().Add(ttl)
+		nominatimCache.Put(e)
+	}
+	return e, nil
+}
+
+// writeNominatim sends a (possibly cached) Nominatim response to the browser.
+func writeNominatim(w http.ResponseWriter, e httpcache.Entry, hit bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if hit {
+		w.Header().Set("X-Cache", "HIT")
+	} else {
+		w.Header().Set("X-Cache", "MISS")
+	}
+	if left := time.Until(e.Expires); left > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(left.Seconds())))
+	}
+	w.WriteHeader(e.Status)
+	w.Write(e.Body)
+}
+
+// refreshRequested reports whether a request may skip the cache: it asks for it
+// with "Cache-Control: no-cache" and carries the API_TOKEN bearer token, so
+// anonymous clients cannot push traffic past the cache to Nominatim.
+func refreshRequested(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Cache-Control"), "no-cache") && validToken(r)
+}
+
+// proxyNominatim proxies a geocoding search query to the Nominatim API through the
+// proxy client, with identical (normalised) queries answered from the cache.
+func proxyNominatim(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := normalizeQuery(q.Get("q"))
+	if query == "" {
+		http.Error(w, "Missing query parameter", http.StatusBadRequest)
+		return
+	}
+
+	params := url.Values{"q": {query}}
+	for _, name := range nominatimParams {
+		if v := strings.TrimSpace(q.Get(name)); v != "" {
+			params.Set(name, strings.ToLower(v))
+		}
+	}
+	refresh := refreshRequested(r)
+
+	e, hit, err := fetchNominatim(r.Context(), "search", params, refresh)
+	if err == errQueueFull {
+		w.Header().Set("Retry-After", strconv.Itoa(nominatimLimiter.retryAfter()))
+		http.Error(w, "Geocoder busy, try again later", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		logger.Printf("Error fetching from Nominatim: %v", err)
+		http.Error(w, "Failed to search location", http.StatusInternalServerError)
+		return
+	}
+	writeNominatim(w, e, hit)
+
+	logRequestDetails(r)
+}
+
+// proxyReverse resolves ?lat=&lon= to an address through Nominatim's reverse
+// endpoint, sharing the cache and rate limit of the search. The position is
+// rounded to 5 decimals (about 1 m) so nearby clicks share a cache entry;
+// ?zoom= (0-18, default 18) picks the detail level, from country to building.
+func proxyReverse(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	p, err := parsePointParams(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	zoom := 18
+	if s := q.Get("zoom"); s != "" {
+		if zoom, err = strconv.Atoi(s); err != nil || zoom < 0 || zoom > 18 {
+			http.Error(w, "invalid zoom (want 0-18)", http.StatusBadRequest)
+			return
+		}
+	}
+
+	params := url.Values{
+		"lat":  {strconv.FormatFloat(p.Lat, 'f', 5, 64)},
+		"lon":  {strconv.FormatFloat(p.Lon, 'f', 5, 64)},
+		"zoom": {strconv.Itoa(zoom)},
+	}
+	if v := strings.TrimSpace(q.Get("accept-language")); v != "" {
+		params.Set("accept-language", strings.ToLower(v))
+	}
+	refresh := refreshRequested(r)
+
+	e, hit, err := fetchNominatim(r.Context(), "reverse", params, refresh)
+	if err == errQueueFull {
+		w.Header().Set("Retry-After", strconv.Itoa(nominatimLimiter.retryAfter()))
+		http.Error(w, "Geocoder busy, try again later", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		logger.Printf("Error reverse geocoding with Nominatim: %v", err)
+		http.Error(w, "Failed to resolve address", http.StatusInternalServerError)
+		return
+	}
+	writeNominatim(w, e, hit)
+
+	logRequestDetails(r)
+}
+
+// apiGeocoderStats returns the Nominatim cache and rate limiter counters.
+func apiGeocoderStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, struct {
+		Cache   httpcache.Stats `json:"cache"`
+		Limiter limiterStats    `json:"limiter"`
+	}{nominatimCache.Stats(), nominatimLimiter.stats()})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/michalswi/osm/httpcache"
+)
+
+func TestFetchNominatimSharesMisses(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"display_name":"Wrocław"}]`))
+	}))
+	defer upstream.Close()
+
+	oldURL, oldCache, oldLimiter, oldClient := nominatimURL, nominatimCache, nominatimLimiter, ProxyClient
+	defer func() {
+		nominatimURL, nominatimCache, nominatimLimiter, ProxyClient = oldURL, oldCache, oldLimiter, oldClient
+	}()
+	nominatimURL, nominatimCacheTTL, ProxyClient = upstream.URL, time.Hour, upstream.Client()
+	nominatimLimiter = newLimiter(1, 10)
+	var err error
+	if nominatimCache, err = httpcache.New(10, "", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 5
+	var wg sync.WaitGroup
+	results := make([]httpcache.Entry, n)
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _, errs[i] = fetchNominatim(context.Background(), "search", url.Values{"q": {"wrocław"}}, false)
+		}(i)
+	}
+	// let every request reach the flight before the upstream answers
+	for deadline := time.Now().Add(2 * time.Second); calls.Load() == 0 && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("%d upstream calls, want 1", got)
+	}
+	for i := range results {
+		if errs[i] != nil || string(results[i].Body) != `[{"display_name":"Wrocław"}]` {
+			t.Errorf("request %d = %q, %v", i, results[i].Body, errs[i])
+		}
+	}
+	if _, hit, err := fetchNominatim(context.Background(), "search", url.Values{"q": {"wrocław"}}, false); !hit || err != nil {
+		t.Errorf("repeated query hit = %v, %v, want a cache hit", hit, err)
+	}
+}
@@ -5,11 +5,13 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
-	"strconv"
+	"strings"
 	"time"
+
+	"github.com/michalswi/osm/coords"
 )
 
-// getCachedLocations returns cached locations if TTL not expired, otherwise reloads from disk.
+// getCachedLocations returns cached locations if TTL not expired, otherwise reloads from the store.
 func getCachedLocations() []ClientLocation {
 	locationsCacheMu.RLock()
 	fresh := time.Since(locationsCacheStamp) < locationsCacheTTL
@@ -19,19 +21,73 @@ func getCachedLocations() []ClientLocation {
 	}
 	locationsCacheMu.RUnlock()
 
-	locs, err := readLocations()
+	locs, areas, err := readLocations()
 	if err != nil {
 		logger.Printf("Failed to read locations: %v", err)
 		locs = []ClientLocation{}
+		areas = []ClientArea{}
+	}
+
+	links, err := readLinks()
+	if err != nil {
+		logger.Printf("Failed to read links: %v", err)
+		links = []Link{}
 	}
 
+	issues := enrichLocations(locs)
+	reverseGeocode(locs)
+	index := newSearchIndex(locs)
+
 	locationsCacheMu.Lock()
 	locationsCache = locs
+	areasCache = areas
+	linksCache = links
+	locationIssues = issues
+	locationsIndex = index
 	locationsCacheStamp = time.Now()
 	locationsCacheMu.Unlock()
 	return locs
 }
 
+// invalidateLocationsCache makes the next getCachedLocations reload from the store.
+func invalidateLocationsCache() {
+	locationsCacheMu.Lock()
+	locationsCacheStamp = time.Time{}
+	locationsCacheMu.Unlock()
+}
+
+// getCachedAreas returns the areas loaded together with the cached locations.
+func getCachedAreas() []ClientArea {
+	getCachedLocations()
+	locationsCacheMu.RLock()
+	defer locationsCacheMu.RUnlock()
+	return areasCache
+}
+
+// getCachedLinks returns the links loaded together with the cached locations.
+func getCachedLinks() []Link {
+	getCachedLocations()
+	locationsCacheMu.RLock()
+	defer locationsCacheMu.RUnlock()
+	return linksCache
+}
+
+// getLocationIssues returns the validation issues found when the cached locations were loaded.
+func getLocationIssues() []validationIssue {
+	getCachedLocations()
+	locationsCacheMu.RLock()
+	defer locationsCacheMu.RUnlock()
+	return locationIssues
+}
+
+// getLocationIndex returns the search index built alongside the cached locations.
+func getLocationIndex() *searchIndex {
+	getCachedLocations()
+	locationsCacheMu.RLock()
+	defer locationsCacheMu.RUnlock()
+	return locationsIndex
+}
+
 // oms renders the main page (proxy or normal template) with coordinates and location markers.
 func oms(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
@@ -40,8 +96,13 @@ func oms(w http.ResponseWriter, r *http.Request) {
 	lat := "51.109970"
 	lon := "17.031984"
 
-	// read locations from file
-	locations := getCachedLocations()
+	// read locations from file, keeping only the ones matching the shared filter
+	allLocations := getCachedLocations()
+	filter, err := parseLocationFilter(r.URL.Query())
+	if err != nil {
+		logger.Println("Invalid filter:", err)
+	}
+	locations := filterLocations(allLocations, filter)
 
 	locationsJSON, err := json.Marshal(locations)
 	if err != nil {
@@ -50,29 +111,60 @@ func oms(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if r.URL.Query().Has("lat") && r.URL.Query().Has("lon") {
+	tags, categories, countries := locationFacets(allLocations)
+	filterJSON, err := json.Marshal(struct {
+		Active     locationFilter `json:"active"`
+		Tags       []string       `json:"tags"`
+		Categories []string       `json:"categories"`
+		Countries  []countryFacet `json:"countries"`
+		TimeRange  timeRange      `json:"time_range"`
+	}{filter, tags, categories, countries, locationsTimeRange(allLocations)})
+	if err != nil {
+		logger.Printf("Failed to marshal filter: %v", err)
+		http.Error(w, "Failed to marshal filter", http.StatusInternalServerError)
+		return
+	}
+
+	if q := r.URL.Query().Get("coord"); q != "" {
+		if c, err := coords.Parse(q); err == nil {
+			lat, lon = fmt.Sprintf("%f", c.Lat), fmt.Sprintf("%f", c.Lon)
+		} else {
+			logger.Println("Invalid coordinate:", err)
+		}
+	} else if r.URL.Query().Has("lat") && r.URL.Query().Has("lon") {
 		latParam := r.URL.Query().Get("lat")
 		lonParam := r.URL.Query().Get("lon")
-		if parsedLat, err := strconv.ParseFloat(latParam, 64); err == nil {
+		if parsedLat, err := coords.ParseAxis(latParam, true); err == nil {
 			lat = fmt.Sprintf("%f", parsedLat)
 		} else {
 			logger.Println("Invalid latitude value:", latParam)
 		}
-		if parsedLon, err := strconv.ParseFloat(lonParam, 64); err == nil {
+		if parsedLon, err := coords.ParseAxis(lonParam, false); err == nil {
 			lon = fmt.Sprintf("%f", parsedLon)
 		} else {
 			logger.Println("Invalid longitude value:", lonParam)
 		}
 	}
 
+	// human-readable name of the shared position, shown in its popup
+	label := []rune(strings.TrimSpace(r.URL.Query().Get("label")))
+	if len(label) > 200 {
+		label = label[:200]
+	}
+	labelJSON, _ := json.Marshal(string(label))
+
 	data := struct {
 		Lat           string
 		Lon           string
+		LabelJSON     template.JS
 		LocationsJSON template.JS
+		FilterJSON    template.JS
 	}{
 		Lat:           lat,
 		Lon:           lon,
+		LabelJSON:     template.JS(labelJSON),
 		LocationsJSON: template.JS(locationsJSON),
+		FilterJSON:    template.JS(filterJSON),
 	}
 
 	if proxyEnabled {
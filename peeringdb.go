@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/michalswi/osm/geo"
+)
+
+// PeeringDB JSON export, as written by the PeeringDB API / peeringdb-py dumps:
+// every object type is a {"data": [...]} list.
+type pdbDump struct {
+	Fac      struct{ Data []pdbFac }      `json:"fac"`
+	Ix       struct{ Data []pdbIx }       `json:"ix"`
+	Net      struct{ Data []pdbNet }      `json:"net"`
+	NetFac   struct{ Data []pdbNetFac }   `json:"netfac"`
+	IxFac    struct{ Data []pdbIxFac }    `json:"ixfac"`
+	NetIxLan struct{ Data []pdbNetIxLan } `json:"netixlan"`
+}
+
+type pdbFac struct {
+	ID        int      `json:"id"`
+	Name      string   `json:"name"`
+	City      string   `json:"city"`
+	Country   string   `json:"country"`
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+}
+
+type pdbIx struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	City    string `json:"city"`
+	Country string `json:"country"`
+}
+
+type pdbNet struct {
+	ID   int    `json:"id"`
+	ASN  uint32 `json:"asn"`
+	Name string `json:"name"`
+}
+
+type pdbNetFac struct {
+	NetID int `json:"net_id"`
+	FacID int `json:"fac_id"`
+}
+
+type pdbIxFac struct {
+	IxID  int `json:"ix_id"`
+	FacID int `json:"fac_id"`
+}
+
+type pdbNetIxLan struct {
+	NetID int    `json:"net_id"`
+	IxID  int    `json:"ix_id"`
+	ASN   uint32 `json:"asn"`
+}
+
+// pdbNetwork is a network present at a facility or IXP.
+type pdbNetwork struct {
+	ASN  uint32 `json:"asn"`
+	Name string `json:"name"`
+}
+
+// pdbFacility is a facility layer entry with the networks and IXPs present there.
+type pdbFacility struct {
+	ID       int          `json:"id"`
+	Name     string       `json:"name"`
+	City     string       `json:"city"`
+	Country  string       `json:"country"`
+	Lat      float64      `json:"lat"`
+	Lon      float64      `json:"lon"`
+	IXPs     []string     `json:"ixps"`
+	Networks []pdbNetwork `json:"networks"`
+	OurASNs  []string     `json:"our_asns"`
+}
+
+// pdbIXP is an IXP layer entry, placed at the centroid of its facilities.
+type pdbIXP struct {
+	ID         int          `json:"id"`
+	Name       string       `json:"name"`
+	City       string       `json:"city"`
+	Country    string       `json:"country"`
+	Lat        float64      `json:"lat"`
+	Lon        float64      `json:"lon"`
+	Facilities []string     `json:"facilities"`
+	Networks   []pdbNetwork `json:"networks"`
+	OurASNs    []string     `json:"our_asns"`
+}
+
+// peeringDBLayers holds the layers built from the dump, rebuilt when the file changes.
+type peeringDBLayers struct {
+	mu         sync.Mutex
+	path       string
+	modTime    time.Time
+	facilities []pdbFacility
+	ixps       []pdbIXP
+}
+
+// get returns the facility and IXP layers, reloading the dump if it changed on disk.
+func (p *peeringDBLayers) get() ([]pdbFacility, []pdbIXP, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.path == "" {
+		return nil, nil, fmt.Errorf("no PeeringDB dump configured (PEERINGDB_DUMP)")
+	}
+	fi, err := os.Stat(p.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.facilities != nil && fi.ModTime().Equal(p.modTime) {
+		return p.facilities, p.ixps, nil
+	}
+
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var dump pdbDump
+	if err := json.Unmarshal(data, &dump); err != nil {
+		return nil, nil, fmt.Errorf("invalid PeeringDB dump: %v", err)
+	}
+	p.facilities, p.ixps = buildPeeringDBLayers(dump)
+	p.modTime = fi.ModTime()
+	logger.Printf("Loaded PeeringDB dump: %d facilities, %d IXPs", len(p.facilities), len(p.ixps))
+	return p.facilities, p.ixps, nil
+}
+
+// buildPeeringDBLayers joins facilities, IXPs and networks of the dump.
+func buildPeeringDBLayers(dump pdbDump) ([]pdbFacility, []pdbIXP) {
+	nets := map[int]pdbNet{}
+	for _, n := range dump.Net.Data {
+		nets[n.ID] = n
+	}
+
+	facNets := map[int][]pdbNetwork{}
+	for _, nf := range dump.NetFac.Data {
+		if n, ok := nets[nf.NetID]; ok {
+			facNets[nf.FacID] = append(facNets[nf.FacID], pdbNetwork{ASN: n.ASN, Name: n.Name})
+		}
+	}
+
+	ixNets := map[int][]pdbNetwork{}
+	seenIxNet := map[[2]int]bool{}
+	for _, nl := range dump.NetIxLan.Data {
+		if seenIxNet[[2]int{nl.IxID, nl.NetID}] {
+			continue // one entry per network, not per peering LAN address
+		}
+		seenIxNet[[2]int{nl.IxID, nl.NetID}] = true
+		n := pdbNetwork{ASN: nl.ASN}
+		if net, ok := nets[nl.NetID]; ok {
+			n = pdbNetwork{ASN: net.ASN, Name: net.Name}
+		}
+		ixNets[nl.IxID] = append(ixNets[nl.IxID], n)
+	}
+
+	ixNames := map[int]string{}
+	for _, ix := range dump.Ix.Data {
+		ixNames[ix.ID] = ix.Name
+	}
+	facByID := map[int]pdbFac{}
+	for _, f := range dump.Fac.Data {
+		facByID[f.ID] = f
+	}
+	facIXPs := map[int][]string{}
+	ixFacs := map[int][]pdbFac{}
+	for _, ixf := range dump.IxFac.Data {
+		facIXPs[ixf.FacID] = append(facIXPs[ixf.FacID], ixNames[ixf.IxID])
+		if f, ok := facByID[ixf.FacID]; ok {
+			ixFacs[ixf.IxID] = append(ixFacs[ixf.IxID], f)
+		}
+	}
+
+	facilities := []pdbFacility{}
+	for _, f := range dump.Fac.Data {
+		if f.Latitude == nil || f.Longitude == nil {
+			continue
+		}
+		facilities = append(facilities, pdbFacility{
+			ID: f.ID, Name: f.Name, City: f.City, Country: f.Country,
+			Lat: *f.Latitude, Lon: *f.Longitude,
+			IXPs:     nonNil(facIXPs[f.ID]),
+			Networks: sortedNetworks(facNets[f.ID]),
+		})
+	}
+
+	ixps := []pdbIXP{}
+	for _, ix := range dump.Ix.Data {
+		var lat, lon float64
+		var n int
+		names := []string{}
+		for _, f := range ixFacs[ix.ID] {
+			names = append(names, f.Name)
+			if f.Latitude != nil && f.Longitude != nil {
+				lat += *f.Latitude
+				lon += *f.Longitude
+				n++
+			}
+		}
+		if n == 0 {
+			continue // cannot be placed on the map
+		}
+		ixps = append(ixps, pdbIXP{
+			ID: ix.ID, Name: ix.Name, City: ix.City, Country: ix.Country,
+			Lat: lat / float64(n), Lon: lon / float64(n),
+			Facilities: names,
+			Networks:   sortedNetworks(ixNets[ix.ID]),
+		})
+	}
+	return facilities, ixps
+}
+
+func sortedNetworks(n []pdbNetwork) []pdbNetwork {
+	if n == nil {
+		return []pdbNetwork{}
+	}
+	sort.Slice(n, func(i, j int) bool { return n[i].ASN < n[j].ASN })
+	return n
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+// ourASNs returns the AS numbers used by the known locations.
+func ourASNs() map[uint32]bool {
+	out := map[uint32]bool{}
+	for _, loc := range getCachedLocations() {
+		if asn, ok := parseASN(loc.As); ok {
+			out[asn] = true
+		}
+	}
+	return out
+}
+
+// sharedASNs lists which of nets belong to our ASNs.
+func sharedASNs(nets []pdbNetwork, ours map[uint32]bool) []string {
+	out := []string{}
+	for _, n := range nets {
+		if ours[n.ASN] {
+			out = append(out, fmt.Sprintf("AS%d", n.ASN))
+		}
+	}
+	return out
+}
+
+// pdbQuery holds the common query params of the PeeringDB endpoints: bbox limits
+// the area, min_ours keeps entries where at least that many of our ASNs are present.
+type pdbQuery struct {
+	bbox    bool
+	sw, ne  geo.Point
+	minOurs int
+}
+
+func parsePDBQuery(r *http.Request) (pdbQuery, error) {
+	var q pdbQuery
+	if b := r.URL.Query().Get("bbox"); b != "" {
+		sw, ne, err := parseBBox(b)
+		if err != nil {
+			return q, err
+		}
+		q.bbox, q.sw, q.ne = true, sw, ne
+	}
+	if m := r.URL.Query().Get("min_ours"); m != "" {
+		n, err := strconv.Atoi(m)
+		if err != nil || n < 0 {
+			return q, fmt.Errorf("invalid min_ours: %s", m)
+		}
+		q.minOurs = n
+	}
+	return q, nil
+}
+
+func (q pdbQuery) inBBox(lat, lon float64) bool {
+	return !q.bbox || inBBox(geo.Point{Lat: lat, Lon: lon}, q.sw, q.ne)
+}
+
+// apiPeeringDBFacilities returns the facilities of the PeeringDB dump joined with our ASNs.
+func apiPeeringDBFacilities(w http.ResponseWriter, r *http.Request) {
+	q, err := parsePDBQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	facilities, _, err := peeringDB.get()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	ours := ourASNs()
+	out := []pdbFacility{}
+	for _, f := range facilities {
+		if !q.inBBox(f.Lat, f.Lon) {
+			continue
+		}
+		f.OurASNs = sharedASNs(f.Networks, ours)
+		if len(f.OurASNs) < q.minOurs {
+			continue
+		}
+		out = append(out, f)
+	}
+	writeJSON(w, out)
+}
+
+// apiPeeringDBIXPs returns the IXPs of the PeeringDB dump joined with our ASNs.
+func apiPeeringDBIXPs(w http.ResponseWriter, r *http.Request) {
+	q, err := parsePDBQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_, ixps, err := peeringDB.get()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	ours := ourASNs()
+	out := []pdbIXP{}
+	for _, ix := range ixps {
+		if !q.inBBox(ix.Lat, ix.Lon) {
+			continue
+		}
+		ix.OurASNs = sharedASNs(ix.Networks, ours)
+		if len(ix.OurASNs) < q.minOurs {
+			continue
+		}
+		out = append(out, ix)
+	}
+	writeJSON(w, out)
+}
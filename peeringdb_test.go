@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPDBQueryBBox(t *testing.T) {
+	tests := []struct {
+		bbox     string
+		lat, lon float64
+		want     bool
+	}{
+		{"40,10,55,30", 51.1, 17.03, true},
+		{"40,10,55,30", 51.1, 31, false},
+		{"40,10,55,30", 56, 17.03, false},
+		// across the antimeridian: west of 170 or east of -170
+		{"-50,170,-30,-170", -41.3, 174.8, true},
+		{"-50,170,-30,-170", -41.3, -175, true},
+		{"-50,170,-30,-170", -41.3, 0, false},
+	}
+	for _, tt := range tests {
+		q, err := parsePDBQuery(httptest.NewRequest("GET", "/api/peeringdb/ixps?bbox="+tt.bbox, nil))
+		if err != nil {
+			t.Fatalf("bbox %s: %v", tt.bbox, err)
+		}
+		if got := q.inBBox(tt.lat, tt.lon); got != tt.want {
+			t.Errorf("bbox %s: inBBox(%v, %v) = %v, want %v", tt.bbox, tt.lat, tt.lon, got, tt.want)
+		}
+	}
+	if _, err := parsePDBQuery(httptest.NewRequest("GET", "/api/peeringdb/ixps?bbox=40,190,55,250", nil)); err == nil {
+		t.Error("longitudes past 180 accepted")
+	}
+}
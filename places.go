@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/michalswi/osm/geo"
+	"github.com/michalswi/osm/revgeo"
+)
+
+// place is where a location lies, resolved offline from the country and region
+// polygons and the nearest GeoNames city.
+type place struct {
+	Country     string  `json:"country,omitempty"`
+	CountryName string  `json:"country_name,omitempty"`
+	Region      string  `json:"region,omitempty"`
+	City        string  `json:"city,omitempty"`
+	CityKm      float64 `json:"city_km,omitempty"`
+}
+
+// placeNames is the GeoNames nearest-city index (GEONAMES_CITIES, with region
+// names from GEONAMES_ADMIN1), reloaded when the cities file changes on disk.
+type placeNames struct {
+	mu         sync.Mutex
+	citiesPath string
+	admin1Path string
+	modTime    time.Time
+	index      *revgeo.Index
+}
+
+// get returns the city index; nil when no cities file is configured.
+func (p *placeNames) get() (*revgeo.Index, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.citiesPath == "" {
+		return nil, nil
+	}
+	fi, err := os.Stat(p.citiesPath)
+	if err != nil {
+		return nil, err
+	}
+	if p.index != nil && fi.ModTime().Equal(p.modTime) {
+		return p.index, nil
+	}
+	index, err := revgeo.Load(p.citiesPath, p.admin1Path)
+	if err != nil {
+		return nil, err
+	}
+	p.index = index
+	p.modTime = fi.ModTime()
+	logger.Printf("Loaded %d cities from %s", index.Len(), p.citiesPath)
+	return index, nil
+}
+
+// reverseGeocode sets the place of every location from whatever offline data
+// is configured: COUNTRY_POLYGONS, REGION_POLYGONS and GEONAMES_CITIES.
+func reverseGeocode(locs []ClientLocation) {
+	countries, err := countryPolygons.get()
+	if err != nil {
+		logger.Printf("Failed to load country polygons: %v", err)
+	}
+	regions, err := regionPolygons.get()
+	if err != nil {
+		logger.Printf("Failed to load region polygons: %v", err)
+	}
+	cities, err := cityNames.get()
+	if err != nil {
+		logger.Printf("Failed to load cities: %v", err)
+	}
+	if countries == nil && regions == nil && cities == nil {
+		return
+	}
+
+	for i := range locs {
+		loc := &locs[i]
+		p := geo.Point{Lat: loc.Lat, Lon: loc.Lon}
+		pl := &place{}
+		var city revgeo.City
+		var found bool
+		if cities != nil {
+			var dist float64
+			if city, dist, found = cities.Nearest(loc.Lat, loc.Lon); found {
+				pl.City, pl.CityKm = city.Name, float64(int(dist/100))/10
+			}
+		}
+		if c := countryAt(countries, p); c != nil {
+			pl.Country, pl.CountryName = c.Code, c.Name
+		} else if found {
+			pl.Country = city.Country
+		}
+		if r := countryAt(regions, p); r != nil {
+			pl.Region = r.Name
+		} else if found && city.Country == pl.Country {
+			pl.Region = city.Admin1
+		}
+		if pl.CountryName == "" {
+			pl.CountryName = countryName(countries, pl.Country)
+		}
+		if *pl != (place{}) {
+			loc.Place = pl
+		}
+	}
+}
+
+// countryName returns the name of a country code from the polygons, or the code.
+func countryName(countries []country, code string) string {
+	for _, c := range countries {
+		if c.Code == code && c.Name != "" {
+			return c.Name
+		}
+	}
+	return code
+}
+
+// placeCountry is the country a location lies in, falling back to its declared
+// (or ASN registry) country without offline place data.
+func (loc ClientLocation) placeCountry() string {
+	if loc.Place != nil && loc.Place.Country != "" {
+		return loc.Place.Country
+	}
+	return strings.ToUpper(loc.Country)
+}
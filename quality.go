@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/michalswi/osm/geo"
+)
+
+// qualityIssues runs the data-quality checks over the loaded locations:
+// null-island points, probable lat/lon swaps (with COUNTRY_POLYGONS), and
+// exact or near (within radiusM metres) duplicates of the same AS. Locations
+// derived from an IP prefix are skipped, their position is not typed by hand.
+func qualityIssues(locs []ClientLocation, radiusM float64) []validationIssue {
+	issues := []validationIssue{}
+	countries, err := countryPolygons.get()
+	if err != nil {
+		logger.Printf("Failed to load country polygons: %v", err)
+	}
+
+	var checked []ClientLocation
+	for _, loc := range locs {
+		if loc.Derived {
+			continue
+		}
+		if math.Abs(loc.Lat) < 0.001 && math.Abs(loc.Lon) < 0.001 {
+			issues = append(issues, validationIssue{Kind: "null_island", Message: "position is 0,0 (missing coordinates?)", Location: loc})
+			continue
+		}
+		if countries != nil {
+			if issue, ok := swapIssue(countries, loc); ok {
+				issues = append(issues, issue)
+			}
+		}
+		checked = append(checked, loc)
+	}
+	return append(issues, duplicateIssues(checked, radiusM)...)
+}
+
+// swapIssue reports a location that lies in the declared (or ASN) country only
+// when read as lon,lat, or that is in no country while the swap is. A position
+// in another country than the entry declares is reported as
+// country_position_mismatch; the ASN registry country is not enough for that,
+// networks have PoPs outside their home country.
+func swapIssue(countries []country, loc ClientLocation) (validationIssue, bool) {
+	p := geo.Point{Lat: loc.Lat, Lon: loc.Lon}
+	here := countryAt(countries, p)
+	var there *country
+	if math.Abs(loc.Lon) <= 90 && math.Abs(loc.Lat-loc.Lon) > 0.01 {
+		there = countryAt(countries, geo.Point{Lat: loc.Lon, Lon: loc.Lat})
+	}
+	want := strings.ToUpper(loc.Country)
+	name := func(c *country) string {
+		if c == nil {
+			return "no country"
+		}
+		return c.Code
+	}
+
+	swapped := validationIssue{Kind: "swapped_lat_lon", Location: loc}
+	switch {
+	case want != "" && here != nil && here.Code == want:
+		return validationIssue{}, false
+	case want != "" && there != nil && there.Code == want:
+		swapped.Message = fmt.Sprintf("position lies in %s, not %s; swapped to %.4f,%.4f it lies in %s", name(here), want, loc.Lon, loc.Lat, want)
+		return swapped, true
+	case want == "" && here == nil && there != nil:
+		swapped.Message = fmt.Sprintf("position lies in no country; swapped to %.4f,%.4f it lies in %s", loc.Lon, loc.Lat, there.Code)
+		return swapped, true
+	case want != "" && here != nil && !loc.registryCountry:
+		return validationIssue{Kind: "country_position_mismatch", Message: fmt.Sprintf("position lies in %s, not %s", here.Code, want), Location: loc}, true
+	}
+	return validationIssue{}, false
+}
+
+// duplicateIssues finds locations of the same AS at the same position
+// (duplicate) or within radiusM metres of each other (near_duplicate).
+// Different networks sharing a facility are expected and not reported.
+func duplicateIssues(locs []ClientLocation, radiusM float64) []validationIssue {
+	groups := map[string][]ClientLocation{}
+	for _, loc := range locs {
+		key := strings.ToLower(loc.As)
+		if asn, ok := parseASN(loc.As); ok {
+			key = fmt.Sprint(asn)
+		}
+		groups[key] = append(groups[key], loc)
+	}
+	keys := make([]string, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	issues := []validationIssue{}
+	maxLat := radiusM / 111000 // latitude degrees covering radiusM, with margin
+	for _, k := range keys {
+		g := groups[k]
+		sort.SliceStable(g, func(i, j int) bool { return g[i].Lat < g[j].Lat })
+		for i := range g {
+			for j := i + 1; j < len(g) && g[j].Lat-g[i].Lat <= maxLat; j++ {
+				d := geo.Haversine(geo.Point{Lat: g[i].Lat, Lon: g[i].Lon}, geo.Point{Lat: g[j].Lat, Lon: g[j].Lon})
+				related := g[i]
+				switch {
+				case d < 0.01:
+					issues = append(issues, validationIssue{Kind: "duplicate", Message: "same AS at the same position", Location: g[j], Related: &related})
+				case d <= radiusM:
+					issues = append(issues, validationIssue{Kind: "near_duplicate", Message: fmt.Sprintf("same AS %.0f m away", d), Location: g[j], Related: &related})
+				}
+			}
+		}
+	}
+	return issues
+}
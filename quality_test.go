@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/michalswi/osm/geo"
+)
+
+// box is a test country covering a lat/lon rectangle.
+func box(code string, south, west, north, east float64) country {
+	ring := geo.Ring{{Lat: south, Lon: west}, {Lat: south, Lon: east}, {Lat: north, Lon: east}, {Lat: north, Lon: west}}
+	return country{Code: code, shape: geo.MultiPolygon{{ring}}, sw: geo.Point{Lat: south, Lon: west}, ne: geo.Point{Lat: north, Lon: east}}
+}
+
+func TestSwapIssue(t *testing.T) {
+	countries := []country{
+		box("PL", 49, 14, 55, 24),
+		box("DE", 47, 6, 55, 14),
+		box("XX", 10, 50, 30, 60), // where Polish positions land when swapped
+	}
+	tests := []struct {
+		name string
+		loc  ClientLocation
+		kind string // "" for no issue
+	}{
+		{"in the declared country", ClientLocation{Lat: 51.1, Lon: 17.03, Country: "PL"}, ""},
+		{"declared elsewhere", ClientLocation{Lat: 52.5, Lon: 13.4, Country: "PL"}, "country_position_mismatch"},
+		{"registry country elsewhere", ClientLocation{Lat: 52.5, Lon: 13.4, Country: "PL", registryCountry: true}, ""},
+		{"swapped, declared", ClientLocation{Lat: 17.03, Lon: 51.1, Country: "PL"}, "swapped_lat_lon"},
+		{"swapped, registry country", ClientLocation{Lat: 17.03, Lon: 51.1, Country: "PL", registryCountry: true}, "swapped_lat_lon"},
+		{"in a country, none declared", ClientLocation{Lat: 20, Lon: 52}, ""},
+		{"in no country", ClientLocation{Lat: 0.5, Lon: 0.5}, ""},
+	}
+	for _, tt := range tests {
+		issue, ok := swapIssue(countries, tt.loc)
+		if ok != (tt.kind != "") || issue.Kind != tt.kind {
+			t.Errorf("%s: swapIssue = %q, %v, want %q", tt.name, issue.Kind, ok, tt.kind)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+// Package revgeo finds the nearest populated place of a position offline, from
+// a GeoNames cities dump (cities500.txt, cities1000.txt, cities15000.txt...)
+// and optionally its admin1CodesASCII.txt for region names.
+package revgeo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const earthRadius = 6371008.8
+
+// City is one populated place of the dump.
+type City struct {
+	Name       string  `json:"name"`
+	Country    string  `json:"country"`          // ISO 3166-1 alpha-2
+	Admin1     string  `json:"admin1,omitempty"` // region name, or its code without admin1 names
+	Lat        float64 `json:"lat"`
+	Lon        float64 `json:"lon"`
+	Population int     `json:"population,omitempty"`
+}
+
+// Index is an in-memory nearest-neighbour index of cities (a k-d tree over
+// unit vectors, so distances are exact great-circle orderings at any latitude).
+type Index struct {
+	cities []City
+	nodes  []node
+}
+
+type node struct {
+	p           [3]float64
+	city        int
+	left, right int // -1: none
+	axis        int
+}
+
+// Load reads a GeoNames cities file and, when admin1Path is not empty, the
+// admin1 codes file to name the regions.
+func Load(citiesPath, admin1Path string) (*Index, error) {
+	var admin1 map[string]string
+	if admin1Path != "" {
+		f, err := os.Open(admin1Path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if admin1, err = readAdmin1(f); err != nil {
+			return nil, fmt.Errorf("%s: %v", admin1Path, err)
+		}
+	}
+
+	f, err := os.Open(citiesPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cities, err := readCities(f, admin1)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", citiesPath, err)
+	}
+	return NewIndex(cities), nil
+}
+
+// readAdmin1 parses "PL.72<TAB>Lower Silesia<TAB>Lower Silesia<TAB>3337492" lines.
+func readAdmin1(r io.Reader) (map[string]string, error) {
+	names := map[string]string{}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		f := strings.Split(sc.Text(), "\t")
+		if len(f) < 2 || strings.HasPrefix(f[0], "#") {
+			continue
+		}
+		names[f[0]] = f[1]
+	}
+	return names, sc.Err()
+}
+
+// readCities parses the tab separated GeoNames "geoname" table: name (1),
+// latitude (4), longitude (5), country code (8), admin1 code (10), population (14).
+func readCities(r io.Reader, admin1 map[string]string) ([]City, error) {
+	var cities []City
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024) // alternate names can be long
+	for line := 1; sc.Scan(); line++ {
+		f := strings.Split(sc.Text(), "\t")
+		if len(f) < 15 {
+			continue
+		}
+		lat, err1 := strconv.ParseFloat(f[4], 64)
+		lon, err2 := strconv.ParseFloat(f[5], 64)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("line %d: invalid coordinates", line)
+		}
+		c := City{Name: f[1], Country: f[8], Admin1: f[10], Lat: lat, Lon: lon}
+		c.Population, _ = strconv.Atoi(f[14])
+		if name, ok := admin1[f[8]+"."+f[10]]; ok {
+			c.Admin1 = name
+		}
+		cities = append(cities, c)
+	}
+	return cities, sc.Err()
+}
+
+// NewIndex builds an index over cities.
+func NewIndex(cities []City) *Index {
+	ix := &Index{cities: cities, nodes: make([]node, 0, len(cities))}
+	order := make([]int, len(cities))
+	points := make([][3]float64, len(cities))
+	for i, c := range cities {
+		order[i] = i
+		points[i] = unitVector(c.Lat, c.Lon)
+	}
+	ix.build(order, points, 0)
+	return ix
+}
+
+// Len returns the number of indexed cities.
+func (ix *Index) Len() int { return len(ix.cities) }
+
+func (ix *Index) build(order []int, points [][3]float64, depth int) int {
+	if len(order) == 0 {
+		return -1
+	}
+	axis := depth % 3
+	sort.Slice(order, func(i, j int) bool { return points[order[i]][axis] < points[order[j]][axis] })
+	mid := len(order) / 2
+	n := len(ix.nodes)
+	ix.nodes = append(ix.nodes, node{p: points[order[mid]], city: order[mid], axis: axis})
+	left := ix.build(order[:mid], points, depth+1)
+	right := ix.build(order[mid+1:], points, depth+1)
+	ix.nodes[n].left, ix.nodes[n].right = left, right
+	return n
+}
+
+// Nearest returns the closest city to lat,lon and its distance in metres.
+// ok is false for an empty index.
+func (ix *Index) Nearest(lat, lon float64) (c City, distM float64, ok bool) {
+	if len(ix.nodes) == 0 {
+		return City{}, 0, false
+	}
+	q := unitVector(lat, lon)
+	best, bestD := -1, math.Inf(1)
+	var search func(n int)
+	search = func(n int) {
+		if n < 0 {
+			return
+		}
+		nd := &ix.nodes[n]
+		if d := chord2(q, nd.p); d < bestD {
+			best, bestD = nd.city, d
+		}
+		diff := q[nd.axis] - nd.p[nd.axis]
+		near, far := nd.left, nd.right
+		if diff > 0 {
+			near, far = far, near
+		}
+		search(near)
+		if diff*diff < bestD {
+			search(far)
+		}
+	}
+	search(0)
+	// chord length to great-circle distance
+	return ix.cities[best], 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(bestD)/2)), true
+}
+
+func unitVector(lat, lon float64) [3]float64 {
+	phi, lam := lat*math.Pi/180, lon*math.Pi/180
+	return [3]float64{math.Cos(phi) * math.Cos(lam), math.Cos(phi) * math.Sin(lam), math.Sin(phi)}
+}
+
+func chord2(a, b [3]float64) float64 {
+	dx, dy, dz := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return dx*dx + dy*dy + dz*dz
+}
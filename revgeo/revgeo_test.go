@@ -0,0 +1,118 @@
+package revgeo
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// haversine is the great-circle distance in metres, computed independently of
+// the unit vectors the index uses.
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat, dLon := (lat2-lat1)*rad, (lon2-lon1)*rad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// randomPoint returns a point anywhere, or one close to the antimeridian or a pole.
+func randomPoint(rng *rand.Rand) (lat, lon float64) {
+	lat, lon = rng.Float64()*180-90, rng.Float64()*360-180
+	switch rng.Intn(4) {
+	case 0: // within a degree of ±180°
+		lon = 180 - rng.Float64()
+		if rng.Intn(2) == 0 {
+			lon = -lon
+		}
+	case 1: // within a degree of a pole
+		lat = 90 - rng.Float64()
+		if rng.Intn(2) == 0 {
+			lat = -lat
+		}
+	}
+	return lat, lon
+}
+
+func TestNearestBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, n := range []int{1, 2, 7, 100, 2000} {
+		cities := make([]City, n)
+		for i := range cities {
+			cities[i].Lat, cities[i].Lon = randomPoint(rng)
+		}
+		ix := NewIndex(cities)
+		if ix.Len() != n {
+			t.Fatalf("Len = %d, want %d", ix.Len(), n)
+		}
+		for q := 0; q < 500; q++ {
+			lat, lon := randomPoint(rng)
+			want := math.Inf(1)
+			for _, c := range cities {
+				want = math.Min(want, haversine(lat, lon, c.Lat, c.Lon))
+			}
+			c, d, ok := ix.Nearest(lat, lon)
+			if !ok {
+				t.Fatal("Nearest found nothing")
+			}
+			// ties may pick either city, but never a farther one
+			if got := haversine(lat, lon, c.Lat, c.Lon); math.Abs(got-want) > 1e-3 || math.Abs(d-want) > 1e-3 {
+				t.Fatalf("%d cities: Nearest(%f, %f) = %+v at %f m (reported %f), brute force %f m", n, lat, lon, c, got, d, want)
+			}
+		}
+	}
+}
+
+func TestNearestAcrossAntimeridian(t *testing.T) {
+	ix := NewIndex([]City{
+		{Name: "Suva", Lat: -18.14, Lon: 178.44},
+		{Name: "Apia", Lat: -13.83, Lon: -171.77},
+		{Name: "Honolulu", Lat: 21.31, Lon: -157.86},
+	})
+	if c, _, _ := ix.Nearest(-16, -179.9); c.Name != "Suva" {
+		t.Errorf("Nearest(-16, -179.9) = %s, want Suva", c.Name)
+	}
+	if c, _, _ := ix.Nearest(-15, 175); c.Name != "Suva" {
+		t.Errorf("Nearest(-15, 175) = %s, want Suva", c.Name)
+	}
+	if c, _, _ := ix.Nearest(-14, -176); c.Name != "Apia" {
+		t.Errorf("Nearest(-14, -176) = %s, want Apia", c.Name)
+	}
+	if _, _, ok := NewIndex(nil).Nearest(0, 0); ok {
+		t.Error("Nearest on an empty index found a city")
+	}
+}
+
+func TestReadCities(t *testing.T) {
+	admin1, err := readAdmin1(strings.NewReader("# comment\nPL.72\tLower Silesia\tLower Silesia\t3337492\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := func(fields map[int]string) string {
+		f := make([]string, 19)
+		for i, v := range fields {
+			f[i] = v
+		}
+		return strings.Join(f, "\t")
+	}
+	dump := row(map[int]string{1: "Wrocław", 4: "51.1", 5: "17.03333", 8: "PL", 10: "72", 14: "634893"}) + "\n" +
+		row(map[int]string{1: "Kraków", 4: "50.06143", 5: "19.93658", 8: "PL", 10: "77", 14: "755050"}) + "\n" +
+		"short\tline\n"
+	cities, err := readCities(strings.NewReader(dump), admin1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cities) != 2 {
+		t.Fatalf("read %d cities, want 2", len(cities))
+	}
+	want := City{Name: "Wrocław", Country: "PL", Admin1: "Lower Silesia", Lat: 51.1, Lon: 17.03333, Population: 634893}
+	if cities[0] != want {
+		t.Errorf("cities[0] = %+v, want %+v", cities[0], want)
+	}
+	if cities[1].Admin1 != "77" {
+		t.Errorf("admin1 without a name = %q, want the code", cities[1].Admin1)
+	}
+	if _, err := readCities(strings.NewReader(row(map[int]string{1: "x", 4: "north", 5: "1"})), nil); err == nil {
+		t.Error("invalid coordinates read without error")
+	}
+}
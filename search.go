@@ -0,0 +1,243 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// searchIndex is an in-memory inverted index over the text fields of the cached locations.
+type searchIndex struct {
+	locs     []ClientLocation
+	postings map[string][]posting
+	terms    []string // sorted, for prefix lookups
+}
+
+// posting records that a term occurs in a field of the location at index doc.
+type posting struct {
+	doc   int
+	field string
+}
+
+// searchHit is a single ranked search result.
+type searchHit struct {
+	Location ClientLocation `json:"location"`
+	Score    float64        `json:"score"`
+	Matched  []string       `json:"matched"`
+}
+
+// fieldWeights boosts matches in identifying fields over free text.
+var fieldWeights = map[string]float64{
+	"as":       3,
+	"asname":   3,
+	"category": 2,
+	"tags":     2,
+	"details":  1,
+}
+
+// newSearchIndex tokenizes as, asname, details, category, tags and extra fields of every location.
+func newSearchIndex(locs []ClientLocation) *searchIndex {
+	idx := &searchIndex{locs: locs, postings: map[string][]posting{}}
+	for i, loc := range locs {
+		idx.add(i, "as", loc.As)
+		idx.add(i, "asname", loc.Asname)
+		idx.add(i, "details", loc.Details)
+		idx.add(i, "category", loc.Category)
+		idx.add(i, "tags", strings.Join(loc.Tags, " "))
+		for k, v := range loc.Extra {
+			idx.add(i, k, v)
+		}
+	}
+	idx.terms = make([]string, 0, len(idx.postings))
+	for t := range idx.postings {
+		idx.terms = append(idx.terms, t)
+	}
+	sort.Strings(idx.terms)
+	return idx
+}
+
+// add indexes every token of text under the given field.
+func (idx *searchIndex) add(doc int, field, text string) {
+	seen := map[string]bool{}
+	for _, t := range tokenize(text) {
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+		idx.postings[t] = append(idx.postings[t], posting{doc: doc, field: field})
+	}
+}
+
+// tokenize lowercases text and splits it on anything that is not a letter or digit.
+// "as49242" is additionally indexed as "49242" so bare ASNs are found too.
+func tokenize(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	tokens := make([]string, 0, len(fields))
+	for _, f := range fields {
+		tokens = append(tokens, f)
+		if n := strings.TrimPrefix(f, "as"); n != f && n != "" {
+			if _, err := strconv.Atoi(n); err == nil {
+				tokens = append(tokens, n)
+			}
+		}
+	}
+	return tokens
+}
+
+// search ranks locations against q. Every query term is matched exactly, as a prefix
+// of an indexed term, or fuzzily (small edit distance); exact matches score highest.
+func (idx *searchIndex) search(q string, limit int) []searchHit {
+	type acc struct {
+		score   float64
+		terms   int
+		matched map[string]bool
+	}
+	scores := map[int]*acc{}
+
+	queryTerms := tokenize(q)
+	for _, qt := range queryTerms {
+		best := map[int]float64{}
+		fields := map[int]map[string]bool{}
+		for term, weight := range idx.candidates(qt) {
+			for _, p := range idx.postings[term] {
+				fw := fieldWeights[p.field]
+				if fw == 0 {
+					fw = 1
+				}
+				if s := weight * fw; s > best[p.doc] {
+					best[p.doc] = s
+				}
+				if fields[p.doc] == nil {
+					fields[p.doc] = map[string]bool{}
+				}
+				fields[p.doc][p.field] = true
+			}
+		}
+		for doc, s := range best {
+			a := scores[doc]
+			if a == nil {
+				a = &acc{matched: map[string]bool{}}
+				scores[doc] = a
+			}
+			a.score += s
+			a.terms++
+			for f := range fields[doc] {
+				a.matched[f] = true
+			}
+		}
+	}
+
+	hits := []searchHit{}
+	for doc, a := range scores {
+		// documents matching more of the query terms rank first
+		score := a.score * float64(a.terms) / float64(len(queryTerms))
+		matched := make([]string, 0, len(a.matched))
+		for f := range a.matched {
+			matched = append(matched, f)
+		}
+		sort.Strings(matched)
+		hits = append(hits, searchHit{Location: idx.locs[doc], Score: score, Matched: matched})
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Location.As < hits[j].Location.As
+	})
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+// candidates returns the indexed terms matching qt with their match weight.
+func (idx *searchIndex) candidates(qt string) map[string]float64 {
+	out := map[string]float64{}
+	if _, ok := idx.postings[qt]; ok {
+		out[qt] = 3
+	}
+	i := sort.SearchStrings(idx.terms, qt)
+	for ; i < len(idx.terms) && strings.HasPrefix(idx.terms[i], qt); i++ {
+		if _, ok := out[idx.terms[i]]; !ok {
+			out[idx.terms[i]] = 2
+		}
+	}
+	maxDist := fuzzyDistance(qt)
+	if maxDist == 0 {
+		return out
+	}
+	for _, t := range idx.terms {
+		if _, ok := out[t]; ok {
+			continue
+		}
+		if abs(utf8.RuneCountInString(t)-utf8.RuneCountInString(qt)) > maxDist {
+			continue
+		}
+		if levenshtein(qt, t) <= maxDist {
+			out[t] = 1
+		}
+	}
+	return out
+}
+
+// fuzzyDistance is the edit distance tolerated for a query term of this length.
+func fuzzyDistance(term string) int {
+	switch n := utf8.RuneCountInString(term); {
+	case n >= 8:
+		return 2
+	case n >= 4:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// levenshtein computes the edit distance between a and b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// apiLocationsSearch serves ranked full-text search results over the known locations.
+func apiLocationsSearch(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		http.Error(w, "Missing query parameter", http.StatusBadRequest)
+		return
+	}
+
+	limit := 20
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+
+	writeJSON(w, getLocationIndex().search(q, limit))
+}
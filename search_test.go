@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestSearchFuzzyDiacritics(t *testing.T) {
+	idx := newSearchIndex([]ClientLocation{
+		{As: "AS1", Details: "PoP Wrocław, ul. Lodzkiej 5"},
+		{As: "AS2", Details: "Kraków DC"},
+		{As: "AS3", Details: "Gdansk"},
+		{As: "AS4", Details: "Đà Nẵng"},
+	})
+	tests := []struct {
+		q    string
+		want string
+	}{
+		{"wrocław", "AS1"},  // exact
+		{"wroclaw", "AS1"},  // ł typed as l
+		{"łódzkiej", "AS1"}, // two diacritics the data lacks
+		{"nang", "AS4"},     // ẵ is 3 bytes: 4 bytes against 6, one rune apart
+		{"krakow", "AS2"},
+		{"gdańsk", "AS3"},
+	}
+	for _, tt := range tests {
+		hits := idx.search(tt.q, 0)
+		if len(hits) != 1 || hits[0].Location.As != tt.want {
+			t.Errorf("search(%q) = %+v, want %s", tt.q, hits, tt.want)
+		}
+	}
+	if d := levenshtein("kraków", "krakow"); d != 1 {
+		t.Errorf("levenshtein(kraków, krakow) = %d, want 1", d)
+	}
+}
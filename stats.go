@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/michalswi/osm/geo"
+)
+
+// countryStat is the number of locations and distinct ASNs in one country.
+type countryStat struct {
+	Country   string `json:"country"`
+	Name      string `json:"name"`
+	Locations int    `json:"locations"`
+	ASNs      int    `json:"asns"`
+}
+
+// countryStats counts locations per country they lie in (see placeCountry),
+// most locations first. Locations without a country are left out.
+func countryStats(locs []ClientLocation, countries []country) []countryStat {
+	stats := map[string]*countryStat{}
+	asns := map[string]map[uint32]bool{}
+	for _, loc := range locs {
+		code := loc.placeCountry()
+		if code == "" {
+			continue
+		}
+		s := stats[code]
+		if s == nil {
+			s = &countryStat{Country: code, Name: countryName(countries, code)}
+			if loc.Place != nil && loc.Place.CountryName != "" {
+				s.Name = loc.Place.CountryName
+			}
+			stats[code] = s
+			asns[code] = map[uint32]bool{}
+		}
+		s.Locations++
+		if asn, ok := parseASN(loc.As); ok && !asns[code][asn] {
+			asns[code][asn] = true
+			s.ASNs++
+		}
+	}
+
+	out := make([]countryStat, 0, len(stats))
+	for _, s := range stats {
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Locations != out[j].Locations {
+			return out[i].Locations > out[j].Locations
+		}
+		return out[i].Country < out[j].Country
+	})
+	return out
+}
+
+// apiStatsCountries returns the per-country counts of the filtered locations,
+// or with ?format=geojson the country polygons (COUNTRY_POLYGONS) carrying the
+// counts as properties, for a choropleth.
+func apiStatsCountries(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter, err := parseLocationFilter(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	countries, err := countryPolygons.get()
+	if err != nil {
+		logger.Printf("Failed to load country polygons: %v", err)
+	}
+	stats := countryStats(filterLocations(getCachedLocations(), filter), countries)
+
+	switch q.Get("format") {
+	case "", "json":
+		writeJSON(w, stats)
+	case "geojson":
+		if countries == nil {
+			http.Error(w, "no country polygons configured (COUNTRY_POLYGONS)", http.StatusNotFound)
+			return
+		}
+		type feature struct {
+			Type       string        `json:"type"`
+			Properties countryStat   `json:"properties"`
+			Geometry   *geo.Geometry `json:"geometry"`
+		}
+		byCode := map[string]countryStat{}
+		for _, s := range stats {
+			byCode[s.Country] = s
+		}
+		features := []feature{}
+		for _, c := range countries {
+			if s, ok := byCode[c.Code]; ok {
+				features = append(features, feature{"Feature", s, c.geometry})
+			}
+		}
+		writeJSON(w, struct {
+			Type     string    `json:"type"`
+			Features []feature `json:"features"`
+		}{"FeatureCollection", features})
+	default:
+		http.Error(w, "invalid format (want json or geojson)", http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/michalswi/osm/utils"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	errLocationNotFound = errors.New("location not found")
+	errMissingID        = errors.New("missing location id")
+)
+
+// LocationStore persists the raw location entries, as written in locations.json.
+type LocationStore interface {
+	// List returns every stored location, in insertion order.
+	List() ([]Location, error)
+	// Get returns the location with the given id, which must be set.
+	Get(id string) (Location, error)
+	// Put creates or replaces the location with loc.ID, which must be set.
+	Put(loc Location) error
+	// Delete removes the location with the given id, which must be set.
+	Delete(id string) error
+	// Replace atomically swaps the whole set for locs.
+	Replace(locs []Location) error
+	Close() error
+}
+
+// authoredStore is implemented by stores that record who made a change.
+type authoredStore interface {
+	SetAuthor(author, message string)
+}
+
+// changeDescriber is implemented by stores that know who made the latest change.
+type changeDescriber interface {
+	LastChange() (author, message string)
+}
+
+// revisionedStore is implemented by stores that can serve older states.
+type revisionedStore interface {
+	ListAt(rev string) ([]Location, error)
+}
+
+// openStore opens a store from a "kind:path" spec, e.g. "file:source/locations.json",
+// "kv:data/locations.db" or "git:/srv/locations.git#main:locations.json". A bare
+// path is a JSON file.
+func openStore(spec string) (LocationStore, error) {
+	kind, path, ok := strings.Cut(spec, ":")
+	if !ok {
+		kind, path = "file", spec
+	}
+	switch kind {
+	case "file":
+		return &fileStore{path: path}, nil
+	case "kv":
+		return openKVStore(path)
+	case "git":
+		return openGitStore(path)
+	}
+	return nil, fmt.Errorf("unknown store kind %q (file, kv, git)", kind)
+}
+
+// initStore opens the store configured in LOCATION_STORE, defaulting to the JSON file.
+func initStore() {
+	spec := utils.GetEnv("LOCATION_STORE", "file:"+sourceJson)
+	s, err := openStore(spec)
+	if err != nil {
+		logger.Fatalf("Location store setup failed: %v", err)
+	}
+	locationStore = s
+	logger.Println("Location store:", spec)
+}
+
+// newLocationID returns a random id for locations created without one.
+func newLocationID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// fileStore keeps all locations in a single JSON array file. Every write rewrites
+// the whole file, which is fine for the hand-edited datasets it is meant for.
+type fileStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+func (s *fileStore) read() ([]Location, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	var locations []Location
+	if err := json.Unmarshal(data, &locations); err != nil {
+		return nil, err
+	}
+	return locations, nil
+}
+
+func (s *fileStore) write(locations []Location) error {
+	data, err := json.MarshalIndent(locations, "", "    ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, append(data, '\n'))
+}
+
+func (s *fileStore) List() ([]Location, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read()
+}
+
+func (s *fileStore) Get(id string) (Location, error) {
+	if id == "" {
+		return Location{}, errMissingID
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	locations, err := s.read()
+	if err != nil {
+		return Location{}, err
+	}
+	for _, loc := range locations {
+		if loc.ID == id {
+			return loc, nil
+		}
+	}
+	return Location{}, errLocationNotFound
+}
+
+func (s *fileStore) Put(loc Location) error {
+	if loc.ID == "" {
+		return errMissingID
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	locations, err := s.read()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := range locations {
+		if locations[i].ID == loc.ID {
+			locations[i] = loc
+			return s.write(locations)
+		}
+	}
+	return s.write(append(locations, loc))
+}
+
+func (s *fileStore) Replace(locs []Location) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(locs)
+}
+
+func (s *fileStore) Delete(id string) error {
+	if id == "" {
+		return errMissingID
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	locations, err := s.read()
+	if err != nil {
+		return err
+	}
+	for i := range locations {
+		if locations[i].ID == id {
+			return s.write(append(locations[:i], locations[i+1:]...))
+		}
+	}
+	return errLocationNotFound
+}
+
+func (s *fileStore) Close() error { return nil }
+
+// writeFileAtomic replaces path with data via a temporary file and rename.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// kvEntry is the value stored under a location id.
+type kvEntry struct {
+	Seq      uint64   `json:"seq"`
+	Location Location `json:"location"`
+}
+
+var (
+	kvLocations = []byte("locations") // id -> kvEntry
+	kvOrder     = []byte("order")     // big-endian seq -> id, keeps List in insertion order
+)
+
+// kvStore keeps locations in an embedded bbolt database, keyed by id with an
+// index on insertion order, so single entries are read and written without
+// loading the whole set. Every write is one transaction.
+type kvStore struct {
+	db   *bolt.DB
+	path string
+}
+
+func openKVStore(path string) (*kvStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("kv store %s: %v", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{kvLocations, kvOrder} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &kvStore{db: db, path: path}, nil
+}
+
+func kvSeqKey(seq uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, seq)
+}
+
+func (s *kvStore) get(tx *bolt.Tx, id string) (kvEntry, bool, error) {
+	data := tx.Bucket(kvLocations).Get([]byte(id))
+	if data == nil {
+		return kvEntry{}, false, nil
+	}
+	var e kvEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return e, false, fmt.Errorf("%s: corrupt entry %q: %v", s.path, id, err)
+	}
+	return e, true, nil
+}
+
+// put stores loc, keeping the position of an existing entry with the same id.
+func (s *kvStore) put(tx *bolt.Tx, loc Location) error {
+	old, exists, err := s.get(tx, loc.ID)
+	if err != nil {
+		return err
+	}
+	e := kvEntry{Seq: old.Seq, Location: loc}
+	if !exists {
+		if e.Seq, err = tx.Bucket(kvOrder).NextSequence(); err != nil {
+			return err
+		}
+		if err := tx.Bucket(kvOrder).Put(kvSeqKey(e.Seq), []byte(loc.ID)); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(kvLocations).Put([]byte(loc.ID), data)
+}
+
+func (s *kvStore) List() ([]Location, error) {
+	var out []Location
+	err := s.db.View(func(tx *bolt.Tx) error {
+		out = make([]Location, 0, tx.Bucket(kvLocations).Stats().KeyN)
+		return tx.Bucket(kvOrder).ForEach(func(_, id []byte) error {
+			e, ok, err := s.get(tx, string(id))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("%s: order index points at missing entry %q", s.path, id)
+			}
+			out = append(out, e.Location)
+			return nil
+		})
+	})
+	return out, err
+}
+
+func (s *kvStore) Get(id string) (Location, error) {
+	if id == "" {
+		return Location{}, errMissingID
+	}
+	var loc Location
+	err := s.db.View(func(tx *bolt.Tx) error {
+		e, ok, err := s.get(tx, id)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errLocationNotFound
+		}
+		loc = e.Location
+		return nil
+	})
+	return loc, err
+}
+
+func (s *kvStore) Put(loc Location) error {
+	if loc.ID == "" {
+		return errMissingID
+	}
+	return s.db.Update(func(tx *bolt.Tx) error { return s.put(tx, loc) })
+}
+
+func (s *kvStore) Delete(id string) error {
+	if id == "" {
+		return errMissingID
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		e, ok, err := s.get(tx, id)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errLocationNotFound
+		}
+		if err := tx.Bucket(kvOrder).Delete(kvSeqKey(e.Seq)); err != nil {
+			return err
+		}
+		return tx.Bucket(kvLocations).Delete([]byte(id))
+	})
+}
+
+// Replace swaps the whole set for locs in one transaction. Of entries sharing
+// an id the last one wins, at the position of the first.
+func (s *kvStore) Replace(locs []Location) error {
+	for _, loc := range locs {
+		if loc.ID == "" {
+			return errMissingID
+		}
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{kvLocations, kvOrder} {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		for _, loc := range locs {
+			if err := s.put(tx, loc); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *kvStore) Close() error { return s.db.Close() }
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/michalswi/osm/gitrepo"
+)
+
+func locIDs(locs []Location) []string {
+	ids := make([]string, len(locs))
+	for i, loc := range locs {
+		ids[i] = loc.ID
+	}
+	return ids
+}
+
+// testStore runs the behaviour every LocationStore shares; reopen closes the
+// store and opens it again from disk.
+func testStore(t *testing.T, s LocationStore, reopen func() LocationStore) {
+	t.Helper()
+	for _, id := range []string{"a", "b", "c"} {
+		if err := s.Put(Location{ID: id, Location: "51.1,17.03"}); err != nil {
+			t.Fatalf("Put(%s): %v", id, err)
+		}
+	}
+	// replacing keeps the position
+	if err := s.Put(Location{ID: "a", Location: "50.06,19.94"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("b"); !errors.Is(err, errLocationNotFound) {
+		t.Errorf("Delete(b) again = %v, want errLocationNotFound", err)
+	}
+	if _, err := s.Get("b"); !errors.Is(err, errLocationNotFound) {
+		t.Errorf("Get(b) = %v, want errLocationNotFound", err)
+	}
+
+	if err := s.Put(Location{Location: "1,1"}); !errors.Is(err, errMissingID) {
+		t.Errorf("Put without id = %v, want errMissingID", err)
+	}
+	if err := s.Delete(""); !errors.Is(err, errMissingID) {
+		t.Errorf("Delete(\"\") = %v, want errMissingID", err)
+	}
+	if _, err := s.Get(""); !errors.Is(err, errMissingID) {
+		t.Errorf("Get(\"\") = %v, want errMissingID", err)
+	}
+
+	s = reopen()
+	locs, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := locIDs(locs); !reflect.DeepEqual(ids, []string{"a", "c"}) {
+		t.Errorf("List after reopen = %v, want [a c]", ids)
+	}
+	if loc, err := s.Get("a"); err != nil || loc.Location != "50.06,19.94" {
+		t.Errorf("Get(a) = %+v, %v", loc, err)
+	}
+
+	if err := s.Replace([]Location{{ID: "z", As: "AS1"}, {ID: "y"}}); err != nil {
+		t.Fatal(err)
+	}
+	s = reopen()
+	locs, err = s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := locIDs(locs); !reflect.DeepEqual(ids, []string{"z", "y"}) {
+		t.Errorf("List after Replace = %v, want [z y]", ids)
+	}
+	if loc, err := s.Get("z"); err != nil || loc.As != "AS1" {
+		t.Errorf("Get(z) after Replace = %+v, %v", loc, err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "locations.json")
+	var s LocationStore = &fileStore{path: path}
+	testStore(t, s, func() LocationStore {
+		s.Close()
+		s = &fileStore{path: path}
+		return s
+	})
+}
+
+func TestFileStoreKeepsEntriesWithoutID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "locations.json")
+	if err := os.WriteFile(path, []byte(`[{"location":"51.1,17.03"},{"id":"a","location":"1,1"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := &fileStore{path: path}
+	if err := s.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if locs, err := s.List(); err != nil || len(locs) != 1 || locs[0].Location != "51.1,17.03" {
+		t.Errorf("List = %+v, %v, want the entry without id kept", locs, err)
+	}
+}
+
+func TestKVStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "locations.db")
+	open := func() LocationStore {
+		s, err := openKVStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	s := open()
+	testStore(t, s, func() LocationStore {
+		s.Close()
+		s = open()
+		return s
+	})
+
+	if err := os.WriteFile(path, []byte("not a database"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openKVStore(path); err == nil {
+		t.Error("openKVStore accepted a corrupt file")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "locations.json")
+	dst := filepath.Join(dir, "locations.db")
+	write := func(data string) {
+		if err := os.WriteFile(src, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list := func() []Location {
+		s, err := openKVStore(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		locs, err := s.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return locs
+	}
+
+	write(`[{"id":"a","location":"1,1"},{"id":"b","location":"2,2"}]`)
+	if err := runMigrate([]string{"-from", "file:" + src, "-to", "kv:" + dst}); err != nil {
+		t.Fatal(err)
+	}
+	write(`[{"id":"b","location":"3,3"},{"location":"4,4"}]`)
+	if err := runMigrate([]string{"-from", "file:" + src, "-to", "kv:" + dst}); err != nil {
+		t.Fatal(err)
+	}
+	locs := list()
+	if len(locs) != 3 || locs[0].ID != "a" || locs[1].ID != "b" || locs[1].Location != "3,3" || locs[2].ID == "" {
+		t.Errorf("after merge = %+v, want a, updated b and a new entry with an id", locs)
+	}
+
+	if err := runMigrate([]string{"-from", "file:" + src, "-to", "kv:" + dst, "-replace"}); err != nil {
+		t.Fatal(err)
+	}
+	if locs := list(); len(locs) != 2 || locs[0].ID != "b" {
+		t.Errorf("after -replace = %+v, want only the source entries", locs)
+	}
+}
+
+func TestGitStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "locations.git")
+	open := func() LocationStore {
+		s, err := openGitStore(path + "#data:eu/locations.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	s := open()
+	testStore(t, s, func() LocationStore {
+		s.Close()
+		s = open()
+		return s
+	})
+
+	gs := s.(*gitStore)
+	gs.SetAuthor("Jan <jan@example.com>", "delete y")
+	if err := gs.Delete("y"); err != nil {
+		t.Fatal(err)
+	}
+	author, change := gs.LastChange()
+	rev, subject, _ := strings.Cut(change, " ")
+	if author != "Jan" || subject != "delete y" {
+		t.Errorf("LastChange = %q, %q", author, change)
+	}
+	// brackets and newlines cannot leak into the author line
+	gs.SetAuthor("Eve <x>\ncommitter Mallory <m@example.com", "")
+	if err := gs.Put(Location{ID: "w"}); err != nil {
+		t.Fatal(err)
+	}
+	if author, _ := gs.LastChange(); author != "Eve" {
+		t.Errorf("LastChange author = %q, want Eve", author)
+	}
+	c, err := gs.repo.ReadCommit(gs.tip)
+	if err != nil || c.Author.Email != "xcommitter Mallory m@example.com" {
+		t.Errorf("ReadCommit = %+v, %v", c.Author, err)
+	}
+	// the replace before the delete, by abbreviated id of its child's parent
+	c, err = gs.repo.ReadCommit(mustResolve(t, gs, rev))
+	if err != nil {
+		t.Fatal(err)
+	}
+	locs, err := gs.ListAt(c.Parents[0].String()[:8])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := locIDs(locs); !reflect.DeepEqual(ids, []string{"z", "y"}) {
+		t.Errorf("ListAt(parent) = %v, want [z y]", ids)
+	}
+}
+
+func mustResolve(t *testing.T, s *gitStore, rev string) gitrepo.Hash {
+	t.Helper()
+	h, err := s.repo.Resolve(rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestOpenGitStoreInit(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty")
+	os.Mkdir(empty, 0755)
+	if _, err := openGitStore(empty); err != nil {
+		t.Errorf("empty directory: %v", err)
+	}
+	if _, err := openGitStore(filepath.Join(dir, "new", "locations.git")); err != nil {
+		t.Errorf("missing directory: %v", err)
+	}
+
+	other := filepath.Join(dir, "other")
+	os.Mkdir(other, 0755)
+	os.WriteFile(filepath.Join(other, "notes.txt"), []byte("x"), 0644)
+	if _, err := openGitStore(other); err == nil {
+		t.Error("a non-empty directory was turned into a repository")
+	}
+	if _, err := os.Stat(filepath.Join(other, "HEAD")); err == nil {
+		t.Error("HEAD written into a non-empty directory")
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/michalswi/osm/geo"
+	"github.com/michalswi/osm/geoip"
+)
+
+// snapRadiusKm is how far a hop geolocated to the same AS may be from a known
+// location and still be snapped to it (unless the database accuracy is worse).
+const snapRadiusKm = 100
+
+// maxTraceSize limits uploaded traceroute/mtr output.
+const maxTraceSize = 1 << 20
+
+// traceHop is one hop of a traceroute, geolocated and ASN-tagged.
+type traceHop struct {
+	Hop         int             `json:"hop"`
+	IP          string          `json:"ip,omitempty"`
+	Host        string          `json:"host,omitempty"`
+	RTTms       []float64       `json:"rtt_ms"`
+	AvgRTTms    float64         `json:"avg_rtt_ms"`
+	LossPct     *float64        `json:"loss_pct,omitempty"`
+	Private     bool            `json:"private,omitempty"`
+	HasLocation bool            `json:"has_location"`
+	Lat         float64         `json:"lat,omitempty"`
+	Lon         float64         `json:"lon,omitempty"`
+	AccuracyKm  float64         `json:"accuracy_km,omitempty"`
+	Country     string          `json:"country,omitempty"`
+	City        string          `json:"city,omitempty"`
+	As          string          `json:"as,omitempty"`
+	Asname      string          `json:"asname,omitempty"`
+	ASChange    bool            `json:"as_change"`
+	Location    *ClientLocation `json:"location,omitempty"`
+}
+
+// traceResult is the response of /api/trace.
+type traceResult struct {
+	Format string      `json:"format"`
+	Target string      `json:"target,omitempty"`
+	Hops   []traceHop  `json:"hops"`
+	Path   []geo.Point `json:"path"`
+}
+
+// mtrReport is the output of mtr --json.
+type mtrReport struct {
+	Report struct {
+		Mtr struct {
+			Dst string `json:"dst"`
+		} `json:"mtr"`
+		Hubs []struct {
+			Count json.Number `json:"count"`
+			Host  string      `json:"host"`
+			Loss  float64     `json:"Loss%"`
+			Last  float64     `json:"Last"`
+			Avg   float64     `json:"Avg"`
+			Best  float64     `json:"Best"`
+			Wrst  float64     `json:"Wrst"`
+		} `json:"hubs"`
+	} `json:"report"`
+}
+
+// parseTrace reads mtr --json or plain traceroute output.
+func parseTrace(data []byte) (traceResult, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return parseMTR(data)
+	}
+	return parseTraceroute(data)
+}
+
+func parseMTR(data []byte) (traceResult, error) {
+	var rep mtrReport
+	if err := json.Unmarshal(data, &rep); err != nil {
+		return traceResult{}, fmt.Errorf("invalid mtr JSON: %v", err)
+	}
+	res := traceResult{Format: "mtr", Target: rep.Report.Mtr.Dst, Hops: []traceHop{}}
+	for i, hub := range rep.Report.Hubs {
+		hop := traceHop{Hop: i + 1, RTTms: []float64{}}
+		if n, err := hub.Count.Int64(); err == nil {
+			hop.Hop = int(n)
+		}
+		loss := hub.Loss
+		hop.LossPct = &loss
+		hop.IP, hop.Host = splitHost(hub.Host)
+		if hop.IP != "" || hop.Host != "" {
+			hop.AvgRTTms = hub.Avg
+			hop.RTTms = []float64{hub.Best, hub.Avg, hub.Wrst}
+		}
+		res.Hops = append(res.Hops, hop)
+	}
+	return res, nil
+}
+
+// splitHost separates an mtr host field ("router.example (192.0.2.1)", "192.0.2.1" or "???").
+func splitHost(s string) (ip, host string) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "???" {
+		return "", ""
+	}
+	if i := strings.LastIndex(s, " ("); i >= 0 && strings.HasSuffix(s, ")") {
+		return s[i+2 : len(s)-1], s[:i]
+	}
+	if _, err := netip.ParseAddr(s); err == nil {
+		return s, ""
+	}
+	return "", s
+}
+
+// parseTraceroute reads classic traceroute output, e.g.
+//
+//	2  core1.example.net (192.0.2.1)  1.234 ms  1.101 ms  1.088 ms
+//	3  * * *
+//
+// Only the first responder of a hop is kept.
+func parseTraceroute(data []byte) (traceResult, error) {
+	res := traceResult{Format: "traceroute", Hops: []traceHop{}}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "traceroute" || fields[0] == "traceroute6" {
+			if len(fields) > 2 {
+				res.Target = strings.Trim(fields[2], "(),")
+			}
+			continue
+		}
+		n, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue // continuation line with another responder
+		}
+
+		hop := traceHop{Hop: n, RTTms: []float64{}}
+		for i := 1; i < len(fields); i++ {
+			f := strings.Trim(fields[i], "()")
+			if _, err := netip.ParseAddr(f); err == nil && hop.IP == "" {
+				hop.IP = f
+				continue
+			}
+			if i+1 < len(fields) && fields[i+1] == "ms" {
+				if v, err := strconv.ParseFloat(f, 64); err == nil {
+					hop.RTTms = append(hop.RTTms, v)
+				}
+				i++
+				continue
+			}
+			if hop.IP == "" && hop.Host == "" && f != "*" && !strings.HasPrefix(f, "!") {
+				hop.Host = f
+			}
+		}
+		if hop.IP == "" && hop.Host != "" {
+			if _, err := netip.ParseAddr(hop.Host); err == nil {
+				hop.IP, hop.Host = hop.Host, ""
+			}
+		}
+		if len(hop.RTTms) > 0 {
+			var sum float64
+			for _, v := range hop.RTTms {
+				sum += v
+			}
+			hop.AvgRTTms = sum / float64(len(hop.RTTms))
+		}
+		res.Hops = append(res.Hops, hop)
+	}
+	if len(res.Hops) == 0 {
+		return res, fmt.Errorf("no hops found in traceroute output")
+	}
+	return res, nil
+}
+
+// locateHops geolocates every hop and snaps it to a known location when the hop
+// is inside a location's prefix, or in the same AS near a location.
+func locateHops(res *traceResult, locs []ClientLocation) {
+	prevAS := ""
+	for i := range res.Hops {
+		hop := &res.Hops[i]
+		addr, err := netip.ParseAddr(hop.IP)
+		if err != nil {
+			continue
+		}
+		hop.Private = addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast()
+
+		var rec geoip.Record
+		if geoDB != nil && !hop.Private {
+			rec, _ = geoDB.Lookup(addr)
+		}
+		if rec.ASN != 0 {
+			hop.As, hop.Asname = fmt.Sprintf("AS%d", rec.ASN), rec.ASOrg
+		}
+		hop.Country, hop.City = rec.Country, rec.City
+		if rec.HasLocation {
+			hop.HasLocation = true
+			hop.Lat, hop.Lon, hop.AccuracyKm = rec.Lat, rec.Lon, rec.AccuracyKm
+		}
+
+		if loc := snapHop(hop, addr, locs); loc != nil {
+			hop.Location = loc
+			hop.HasLocation = true
+			hop.Lat, hop.Lon, hop.AccuracyKm = loc.Lat, loc.Lon, 0
+			if hop.As == "" {
+				hop.As, hop.Asname = loc.As, loc.Asname
+			}
+		}
+
+		if hop.As != "" {
+			hop.ASChange = prevAS != "" && hop.As != prevAS
+			prevAS = hop.As
+		}
+		if hop.HasLocation {
+			res.Path = append(res.Path, geo.Point{Lat: hop.Lat, Lon: hop.Lon})
+		}
+	}
+	if res.Path == nil {
+		res.Path = []geo.Point{}
+	}
+}
+
+// snapHop finds the known location a hop belongs to: the most specific location
+// prefix containing its address, otherwise the closest location of the same AS.
+func snapHop(hop *traceHop, addr netip.Addr, locs []ClientLocation) *ClientLocation {
+	var best *ClientLocation
+	bestBits := -1
+	for i, loc := range locs {
+		if loc.Prefix == "" {
+			continue
+		}
+		p, err := netip.ParsePrefix(loc.Prefix)
+		if err != nil {
+			if a, err := netip.ParseAddr(loc.Prefix); err == nil {
+				p = netip.PrefixFrom(a, a.BitLen())
+			} else {
+				continue
+			}
+		}
+		if p.Contains(addr) && p.Bits() > bestBits {
+			best, bestBits = &locs[i], p.Bits()
+		}
+	}
+	if best != nil || hop.As == "" || !hop.HasLocation {
+		return best
+	}
+
+	asn, ok := parseASN(hop.As)
+	if !ok {
+		return nil
+	}
+	radius := snapRadiusKm * 1.0
+	if hop.AccuracyKm > radius {
+		radius = hop.AccuracyKm
+	}
+	bestDist := -1.0
+	for i, loc := range locs {
+		if n, ok := parseASN(loc.As); !ok || n != asn {
+			continue
+		}
+		d := geo.Haversine(geo.Point{Lat: hop.Lat, Lon: hop.Lon}, geo.Point{Lat: loc.Lat, Lon: loc.Lon}) / 1000
+		if d <= radius && (bestDist < 0 || d < bestDist) {
+			best, bestDist = &locs[i], d
+		}
+	}
+	return best
+}
+
+// apiTrace accepts raw traceroute or mtr --json output (POST body or "trace"
+// form field) and returns the geolocated hops.
+func apiTrace(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST traceroute or mtr --json output", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxTraceSize)
+	var data []byte
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(maxTraceSize); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		data = []byte(r.FormValue("trace"))
+	} else {
+		var err error
+		data, err = io.ReadAll(r.Body)
+		if err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		// curl -d and HTML forms send trace=...; anything else is taken as is
+		if form, err := url.ParseQuery(string(data)); err == nil && form.Has("trace") {
+			data = []byte(form.Get("trace"))
+		}
+	}
+
+	res, err := parseTrace(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	locateHops(&res, getCachedLocations())
+	writeJSON(w, res)
+}
+
+// tracePage serves the traceroute upload page.
+func tracePage(w http.ResponseWriter, r *http.Request) {
+	t := tpl_trace
+	if proxyEnabled {
+		t = tpl_trace_proxy
+	}
+	if err := t.Execute(w, nil); err != nil {
+		http.Error(w, "Internal Error", 500)
+	}
+	logRequestDetails(r)
+}
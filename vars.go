@@ -8,15 +8,18 @@ import (
 	"text/template"
 	"time"
 
+	"github.com/michalswi/osm/geoip"
 	"github.com/michalswi/osm/utils"
 )
 
 var logger = log.New(os.Stdout, "osm: ", log.LstdFlags|log.Lshortfile)
 var port = utils.GetEnv("SERVER_PORT", "5050")
 var proxyStr = os.Getenv("PROXY_ADDR")
+var detailsTemplate = utils.GetEnv("DETAILS_TEMPLATE", "https://bgp.he.net/{as}#_whois")
 
 var (
 	sourceJson = "source/locations.json"
+	linksJson  = "source/links.json"
 
 	logMutex     sync.Mutex
 	logPath      string
@@ -24,9 +27,29 @@ var (
 	proxyEnabled bool
 
 	locationsCache      []ClientLocation
+	locationsIndex      *searchIndex
+	areasCache          []ClientArea
+	locationIssues      []validationIssue
+	linksCache          []Link
 	locationsCacheMu    sync.RWMutex
 	locationsCacheTTL   = 3 * time.Second
 	locationsCacheStamp time.Time
+
+	asnReg = &asnRegistry{path: os.Getenv("ASN_REGISTRY")}
+	geoDB  geoip.DB
+
+	peeringDB = &peeringDBLayers{path: os.Getenv("PEERINGDB_DUMP")}
+
+	countryPolygons = &countryIndex{path: os.Getenv("COUNTRY_POLYGONS")}
+	// admin-1 features carry the ISO code of their country, so a region file
+	// loads as entries with the country's Code and the region's Name
+	regionPolygons = &countryIndex{path: os.Getenv("REGION_POLYGONS")}
+	cityNames      = &placeNames{citiesPath: os.Getenv("GEONAMES_CITIES"), admin1Path: os.Getenv("GEONAMES_ADMIN1")}
+
+	locationStore   LocationStore
+	locationWriteMu sync.Mutex
+	history         *locationHistory
+	apiToken        = os.Getenv("API_TOKEN")
 )
 
 var tpl = template.Must(template.New("page").Parse(`
@@ -56,6 +79,7 @@ var tpl = template.Must(template.New("page").Parse(`
             padding:12px;
             box-sizing:border-box;
             gap:14px;
+            overflow-y:auto;
         }
         body.light #sidebar { background:#ffffff; color:#222; }
 
@@ -63,14 +87,14 @@ var tpl = template.Must(template.New("page").Parse(`
         .block { border:1px solid #313b44; border-radius:6px; padding:10px; }
         body.light .block { border-color:#d9d9d9; }
 
-        input, select, button {
+        input, select, button, textarea {
             font-size:13px; padding:6px 8px;
             border:1px solid #495661; border-radius:4px;
             background:#2d353c; color:#e2e6ea;
         }
         input:focus, select:focus { outline:2px solid #4d92ff; }
         button { cursor:pointer; }
-        body.light input, body.light select, body.light button {
+        body.light input, body.light select, body.light button, body.light textarea {
             background:#fafafa; color:#111; border-color:#c3c7cb;
         }
         button:hover { background:#3a444d; }
@@ -86,6 +110,16 @@ var tpl = template.Must(template.New("page").Parse(`
         #map-wrap { flex:1; display:flex; }
         #map { flex:1; min-height:0; }
 
+        /* Search results */
+        .result-section { font-size:12px; opacity:.7; margin-top:8px; }
+        .result { font-size:12px; padding:4px 2px; cursor:pointer; border-bottom:1px solid #313b44; }
+        body.light .result { border-bottom-color:#eee; }
+        .result:hover { background:#3a444d; }
+        #location-list { max-height:220px; overflow-y:auto; }
+        body.light .result:hover { background:#ececec; }
+
+        .measure-result { font-size:12px; margin-top:8px; }
+
         /* Share URL */
         .share-url-block .row { align-items:stretch; }
         .share-url-block input { flex:1; min-width:0; }
@@ -115,6 +149,7 @@ var tpl = template.Must(template.New("page").Parse(`
                 <input id="search" type="text" placeholder="Wrocław, Poland" onkeydown="if(event.key==='Enter'){searchPlace();}">
                 <button onclick="searchPlace()">Search</button>
             </div>
+            <div id="search-results"></div>
         </div>
 
         <div class="block">
@@ -130,9 +165,9 @@ var tpl = template.Must(template.New("page").Parse(`
         </div>
 
         <div class="block">
-            <h2>or Enter Coordinates (lat,lon)</h2>
+            <h2>or Enter Coordinates</h2>
             <div class="row">
-                <input id="coord" type="text" placeholder="12.34,56.78">
+                <input id="coord" type="text" placeholder="12.34,56.78 or 51&deg;06'35.9&quot;N 17&deg;01'55.1&quot;E" title="lat,lon, DMS, DDM, geo: URI, geohash or plus code">
                 <button onclick="updateMapFromText()">Find</button>
             </div>
         </div>
@@ -146,6 +181,100 @@ var tpl = template.Must(template.New("page").Parse(`
             </select>
         </div>
 
+        <div class="block">
+            <h2>Measure</h2>
+            <div class="row">
+                <button class="stretch" onclick="startMeasure('distance')">Distance</button>
+                <button class="stretch" onclick="startMeasure('area')">Area</button>
+                <button class="stretch" onclick="stopMeasure()">Clear</button>
+            </div>
+            <div id="measure-result" class="measure-result"></div>
+        </div>
+
+        <div class="block">
+            <h2>Filter</h2>
+            <div class="col">
+                <input id="filter-tags" type="text" placeholder="ix, !decommissioned" onkeydown="if(event.key==='Enter'){applyFilter();}">
+                <select id="filter-category">
+                    <option value="">All categories</option>
+                </select>
+                <select id="filter-country">
+                    <option value="">All countries</option>
+                </select>
+            </div>
+            <div class="row" style="margin-top:8px;">
+                <button class="stretch" onclick="applyFilter()">Apply</button>
+                <button class="stretch" onclick="clearFilter()">Clear</button>
+            </div>
+        </div>
+
+        <div class="block" id="timeline-block">
+            <h2>Timeline</h2>
+            <div class="col">
+                <input id="timeline" type="range" min="0" max="0" value="0" oninput="onTimeline()">
+                <div id="timeline-label" class="measure-result">All time</div>
+            </div>
+            <div class="row" style="margin-top:8px;">
+                <button class="stretch" onclick="playTimeline()">▶ Play</button>
+                <button class="stretch" onclick="clearTimeline()">All time</button>
+            </div>
+        </div>
+
+        <div class="block">
+            <h2>Locations</h2>
+            <div class="row">
+                <select id="list-group" onchange="refreshLocationList()">
+                    <option value="as">By AS</option>
+                    <option value="asname">By AS name</option>
+                    <option value="country">By country</option>
+                    <option value="place">By place</option>
+                </select>
+                <input id="list-filter" type="text" class="stretch" placeholder="Filter list" oninput="renderLocationList()">
+            </div>
+            <div id="location-list"></div>
+        </div>
+
+        <div class="block">
+            <h2>Density</h2>
+            <div class="row">
+                <select id="density-source" class="stretch" onchange="refreshDensity()">
+                    <option value="">Off</option>
+                    <option value="locations">Locations</option>
+                    <option value="visitors">Visitors</option>
+                </select>
+                <select id="density-style" class="stretch" onchange="refreshDensity()">
+                    <option value="heat">Heatmap</option>
+                    <option value="hex">Hexagons</option>
+                    <option value="square">Squares</option>
+                </select>
+            </div>
+            <div id="density-status" class="measure-result"></div>
+        </div>
+
+        <div class="block">
+            <h2>Countries</h2>
+            <select id="country-metric" onchange="refreshCountryStats()">
+                <option value="">Off</option>
+                <option value="locations">Locations per country</option>
+                <option value="asns">ASNs per country</option>
+            </select>
+            <div id="country-legend" class="measure-result"></div>
+        </div>
+
+        <div class="block">
+            <h2>PeeringDB</h2>
+            <div class="col">
+                <label><input id="pdb-fac" type="checkbox" onchange="refreshPeeringDB()"> Facilities</label>
+                <label><input id="pdb-ix" type="checkbox" onchange="refreshPeeringDB()"> IXPs</label>
+                <select id="pdb-shared" onchange="refreshPeeringDB()">
+                    <option value="0">All in view</option>
+                    <option value="1">With any of our ASNs</option>
+                    <option value="2">Shared by 2+ of our ASNs</option>
+                </select>
+            </div>
+            <div id="pdb-status" class="measure-result"></div>
+        </div>
+
         <div class="block share-url-block">
             <h2>Share URL</h2>
             <div class="row">
@@ -154,6 +283,27 @@ var tpl = template.Must(template.New("page").Parse(`
             </div>
         </div>
 
+        <div class="block">
+            <h2>IP Lookup</h2>
+            <div class="col">
+                <textarea id="ip-list" rows="4" placeholder="193.0.6.139&#10;2001:67c:2e8::2"></textarea>
+                <div class="row">
+                    <button class="stretch" onclick="plotIPs()">Plot</button>
+                    <button class="stretch" onclick="clearIPs()">Clear</button>
+                </div>
+                <div class="row">
+                    <button class="stretch" onclick="exportIPs('csv')">CSV</button>
+                    <button class="stretch" onclick="exportIPs('geojson')">GeoJSON</button>
+                </div>
+            </div>
+            <div id="ip-status" class="measure-result"></div>
+        </div>
+
+        <div class="block">
+            <h2>Traceroute</h2>
+            <button class="stretch" onclick="window.location.href='/trace'">Visualise traceroute / mtr</button>
+        </div>
+
         <div id="footer">© 2049 michalswi</div>
 
     </div>
@@ -165,6 +315,9 @@ var tpl = template.Must(template.New("page").Parse(`
 <script>
     var lat = parseFloat("{{.Lat}}");
     var lon = parseFloat("{{.Lon}}");
+    // label of the shared position, kept in the share URL while the marker stays there
+    var shareLabel = { lat: lat.toFixed(6), lon: lon.toFixed(6), text: {{.LabelJSON}} };
+    var locationFilter = {{.FilterJSON}};
 
     var map = L.map('map', { 
         zoomControl:true  // Enable zoom controls 
@@ -178,7 +331,7 @@ var tpl = template.Must(template.New("page").Parse(`
     currentTiles.addTo(map);
     
     var marker = L.marker([lat, lon], { draggable:true }).addTo(map)
-        .bindPopup("Default Location")
+        .bindPopup(shareLabel.text ? escapeHTML(shareLabel.text) + "<br>" + lat.toFixed(6) + ", " + lon.toFixed(6) : "Default Location")
         .openPopup();
     updateShareURL(lat, lon);
 
@@ -195,49 +348,403 @@ var tpl = template.Must(template.New("page").Parse(`
 
     // Add pins from locations.json
     var locations = {{.LocationsJSON}};
-    locations.forEach(function(location){
+
+    function locationPopup(location) {
         var detailsHTML = location.details;
         if (/^https?:\/\//i.test(detailsHTML)) {
             detailsHTML = '<a href="' + detailsHTML + '" target="_blank" rel="noopener">' + detailsHTML + '</a>';
         }
+        var html = "as: " + location.as + "<br>asname: " + location.asname + "<br>details: " + detailsHTML;
+        if (location.country) html += "<br>country: " + location.country;
+        if (location.place) {
+            var pl = location.place;
+            html += "<br>place: " + [pl.city, pl.region, pl.country_name].filter(Boolean).join(", ");
+        }
+        if (location.category) html += "<br>category: " + location.category;
+        if (location.tags && location.tags.length) html += "<br>tags: " + location.tags.join(", ");
+        if (location.derived) html += "<br>derived from " + location.prefix + " (±" + location.accuracy_km + " km)";
+        return html;
+    }
+
+    var dynamicMarkers = [];
+    function addLocationMarker(location) {
         var m = L.marker([location.lat, location.lon]).addTo(map)
-            .bindPopup("as: " + location.as + "<br>asname: " + location.asname + "<br>details: " + detailsHTML);
-        m.on('click', function(){
+            .bindPopup(locationPopup(location));
+        m.location = location;
+        m.on('click', function() {
             updateShareURL(location.lat.toFixed(6), location.lon.toFixed(6));
         });
-    });
+        dynamicMarkers.push(m);
+        if (location.derived && location.accuracy_km > 0) {
+            // uncertainty of a position resolved from an IP prefix
+            var c = L.circle([location.lat, location.lon], {
+                radius: location.accuracy_km * 1000,
+                interactive: false,
+                color: '#7048e8', weight: 1, fillOpacity: 0.08
+            }).addTo(map);
+            dynamicMarkers.push(c);
+        }
+        return m;
+    }
 
-    var dynamicMarkers = [];
-    function clearDynamicMarkers(){ 
-        dynamicMarkers.forEach(m=>map.removeLayer(m)); 
-        dynamicMarkers=[];
-    }
-
-    function refreshLocations(){
-        fetch('/api/locations')
-          .then(r=>r.json())
-          .then(list=>{
-              clearDynamicMarkers();
-              list.forEach(function(location){
-                  var detailsHTML = location.details;
-                  if (/^https?:\/\//i.test(detailsHTML)) {
-                      detailsHTML = '<a href="' + detailsHTML + '" target="_blank" rel="noopener">' + detailsHTML + '</a>';
-                  }
-                  var dm = L.marker([location.lat, location.lon]).addTo(map)
-                      .bindPopup("as: " + location.as + "<br>asname: " + location.asname + "<br>details: " + detailsHTML);
-                  dm.on('click', function(){
-                      updateShareURL(location.lat.toFixed(6), location.lon.toFixed(6));
-                  });
-                  dynamicMarkers.push(dm);
-              });
-          })
-          .catch(()=>{});
+    function clearDynamicMarkers() {
+        dynamicMarkers.forEach(m => map.removeLayer(m));
+        dynamicMarkers = [];
+    }
+
+    locations.forEach(addLocationMarker);
+
+    function refreshLocations() {
+        fetch('/api/locations' + filterQuery())
+            .then(r => r.json())
+            .then(list => {
+                clearDynamicMarkers();
+                list.forEach(addLocationMarker);
+            })
+            .catch(err => console.log('locations refresh error', err));
+        refreshAreas();
+        refreshLinks();
+        refreshLocationList();
+        if (document.getElementById('density-source').value === 'locations') {
+            refreshDensity();
+        }
+        refreshCountryStats();
+    }
+
+    // Sidebar list of our pins, grouped on the server and narrowed by the text box
+    var locationGroups = [];
+    function refreshLocationList() {
+        var by = document.getElementById('list-group').value;
+        var fq = filterQuery();
+        fetch('/api/locations/groups?by=' + by + (fq ? '&' + fq.slice(1) : ''))
+            .then(r => r.json())
+            .then(groups => {
+                locationGroups = groups;
+                renderLocationList();
+            })
+            .catch(err => console.log('location list error', err));
+    }
+
+    function renderLocationList() {
+        var q = document.getElementById('list-filter').value.trim().toLowerCase();
+        var box = document.getElementById('location-list');
+        box.innerHTML = '';
+        locationGroups.forEach(function(g) {
+            var items = g.locations.filter(function(loc) {
+                if (!q) return true;
+                var pl = loc.place || {};
+                return [g.label, loc.as, loc.asname, loc.country, loc.name, pl.city, pl.region]
+                    .some(v => v && v.toLowerCase().includes(q));
+            });
+            if (!items.length) return;
+            var h = document.createElement('div');
+            h.className = 'result-section';
+            h.textContent = g.label + ' (' + items.length + ')';
+            box.appendChild(h);
+            items.forEach(function(loc) {
+                var d = document.createElement('div');
+                d.className = 'result';
+                var where = loc.place && loc.place.city ? loc.place.city : loc.lat.toFixed(4) + ', ' + loc.lon.toFixed(4);
+                d.textContent = loc.as + ' ' + loc.asname + ' – ' + where;
+                d.onclick = function() { flyToLocation(loc); };
+                box.appendChild(d);
+            });
+        });
+    }
+
+    // viewBBox returns the view as south,west,north,east for ?bbox=. Longitudes of
+    // a wrapped world copy are brought back into -180..180 (west > east then
+    // means the view crosses the antimeridian).
+    function viewBBox() {
+        var b = map.getBounds();
+        var west = -180, east = 180;
+        if (b.getEast() - b.getWest() < 360) {
+            west = L.latLng(0, b.getWest()).wrap().lng;
+            east = L.latLng(0, b.getEast()).wrap().lng;
+        }
+        return [Math.max(b.getSouth(), -90), west, Math.min(b.getNorth(), 90), east].join(',');
+    }
+
+    // Density of locations or visitors, binned on the server for the current view
+    var densityLayer = L.layerGroup().addTo(map);
+    function densityColor(t) {
+        // yellow to red
+        return 'hsl(' + Math.round(60 - 60 * t) + ', 100%, ' + Math.round(60 - 15 * t) + '%)';
+    }
+
+    var densitySeq = 0; // responses of superseded refreshes (map moved again) are dropped
+    function refreshDensity() {
+        var seq = ++densitySeq;
+        var source = document.getElementById('density-source').value;
+        var style = document.getElementById('density-style').value;
+        var status = document.getElementById('density-status');
+        if (!source) {
+            densityLayer.clearLayers();
+            status.textContent = '';
+            return;
+        }
+        var q = '?source=' + source + '&bin=' + (style === 'square' ? 'square' : 'hex') + '&zoom=' + map.getZoom() +
+            '&bbox=' + viewBBox();
+        if (source === 'locations') {
+            var fq = filterQuery();
+            if (fq) q += '&' + fq.slice(1);
+        }
+        fetch('/api/density' + q)
+            .then(r => r.ok ? r.json() : r.text().then(t => Promise.reject(t)))
+            .then(d => {
+                if (seq !== densitySeq) return;
+                densityLayer.clearLayers();
+                d.bins.forEach(function(bin) {
+                    var t = d.max > 0 ? bin.weight / d.max : 0;
+                    var tip = bin.count + (bin.weight !== bin.count ? ' (weight ' + bin.weight + ')' : '');
+                    if (style === 'heat') {
+                        L.circle([bin.lat, bin.lon], {
+                            radius: d.cell_m * Math.cos(bin.lat * Math.PI / 180) * 0.75,
+                            stroke: false,
+                            fillColor: densityColor(t),
+                            fillOpacity: 0.15 + 0.45 * t,
+                            interactive: false
+                        }).addTo(densityLayer);
+                    } else {
+                        L.polygon(bin.ring, {
+                            color: densityColor(t),
+                            weight: 1,
+                            fillColor: densityColor(t),
+                            fillOpacity: 0.2 + 0.5 * t
+                        }).bindTooltip(tip).addTo(densityLayer);
+                    }
+                });
+                status.innerHTML = '<span style="color:' + densityColor(0) + '">■</span> 1 … ' +
+                    '<span style="color:' + densityColor(1) + '">■</span> ' + d.max + ' per cell, ' + d.total + ' in view';
+            })
+            .catch(err => {
+                if (seq !== densitySeq) return;
+                densityLayer.clearLayers();
+                status.textContent = String(err).trim();
+            });
+    }
+
+    // Countries coloured by the number of our locations or distinct ASNs in them
+    var countryLayer = L.layerGroup().addTo(map);
+    var countryColors = ['#dbe4ff', '#91a7ff', '#5c7cfa', '#3b5bdb', '#1c2f8f'];
+    function refreshCountryStats() {
+        var metric = document.getElementById('country-metric').value;
+        var legend = document.getElementById('country-legend');
+        if (!metric) {
+            countryLayer.clearLayers();
+            legend.innerHTML = '';
+            return;
+        }
+        var fq = filterQuery();
+        fetch('/api/stats/countries?format=geojson' + (fq ? '&' + fq.slice(1) : ''))
+            .then(r => r.ok ? r.json() : r.text().then(t => Promise.reject(t)))
+            .then(fc => {
+                var max = Math.max(1, ...fc.features.map(f => f.properties[metric]));
+                var steps = countryColors.map((c, i) => Math.ceil(max * (i + 1) / countryColors.length));
+                var colorOf = v => countryColors[steps.findIndex(s => v <= s)];
+                countryLayer.clearLayers();
+                L.geoJSON(fc, {
+                    style: f => ({ color: '#555', weight: 1, fillColor: colorOf(f.properties[metric]), fillOpacity: 0.6 }),
+                    onEachFeature: function(f, layer) {
+                        var p = f.properties;
+                        layer.bindTooltip(p.name + ': ' + p.locations + ' location(s), ' + p.asns + ' ASN(s)');
+                    }
+                }).addTo(countryLayer);
+                var prev = 0;
+                legend.innerHTML = steps.map(function(s, i) {
+                    if (s <= prev) return '';
+                    var range = prev + 1 === s ? String(s) : (prev + 1) + '–' + s;
+                    prev = s;
+                    return '<span style="color:' + countryColors[i] + '">■</span> ' + range;
+                }).filter(Boolean).join('<br>');
+            })
+            .catch(err => {
+                countryLayer.clearLayers();
+                legend.textContent = String(err).trim();
+            });
+    }
+
+    // Shaded area locations (polygons and multipolygons)
+    var areasLayer = L.layerGroup().addTo(map);
+    function refreshAreas() {
+        fetch('/api/areas' + filterQuery())
+            .then(r => r.json())
+            .then(list => {
+                areasLayer.clearLayers();
+                list.forEach(function(area) {
+                    L.geoJSON(area.geometry, {
+                        interactive: false,
+                        style: { color: '#e8590c', weight: 1, fillOpacity: 0.15 }
+                    }).addTo(areasLayer);
+                });
+            })
+            .catch(err => console.log('areas refresh error', err));
+    }
+
+    refreshAreas();
+
+    // AS interconnection links, drawn as great-circle lines styled by relationship
+    var linkStyles = {
+        transit: { color: '#e03131', dashArray: null },
+        peer: { color: '#2f9e44', dashArray: '6 6' },
+        customer: { color: '#1971c2', dashArray: '2 6' }
+    };
+    var linksLayer = L.layerGroup().addTo(map);
+    function refreshLinks() {
+        fetch('/api/links' + filterQuery())
+            .then(r => r.json())
+            .then(list => {
+                linksLayer.clearLayers();
+                list.forEach(function(link) {
+                    var style = linkStyles[link.type] || {};
+                    var weight = link.capacity_mbps ? Math.min(8, 1 + Math.log10(link.capacity_mbps)) : 2;
+                    L.polyline(link.path.map(p => [p.lat, p.lon]), {
+                        color: style.color,
+                        dashArray: style.dashArray,
+                        weight: weight,
+                        opacity: 0.8,
+                        bubblingMouseEvents: false
+                    }).bindPopup(
+                        "<b>" + link.type + "</b><br>" +
+                        link.from.as + " " + link.from.asname + " → " + link.to.as + " " + link.to.asname +
+                        (link.capacity ? "<br>capacity: " + link.capacity : "") +
+                        "<br>distance: " + link.distance_km.toFixed(1) + " km" +
+                        (link.details ? "<br>details: " + link.details : "")
+                    ).addTo(linksLayer);
+                });
+            })
+            .catch(err => console.log('links refresh error', err));
+    }
+
+    refreshLinks();
+
+    // PeeringDB facilities and IXPs, joined with the ASNs of our locations
+    var peeringDBLayer = L.layerGroup().addTo(map);
+    function peeringDBPopup(item, kind) {
+        var html = "<b>" + item.name + "</b> (" + kind + ")<br>" +
+            [item.city, item.country].filter(Boolean).join(", ");
+        if (item.our_asns.length) {
+            html += "<br><b>our ASNs:</b> " + item.our_asns.join(", ");
+        }
+        var places = kind === 'facility' ? item.ixps : item.facilities;
+        if (places.length) {
+            html += "<br>" + (kind === 'facility' ? "IXPs: " : "facilities: ") + places.slice(0, 10).join(", ") +
+                (places.length > 10 ? " …" : "");
+        }
+        html += "<br>networks: " + item.networks.length;
+        return html;
+    }
+    var peeringDBSeq = 0; // responses of superseded refreshes are dropped
+    function refreshPeeringDB() {
+        var seq = ++peeringDBSeq;
+        peeringDBLayer.clearLayers();
+        var status = document.getElementById('pdb-status');
+        var kinds = [];
+        if (document.getElementById('pdb-fac').checked) kinds.push(['facilities', 'facility', '#5f3dc4']);
+        if (document.getElementById('pdb-ix').checked) kinds.push(['ixps', 'IXP', '#0c8599']);
+        if (!kinds.length) {
+            status.textContent = '';
+            return;
+        }
+        var minOurs = document.getElementById('pdb-shared').value;
+        var q = '?min_ours=' + minOurs;
+        if (minOurs === '0') {
+            // the whole dump is too large to draw, only fetch what is in view
+            q += '&bbox=' + viewBBox();
+        }
+        kinds.forEach(function(k) {
+            fetch('/api/peeringdb/' + k[0] + q)
+                .then(r => r.ok ? r.json() : r.text().then(t => Promise.reject(t)))
+                .then(list => {
+                    if (seq !== peeringDBSeq) return;
+                    status.textContent = '';
+                    list.forEach(function(item) {
+                        L.circleMarker([item.lat, item.lon], {
+                            radius: item.our_asns.length ? 7 : 4,
+                            color: k[2],
+                            weight: item.our_asns.length ? 3 : 1,
+                            fillOpacity: 0.5,
+                            bubblingMouseEvents: false
+                        }).bindPopup(peeringDBPopup(item, k[1])).addTo(peeringDBLayer);
+                    });
+                })
+                .catch(err => {
+                    if (seq === peeringDBSeq) status.textContent = String(err).trim();
+                });
+        });
+    }
+
+    // Pasted IP addresses plotted as a temporary layer
+    var ipLayer = L.layerGroup().addTo(map);
+    function lookupIPs(format) {
+        return fetch('/api/ip/lookup' + (format ? '?format=' + format : ''), {
+            method: 'POST',
+            body: document.getElementById('ip-list').value
+        }).then(r => r.ok ? r : r.text().then(t => Promise.reject(t)));
+    }
+    function plotIPs() {
+        var status = document.getElementById('ip-status');
+        lookupIPs().then(r => r.json())
+            .then(list => {
+                ipLayer.clearLayers();
+                var found = list.filter(res => res.found);
+                found.forEach(function(res) {
+                    if (res.accuracy_km > 0) {
+                        L.circle([res.lat, res.lon], {
+                            radius: res.accuracy_km * 1000, interactive: false,
+                            color: '#f08c00', weight: 1, fillOpacity: 0.05
+                        }).addTo(ipLayer);
+                    }
+                    L.circleMarker([res.lat, res.lon], {
+                        radius: 6, color: '#f08c00', weight: 2, fillOpacity: 0.7, bubblingMouseEvents: false
+                    }).bindPopup(
+                        "<b>" + res.ip + "</b>" +
+                        ([res.city, res.country].filter(Boolean).length ? "<br>" + [res.city, res.country].filter(Boolean).join(", ") : "") +
+                        (res.asn ? "<br>AS" + res.asn + " " + (res.as_org || "") : "") +
+                        (res.network ? "<br>network: " + res.network : "") +
+                        "<br>accuracy: " + res.accuracy_km + " km"
+                    ).addTo(ipLayer);
+                });
+                status.textContent = found.length + " of " + list.length + " located" +
+                    (found.length < list.length ? " (not found: " + list.filter(res => !res.found).map(res => res.ip).slice(0, 5).join(", ") + ")" : "");
+                if (found.length) {
+                    map.fitBounds(found.map(res => [res.lat, res.lon]), { padding: [30, 30], maxZoom: 10 });
+                }
+            })
+            .catch(err => { status.textContent = String(err).trim(); });
+    }
+    function clearIPs() {
+        ipLayer.clearLayers();
+        document.getElementById('ip-status').textContent = '';
+    }
+    function exportIPs(format) {
+        lookupIPs(format).then(r => r.blob())
+            .then(blob => {
+                var a = document.createElement('a');
+                a.href = URL.createObjectURL(blob);
+                a.download = 'ip-lookup.' + format;
+                a.click();
+                URL.revokeObjectURL(a.href);
+            })
+            .catch(err => { document.getElementById('ip-status').textContent = String(err).trim(); });
     }
 
+    map.on('moveend', function() {
+        if (document.getElementById('pdb-shared').value === '0') {
+            refreshPeeringDB();
+        }
+        refreshDensity();
+    });
+
     setInterval(refreshLocations, 10000); // every 10s
 
     // Click event to get coordinates
     map.on('click', function(e) {
+        if (measureMode) {
+            addMeasurePoint(e.latlng);
+            return;
+        }
+
         var clickedLat = e.latlng.lat.toFixed(6);
         var clickedLon = e.latlng.lng.toFixed(6);
 
@@ -250,24 +757,155 @@ var tpl = template.Must(template.New("page").Parse(`
             .bindPopup("Clicked Location: " + clickedLat + ", " + clickedLon)
             .openPopup();
         updateShareURL(clickedLat, clickedLon);
+        clickSections = {};
+        showAddress(clickedLat, clickedLon);
+        showNearest(clickedLat, clickedLon);
+        showContaining(clickedLat, clickedLon);
+        showProjections(clickedLat, clickedLon);
     });
 
-    function searchPlace(){
+    // Clicked-location popup, extended asynchronously with extra sections
+    var clickSections = {};
+    var clickSectionOrder = ['address', 'inside', 'nearest', 'crs'];
+    function setClickSection(name, latVal, lonVal, html) {
+        var p = marker.getLatLng();
+        if (p.lat.toFixed(6) !== latVal || p.lng.toFixed(6) !== lonVal) return; // stale response
+        clickSections[name] = html;
+        var out = "Clicked Location: " + latVal + ", " + lonVal;
+        Object.keys(clickSections)
+            .sort((a, b) => clickSectionOrder.indexOf(a) - clickSectionOrder.indexOf(b))
+            .forEach(k => out += "<br>" + clickSections[k]);
+        marker.setPopupContent(out);
+    }
+
+    function escapeHTML(s) {
+        return String(s).replace(/[&<>"]/g, c => ({'&':'&amp;','<':'&lt;','>':'&gt;','"':'&quot;'})[c]);
+    }
+
+    // "What's here": the address of the clicked point from the reverse geocoder,
+    // also used as the label of the share URL
+    function showAddress(latVal, lonVal) {
+        fetch('/proxy/reverse?lat=' + latVal + '&lon=' + lonVal + '&zoom=' + Math.min(18, Math.max(10, map.getZoom())))
+            .then(r => r.ok ? r.json() : Promise.reject(r.status))
+            .then(res => {
+                if (!res.display_name) return;
+                setClickSection('address', latVal, lonVal, "<b>address:</b> " + escapeHTML(res.display_name));
+                var p = marker.getLatLng();
+                if (p.lat.toFixed(6) !== latVal || p.lng.toFixed(6) !== lonVal) return;
+                shareLabel = { lat: latVal, lon: lonVal, text: res.display_name };
+                updateShareURL(latVal, lonVal);
+            })
+            .catch(err => console.log('reverse geocoding error', err));
+    }
+
+    // Names the areas containing the clicked point
+    function showContaining(latVal, lonVal) {
+        fetch('/api/geo/contains?lat=' + latVal + '&lon=' + lonVal + filterQuery().replace('?', '&'))
+            .then(r => r.json())
+            .then(list => {
+                if (!list.length) return;
+                setClickSection('inside', latVal, lonVal, "<b>inside:</b> " + list.map(a => a.name).join(", "));
+            })
+            .catch(err => console.log('contains error', err));
+    }
+
+    // Shows the clicked point in every supported CRS (UTM, MGRS, PL-1992/2000, ...)
+    function showProjections(latVal, lonVal) {
+        fetch('/api/convert?coord=' + latVal + ',' + lonVal)
+            .then(r => r.json())
+            .then(res => {
+                var html = "<b>coordinates:</b>";
+                res.results.filter(p => p.crs !== 'EPSG:4326').forEach(function(p) {
+                    html += "<br>" + p.name + ": " + p.text;
+                });
+                setClickSection('crs', latVal, lonVal, html);
+            })
+            .catch(err => console.log('convert error', err));
+    }
+
+    // Lists the three closest known locations in the clicked-location popup
+    function showNearest(latVal, lonVal) {
+        fetch('/api/locations/nearest?k=3&lat=' + latVal + '&lon=' + lonVal + filterQuery().replace('?', '&'))
+            .then(r => r.json())
+            .then(hits => {
+                if (!hits.length) return;
+                var html = "<b>nearest:</b>";
+                hits.forEach(function(h) {
+                    html += "<br>" + h.location.as + " " + h.location.asname + " – " +
+                        h.distance_km.toFixed(1) + " km, " + Math.round(h.bearing) + "°";
+                });
+                setClickSection('nearest', latVal, lonVal, html);
+            })
+            .catch(err => console.log('nearest error', err));
+    }
+
+    // Search our pins and the geocoder, listing both in separate sections
+    function searchPlace() {
         var q = document.getElementById('search').value.trim();
-        if(!q) return alert("Enter a place.");
-        fetch('https://nominatim.openstreetmap.org/search?format=json&q=' + encodeURIComponent(q))
-          .then(r=>r.json())
-          .then(data=>{
-              if(!data.length) return alert("Not found.");
-              var newLat = parseFloat(data[0].lat);
-              var newLon = parseFloat(data[0].lon);
-              document.getElementById('lat').value = newLat.toFixed(6);
-              document.getElementById('lon').value = newLon.toFixed(6);
-              map.setView([newLat, newLon], 13);
-              marker.setLatLng([newLat, newLon]).bindPopup("Searched: " + data[0].display_name).openPopup();
-              updateShareURL(newLat.toFixed(6), newLon.toFixed(6));
-          })
-          .catch(err=>alert("Search error: " + err.message));
+        if (!q) return alert("Enter a place.");
+        var pins = fetch('/api/locations/search?q=' + encodeURIComponent(q))
+            .then(r => r.ok ? r.json() : [])
+            .catch(() => []);
+        var busy = false;
+        var places = fetch('/proxy/nominatim?q=' + encodeURIComponent(q))
+            .then(r => {
+                busy = r.status === 429;
+                return r.ok ? r.json() : [];
+            })
+            .catch(() => []);
+        Promise.all([pins, places])
+            .then(function(res) {
+                renderSearchResults(res[0], res[1]);
+                if (res[0].length) {
+                    flyToLocation(res[0][0].location);
+                } else if (res[1].length) {
+                    showPlace(res[1][0]);
+                } else {
+                    alert(busy ? "Geocoder busy, try again in a moment." : "Not found.");
+                }
+            });
+    }
+
+    function showPlace(place) {
+        var newLat = parseFloat(place.lat);
+        var newLon = parseFloat(place.lon);
+        document.getElementById('lat').value = newLat.toFixed(6);
+        document.getElementById('lon').value = newLon.toFixed(6);
+        map.setView([newLat, newLon], 13);
+        marker.setLatLng([newLat, newLon]).bindPopup("Searched: " + escapeHTML(place.display_name)).openPopup();
+        shareLabel = { lat: newLat.toFixed(6), lon: newLon.toFixed(6), text: place.display_name };
+        updateShareURL(newLat.toFixed(6), newLon.toFixed(6));
+    }
+
+    // flyToLocation centres the map on a known location and opens its pin popup
+    function flyToLocation(location) {
+        map.flyTo([location.lat, location.lon], Math.max(map.getZoom(), 13));
+        dynamicMarkers.forEach(function(m) {
+            var p = m.getLatLng();
+            // several networks can share a position, prefer the marker of this one
+            if (p.lat === location.lat && p.lng === location.lon && (!m.location || m.location.as === location.as)) m.openPopup();
+        });
+        updateShareURL(location.lat.toFixed(6), location.lon.toFixed(6));
+    }
+
+    function renderSearchResults(hits, places) {
+        var box = document.getElementById('search-results');
+        box.innerHTML = '';
+        function section(title, items, label, onClick) {
+            var h = document.createElement('div');
+            h.className = 'result-section';
+            h.textContent = title + ' (' + items.length + ')';
+            box.appendChild(h);
+            items.forEach(function(item) {
+                var d = document.createElement('div');
+                d.className = 'result';
+                d.textContent = label(item);
+                d.onclick = function() { onClick(item); };
+                box.appendChild(d);
+            });
+        }
+        section('Our locations', hits, h => h.location.as + ' ' + h.location.asname, h => flyToLocation(h.location));
+        section('Places', places.slice(0, 5), p => p.display_name, showPlace);
     }
 
     function updateMap() {
@@ -284,24 +922,24 @@ var tpl = template.Must(template.New("page").Parse(`
         }
     }
 
+    // Parse the text box on the server: decimal, DMS, DDM, geo: URI, geohash or plus code
     function updateMapFromText() {
         var input = document.getElementById('coord').value.trim();
-        var parts = input.split(",");
+        if (!input) return;
 
-        if (parts.length === 2) {
-            var newLat = parseFloat(parts[0]);
-            var newLon = parseFloat(parts[1]);
-
-            if (!isNaN(newLat) && !isNaN(newLon)) {
-                document.getElementById('lat').value = newLat;
-                document.getElementById('lon').value = newLon;
+        fetch('/api/parse-coordinate?q=' + encodeURIComponent(input))
+            .then(function(resp) {
+                if (!resp.ok) return resp.text().then(function(t) { throw new Error(t.trim()); });
+                return resp.json();
+            })
+            .then(function(c) {
+                document.getElementById('lat').value = c.lat.toFixed(6);
+                document.getElementById('lon').value = c.lon.toFixed(6);
                 updateMap();
-            } else {
-                alert("Invalid format. Use lat,lon (e.g. 12.34,56.78).");
-            }
-        } else {
-            alert("Invalid format. Use lat,lon (e.g. 12.34,56.78).");
-        }
+            })
+            .catch(function(err) {
+                alert("Invalid coordinate: " + err.message);
+            });
     }
 
     // Find user's current location
@@ -357,8 +995,194 @@ var tpl = template.Must(template.New("page").Parse(`
         }
     }
 
+    // Measuring tool: map clicks add vertices, the server measures on the WGS84 ellipsoid
+    var measureMode = null;
+    var measurePoints = [];
+    var measureLayer = null;
+
+    function startMeasure(mode) {
+        stopMeasure();
+        measureMode = mode;
+        document.getElementById('measure-result').textContent = 'Click the map to add points.';
+    }
+
+    function stopMeasure() {
+        measureMode = null;
+        measurePoints = [];
+        if (measureLayer) {
+            map.removeLayer(measureLayer);
+            measureLayer = null;
+        }
+        document.getElementById('measure-result').textContent = '';
+    }
+
+    function addMeasurePoint(latlng) {
+        var mode = measureMode;
+        measurePoints.push({ lat: latlng.lat, lon: latlng.lng });
+        var latlngs = measurePoints.map(p => [p.lat, p.lon]);
+        if (measureLayer) map.removeLayer(measureLayer);
+        measureLayer = (mode === 'area' ? L.polygon(latlngs) : L.polyline(latlngs)).addTo(map);
+        if (measurePoints.length < (mode === 'area' ? 3 : 2)) return;
+        fetch('/api/geo/' + mode, { method: 'POST', body: JSON.stringify({ points: measurePoints }) })
+            .then(r => r.json())
+            .then(function(res) {
+                if (measureMode !== mode) return;
+                var out = document.getElementById('measure-result');
+                if (mode === 'area') {
+                    out.innerHTML = 'Area: ' + formatArea(res.area_m2) + '<br>Perimeter: ' + formatLength(res.perimeter_m);
+                } else {
+                    out.innerHTML = 'Length: ' + formatLength(res.length_m) +
+                        '<br>Bearing: ' + res.initial_bearing.toFixed(1) + '° → ' + res.final_bearing.toFixed(1) + '°' +
+                        '<br>Midpoint: ' + res.midpoint.lat.toFixed(6) + ', ' + res.midpoint.lon.toFixed(6);
+                }
+            })
+            .catch(err => console.log('measure error', err));
+    }
+
+    function formatLength(m) {
+        return m >= 1000 ? (m / 1000).toFixed(3) + ' km' : m.toFixed(1) + ' m';
+    }
+
+    function formatArea(m2) {
+        return m2 >= 1e6 ? (m2 / 1e6).toFixed(3) + ' km²' : m2.toFixed(1) + ' m²';
+    }
+
+    // Timeline slider: replays the footprint between the earliest and latest validity bound
+    var DAY_MS = 86400000;
+    var timelineTimer = null;
+
+    function timelineStart() {
+        return Date.parse(locationFilter.time_range.from);
+    }
+
+    function initTimeline() {
+        var tr = locationFilter.time_range;
+        if (!tr.from) {
+            document.getElementById('timeline-block').style.display = 'none';
+            return;
+        }
+        var slider = document.getElementById('timeline');
+        var end = Math.max(Date.parse(tr.to), Date.now());
+        slider.max = Math.ceil((end - timelineStart()) / DAY_MS);
+        if (locationFilter.active.at) {
+            slider.value = Math.round((Date.parse(locationFilter.active.at) - timelineStart()) / DAY_MS);
+            document.getElementById('timeline-label').textContent = locationFilter.active.at.slice(0, 10);
+        } else {
+            slider.value = slider.max;
+        }
+    }
+
+    function onTimeline() {
+        var day = parseInt(document.getElementById('timeline').value, 10);
+        var at = new Date(timelineStart() + day * DAY_MS).toISOString().slice(0, 10);
+        locationFilter.active.at = at;
+        document.getElementById('timeline-label').textContent = at;
+        refreshLocations();
+        var p = marker.getLatLng();
+        updateShareURL(p.lat.toFixed(6), p.lng.toFixed(6));
+    }
+
+    function stopTimeline() {
+        if (timelineTimer) clearInterval(timelineTimer);
+        timelineTimer = null;
+    }
+
+    function playTimeline() {
+        stopTimeline();
+        var slider = document.getElementById('timeline');
+        var step = Math.max(1, Math.ceil(slider.max / 60));
+        slider.value = 0;
+        onTimeline();
+        timelineTimer = setInterval(function() {
+            if (+slider.value >= +slider.max) return stopTimeline();
+            slider.value = Math.min(+slider.max, +slider.value + step);
+            onTimeline();
+        }, 500);
+    }
+
+    function clearTimeline() {
+        stopTimeline();
+        delete locationFilter.active.at;
+        var slider = document.getElementById('timeline');
+        slider.value = slider.max;
+        document.getElementById('timeline-label').textContent = 'All time';
+        refreshLocations();
+        var p = marker.getLatLng();
+        updateShareURL(p.lat.toFixed(6), p.lng.toFixed(6));
+    }
+
+    // Tag & category filter, kept in the share URL
+    function filterQuery() {
+        var params = new URLSearchParams();
+        locationFilter.active.tags.forEach(t => params.append('tag', t));
+        locationFilter.active.exclude_tags.forEach(t => params.append('tag', '!' + t));
+        locationFilter.active.categories.forEach(c => params.append('category', c));
+        locationFilter.active.countries.forEach(c => params.append('country', c));
+        if (locationFilter.active.at) params.set('at', locationFilter.active.at.slice(0, 10));
+        var qs = params.toString();
+        return qs ? '?' + qs : '';
+    }
+
+    function renderFilterPanel() {
+        var active = locationFilter.active;
+        var tagsInput = document.getElementById('filter-tags');
+        tagsInput.value = active.tags.concat(active.exclude_tags.map(t => '!' + t)).join(', ');
+        if (locationFilter.tags.length) {
+            tagsInput.title = 'Known tags: ' + locationFilter.tags.join(', ');
+        }
+        var select = document.getElementById('filter-category');
+        locationFilter.categories.forEach(function(c) {
+            var opt = document.createElement('option');
+            opt.value = c;
+            opt.text = c;
+            select.appendChild(opt);
+        });
+        select.value = active.categories.length ? active.categories[0] : '';
+        var countrySelect = document.getElementById('filter-country');
+        locationFilter.countries.forEach(function(c) {
+            var opt = document.createElement('option');
+            opt.value = c.code;
+            opt.text = c.name;
+            countrySelect.appendChild(opt);
+        });
+        countrySelect.value = active.countries.length ? active.countries[0] : '';
+    }
+
+    function applyFilter() {
+        var tags = [], excludeTags = [];
+        document.getElementById('filter-tags').value.split(',').forEach(function(t) {
+            t = t.trim().toLowerCase();
+            if (t.startsWith('!')) {
+                t = t.slice(1).trim();
+                if (t) excludeTags.push(t);
+            } else if (t) {
+                tags.push(t);
+            }
+        });
+        var category = document.getElementById('filter-category').value;
+        var country = document.getElementById('filter-country').value;
+        locationFilter.active = { tags: tags, exclude_tags: excludeTags, categories: category ? [category] : [], countries: country ? [country] : [], at: locationFilter.active.at };
+        refreshLocations();
+        var p = marker.getLatLng();
+        updateShareURL(p.lat.toFixed(6), p.lng.toFixed(6));
+    }
+
+    function clearFilter() {
+        document.getElementById('filter-tags').value = '';
+        document.getElementById('filter-category').value = '';
+        document.getElementById('filter-country').value = '';
+        applyFilter();
+    }
+
+    renderFilterPanel();
+    initTimeline();
+    refreshLocationList();
+
     function updateShareURL(latVal, lonVal){
-        document.getElementById('share-url').value = location.origin + "?lat=" + latVal + "&lon=" + lonVal;
+        var fq = filterQuery();
+        var label = shareLabel.text && shareLabel.lat === (+latVal).toFixed(6) && shareLabel.lon === (+lonVal).toFixed(6)
+            ? "&label=" + encodeURIComponent(shareLabel.text) : "";
+        document.getElementById('share-url').value = location.origin + "?lat=" + latVal + "&lon=" + lonVal + label + (fq ? "&" + fq.slice(1) : "");
     }
 
     function copyShare(){
@@ -371,3 +1195,132 @@ var tpl = template.Must(template.New("page").Parse(`
 </body>
 </html>
 `))
+
+var tpl_trace = template.Must(template.New("trace").Parse(`
+<!DOCTYPE html>
+<html>
+<head>
+    <title>osm - trace</title>
+    <link rel="icon" href="web/pepe.png" type="image/png" sizes="16x16">
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <link rel="stylesheet" href="https://unpkg.com/leaflet/dist/leaflet.css">
+    <script src="https://unpkg.com/leaflet/dist/leaflet.js"></script>
+    <style>
+        body { margin:0; font-family:Arial, sans-serif; background:#f5f5f5; color:#111; }
+        #layout { display:flex; height:100vh; width:100vw; }
+        #sidebar {
+            width:420px; background:#fff; display:flex; flex-direction:column;
+            padding:12px; box-sizing:border-box; gap:10px; overflow-y:auto;
+        }
+        h2 { margin:0; font-size:15px; font-weight:600; }
+        textarea { width:100%; height:180px; font-family:monospace; font-size:11px; box-sizing:border-box; }
+        button { font-size:13px; padding:6px 8px; cursor:pointer; }
+        table { border-collapse:collapse; font-size:12px; width:100%; }
+        th, td { text-align:left; padding:3px 4px; border-bottom:1px solid #eee; }
+        tr.as-change td { border-top:2px solid #e03131; }
+        tr.hop-row { cursor:pointer; }
+        tr.hop-row:hover { background:#ececec; }
+        #status { font-size:12px; color:#c92a2a; }
+        #map { flex:1; }
+    </style>
+</head>
+<body>
+<div id="layout">
+    <div id="sidebar">
+        <div><a href="/">← map</a></div>
+        <h2>Traceroute / mtr --json output</h2>
+        <textarea id="trace" placeholder="traceroute to example.com (93.184.216.34), 30 hops max
+ 1  192.168.1.1  0.512 ms  0.433 ms  0.401 ms
+ 2  * * *"></textarea>
+        <button onclick="submitTrace()">Show path</button>
+        <div id="status"></div>
+        <table id="hops"></table>
+    </div>
+    <div id="map"></div>
+</div>
+<script>
+    var map = L.map('map').setView([52, 19], 4);
+    L.tileLayer('https://{s}.tile.openstreetmap.org/{z}/{x}/{y}.png', { attribution: '© OpenStreetMap contributors' }).addTo(map);
+    var traceLayer = L.layerGroup().addTo(map);
+    var hopMarkers = {};
+
+    function esc(s) {
+        return String(s).replace(/[&<>"]/g, c => ({'&':'&amp;','<':'&lt;','>':'&gt;','"':'&quot;'})[c]);
+    }
+
+    // RTT colour scale: green (fast) to red (slow)
+    function rttColor(ms) {
+        var t = Math.min(1, (ms || 0) / 200);
+        return 'hsl(' + Math.round(120 * (1 - t)) + ',70%,45%)';
+    }
+
+    function hopPopup(hop) {
+        return "<b>hop " + hop.hop + "</b> " + esc(hop.ip || '*') +
+            (hop.host ? "<br>" + esc(hop.host) : "") +
+            (hop.as ? "<br>" + esc(hop.as) + " " + esc(hop.asname || '') : "") +
+            ([hop.city, hop.country].filter(Boolean).length ? "<br>" + esc([hop.city, hop.country].filter(Boolean).join(', ')) : "") +
+            "<br>avg RTT: " + hop.avg_rtt_ms.toFixed(1) + " ms" +
+            (hop.loss_pct != null ? "<br>loss: " + hop.loss_pct.toFixed(1) + "%" : "") +
+            (hop.location ? "<br><b>known location</b> " + esc(hop.location.as) + " " + esc(hop.location.details || '') : "") +
+            (hop.as_change ? "<br><b>AS change</b>" : "");
+    }
+
+    function submitTrace() {
+        var status = document.getElementById('status');
+        status.textContent = '';
+        fetch('/api/trace', { method: 'POST', body: document.getElementById('trace').value })
+            .then(r => r.ok ? r.json() : r.text().then(t => Promise.reject(t)))
+            .then(renderTrace)
+            .catch(err => { status.textContent = String(err).trim(); });
+    }
+
+    function renderTrace(res) {
+        traceLayer.clearLayers();
+        hopMarkers = {};
+        var rows = "<tr><th>#</th><th>IP</th><th>AS</th><th>RTT</th><th>where</th></tr>";
+        var prev = null;
+        res.hops.forEach(function(hop) {
+            rows += "<tr class=\"hop-row" + (hop.as_change ? " as-change" : "") + "\" onclick=\"focusHop(" + hop.hop + ")\">" +
+                "<td>" + hop.hop + "</td><td>" + esc(hop.ip || '*') + "</td><td>" + esc(hop.as || '') + "</td>" +
+                "<td>" + (hop.rtt_ms.length ? hop.avg_rtt_ms.toFixed(1) : '') + "</td>" +
+                "<td>" + (hop.location ? "📍 " : "") + esc([hop.city, hop.country].filter(Boolean).join(', ')) + "</td></tr>";
+            if (!hop.has_location) {
+                return;
+            }
+            if (prev) {
+                L.polyline([[prev.lat, prev.lon], [hop.lat, hop.lon]], {
+                    color: rttColor(hop.avg_rtt_ms), weight: 3, opacity: 0.8
+                }).addTo(traceLayer);
+            }
+            var m = hop.location
+                ? L.marker([hop.lat, hop.lon])
+                : L.circleMarker([hop.lat, hop.lon], {
+                    radius: hop.as_change ? 8 : 5,
+                    color: hop.as_change ? '#e03131' : '#495057',
+                    weight: hop.as_change ? 3 : 1,
+                    fillColor: rttColor(hop.avg_rtt_ms), fillOpacity: 0.9
+                });
+            m.bindPopup(hopPopup(hop)).addTo(traceLayer);
+            hopMarkers[hop.hop] = m;
+            prev = hop;
+        });
+        document.getElementById('hops').innerHTML = rows;
+        if (res.path.length) {
+            map.fitBounds(res.path.map(p => [p.lat, p.lon]), { padding: [30, 30], maxZoom: 10 });
+        } else {
+            document.getElementById('status').textContent = 'No hop could be geolocated';
+        }
+    }
+
+    function focusHop(n) {
+        var m = hopMarkers[n];
+        if (m) {
+            map.setView(m.getLatLng(), Math.max(map.getZoom(), 8));
+            m.openPopup();
+        }
+    }
+</script>
+</body>
+</html>
+`))